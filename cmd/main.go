@@ -3,10 +3,13 @@ package main
 import(
 	"time"
 	"os"
+	"flag"
+	"fmt"
+	"encoding/json"
 	"strings"
 	"strconv"
 	"net"
-	"io/ioutil"
+	"errors"
 	"context"
 	"crypto/tls"
 
@@ -14,70 +17,44 @@ import(
 	"github.com/rs/zerolog/log"
 
 	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
-    "github.com/aws/aws-sdk-go-v2/config"
+    awsconfig "github.com/aws/aws-sdk-go-v2/config"
 
 	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+	"github.com/go-rest-balance-charges/internal/config"
 	"github.com/go-rest-balance-charges/internal/handler"
 	"github.com/go-rest-balance-charges/internal/core"
 	"github.com/go-rest-balance-charges/internal/service"
+	"github.com/go-rest-balance-charges/internal/repository"
 	"github.com/go-rest-balance-charges/internal/repository/postgre"
+	"github.com/go-rest-balance-charges/internal/repository/memory"
 	"github.com/go-rest-balance-charges/internal/repository/cache"
 	"github.com/go-rest-balance-charges/internal/adapter/restapi"
-	redis "github.com/redis/go-redis/v9"
+	"github.com/go-rest-balance-charges/internal/adapter/event"
+	"github.com/go-rest-balance-charges/internal/adapter/fx"
+	"github.com/go-rest-balance-charges/internal/validation"
+	"github.com/go-rest-balance-charges/internal/auth"
+	"github.com/go-rest-balance-charges/internal/metrics"
+	"github.com/go-rest-balance-charges/internal/tracing"
+	"github.com/go-rest-balance-charges/internal/health"
 	
 )
 
 var(
 	logLevel 	= zerolog.DebugLevel
 	version 	= "GO CRUD BALANCE_CHARGE 1.0"
-	serverUrlDomain	string
-	path			string
-	noAZ		=	true // set only if you get to split the xray trace per AZ
 
+	appConfig				*config.Config
 	infoPod					core.InfoPod
-	envDB	 				core.DatabaseRDS
 	httpAppServerConfig 	core.HttpAppServer
-	server					core.Server
 	dataBaseHelper 			db_postgre.DatabaseHelper
-	repoDB					db_postgre.WorkerRepository
-	restApiBalance			restapi.RestApiSConfig
-	cache					cache_redis.CacheService
-	envCacheCluster			redis.ClusterOptions
-	envCache				redis.Options
+	repoDB					repository.ChargeRepository
+	cache					cache_redis.Cache
 )
 
 func init(){
 	log.Debug().Msg("init")
 	zerolog.SetGlobalLevel(logLevel)
 
-	// Just for easy test
-	envDB.Host = "127.0.0.1" //"host.docker.internal"
-	envDB.Port = "5432"
-	envDB.Schema = "public"
-	envDB.DatabaseName = "postgres"
-	//envDB.User  = "postgres"
-	//envDB.Password  = "pass123"
-	serverUrlDomain 	= "http://localhost:5000"
-	path				= "/get"
-	envDB.Db_timeout = 90
-	envDB.Postgres_Driver = "postgres"
-	server.Port = 5001
-
-	envCacheCluster.Username = ""
-	envCacheCluster.Password = ""
-	envCacheCluster.Addrs = strings.Split("clustercfg.memdb-arch.vovqz2.memorydb.us-east-2.amazonaws.com:6379", ",")
-
-	envCache.Username = ""
-	envCache.Password = ""
-	envCache.Addr = "127.0.0.1:6379"
-	envCache.DB	= 0
-	//Just for easy test
-
-	server.ReadTimeout = 60
-	server.WriteTimeout = 60
-	server.IdleTimeout = 60
-	server.CtxTimeout = 60
-
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		log.Error().Err(err).Msg("Error to get the POD IP address !!!")
@@ -91,153 +68,308 @@ func init(){
 		}
 	}
 	infoPod.OSPID = strconv.Itoa(os.Getpid())
+}
 
-	file_user, err := ioutil.ReadFile("/var/pod/secret/username")
-    if err != nil {
-        log.Error().Err(err).Msg("ERRO FATAL recuperacao secret-user")
+// getAvailabilityZone asks the EC2 metadata, only when noAZ is false (the xray trace is
+// split per AZ)
+func getAvailabilityZone(noAZ bool) string {
+	if noAZ {
+		return "LOCALHOST_NO_AZ"
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Error().Err(err).Msg("ERRO FATAL get Context !!!")
+		os.Exit(3)
+	}
+	client := imds.NewFromConfig(cfg)
+	response, err := client.GetInstanceIdentityDocument(context.TODO(), &imds.GetInstanceIdentityDocumentInput{})
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to retrieve the region from the EC2 instance !!!")
 		os.Exit(3)
-    }
-	file_pass, err := ioutil.ReadFile("/var/pod/secret/password")
-    if err != nil {
-        log.Error().Err(err).Msg("ERRO FATAL recuperacao secret-pass")
+	}
+	return response.AvailabilityZone
+}
+
+func main() {
+	log.Debug().Msg("main")
+
+	// The defaults, then the config file, the env vars and the flags, the arguments left
+	// are the subcommand
+	var args []string
+	var err error
+	appConfig, args, err = config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("ERRO FATAL na configuração")
+		os.Exit(3)
+	}
+
+	// config prints the effective configuration and exits
+	if len(args) > 0 && args[0] == "config" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(appConfig.Redacted())
+		os.Exit(0)
+	}
+	log.Info().Interface("config", appConfig.Redacted()).Msg("Effective configuration")
+
+	infoPod.ApiVersion = appConfig.ApiVersion
+	infoPod.PodName = appConfig.PodName
+	infoPod.AvailabilityZone = getAvailabilityZone(appConfig.NoAZ)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration( appConfig.Server.ReadTimeout ) * time.Second)
+	defer cancel()
+
+	tracer, err := tracing.NewTracer(ctx, appConfig.Tracing.TracingConfig())
+	if err != nil {
+		log.Error().Err(err).Msg("ERRO FATAL na criação do tracer")
 		os.Exit(3)
-    }
-	envDB.User = string(file_user)
-	envDB.Password = string(file_pass)
+	}
+	tracing.SetTracer(tracer)
 
-	getEnv()
+	switch appConfig.Repository {
+	case "memory":
+		log.Info().Msg("Using the memory repository, data is lost on restart")
+		if len(args) > 0 && args[0] == "migrate" {
+			log.Error().Msg("migrate needs REPOSITORY=postgres")
+			os.Exit(1)
+		}
+		repoDB = db_memory.NewWorkerRepository()
+	case "postgres":
+		count := 1
+		for {
+			dataBaseHelper, err = db_postgre.NewDatabaseHelper(ctx, appConfig.Database.DatabaseRDS())
+			if err != nil {
+				if count < 3 {
+					log.Error().Err(err).Msg("Erro na abertura do Database")
+				} else {
+					log.Error().Err(err).Msg("ERRO FATAL na abertura do Database aborting")
+					panic(err)	
+				}
+				time.Sleep(3 * time.Second)
+				count = count + 1
+				continue
+			}
+			break
+		}
 
-	// Get AZ only if localtest is true
-	if (noAZ != true) {
-		cfg, err := config.LoadDefaultConfig(context.TODO())
+		migrator, err := db_postgre.NewMigrator(dataBaseHelper)
 		if err != nil {
-			log.Error().Err(err).Msg("ERRO FATAL get Context !!!")
+			log.Error().Err(err).Msg("ERRO FATAL na leitura das migrations")
 			os.Exit(3)
 		}
-		client := imds.NewFromConfig(cfg)
-		response, err := client.GetInstanceIdentityDocument(context.TODO(), &imds.GetInstanceIdentityDocumentInput{})
+		// migrate up|down [-steps n]|status runs on the database and exits
+		if len(args) > 0 && args[0] == "migrate" {
+			os.Exit(runMigrate(migrator, args[1:]))
+		}
+		if appConfig.Database.MigrateOnStartup {
+			count, err := migrator.Up(context.Background())
+			if err != nil {
+				log.Error().Err(err).Msg("ERRO FATAL na aplicação das migrations")
+				os.Exit(3)
+			}
+			log.Info().Int("applied", count).Int("version", migrator.Latest()).Msg("Migrations")
+		}
+		err = migrator.Check(ctx)
 		if err != nil {
-			log.Error().Err(err).Msg("Unable to retrieve the region from the EC2 instance !!!")
+			log.Error().Err(err).Msg("ERRO FATAL schema do banco desatualizado")
 			os.Exit(3)
 		}
-		infoPod.AvailabilityZone = response.AvailabilityZone	
-	} else {
-		infoPod.AvailabilityZone = "LOCALHOST_NO_AZ"
-	}
-}
 
-func getEnv() {
-	log.Debug().Msg("getEnv")
+		metrics.RegisterDB(dataBaseHelper.GetConnection(), appConfig.Database.DatabaseName)
+		repoDB = db_postgre.NewWorkerRepository(dataBaseHelper)
+	default:
+		log.Error().Str("repository", appConfig.Repository).Msg("ERRO FATAL repositório desconhecido (postgres|memory)")
+		os.Exit(3)
+	}
 
-	if os.Getenv("API_VERSION") !=  "" {
-		infoPod.ApiVersion = os.Getenv("API_VERSION")
+	switch appConfig.Redis.Mode {
+	case "memory":
+		log.Info().Msg("Using the memory cache, pending amounts are not shared between pods")
+		cache = cache_redis.NewMemoryCache(ctx)
+	case "single":
+		envCache := appConfig.Redis.Options()
+		if !strings.Contains(envCache.Addr, "127.0.0.1") {
+			envCache.TLSConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		cache = cache_redis.NewCache(ctx, envCache)
+	case "cluster":
+		envCacheCluster := appConfig.Redis.ClusterOptions()
+		if !strings.Contains(envCacheCluster.Addrs[0], "127.0.0.1") {
+			log.Debug().Msg("tls ok")
+			envCacheCluster.TLSConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		cache = cache_redis.NewClusterCache(ctx, envCacheCluster)
+	default:
+		log.Error().Str("redis_mode", appConfig.Redis.Mode).Msg("ERRO FATAL modo do Redis desconhecido (cluster|single|memory)")
+		os.Exit(3)
 	}
-	if os.Getenv("POD_NAME") !=  "" {
-		infoPod.PodName = os.Getenv("POD_NAME")
+	_, err = cache.Ping(ctx)
+	if err != nil{
+		log.Error().Err(err).Msg("Erro na abertura do Redis")
 	}
 
-	if os.Getenv("PORT") !=  "" {
-		intVar, _ := strconv.Atoi(os.Getenv("PORT"))
-		server.Port = intVar
+	// A breaker per dependency, so one failing does not open the others
+	breakers := circuitbreaker.NewRegistry()
+	metrics.RegisterBreakers(breakers)
+	repoDB = repository.NewBreakerRepository(repoDB, breakers.Register("postgres", appConfig.Breakers.Postgres.Settings(), repository.BreakerSuccess))
+	cache = cache_redis.NewBreakerCache(cache, breakers.Register("redis", appConfig.Breakers.Redis.Settings(), cache_redis.BreakerSuccess))
+	balanceClient := restapi.NewBreakerBalanceClient(restapi.NewBalanceClient(appConfig.Balance.ClientConfig()),
+													breakers.Register("balance", appConfig.Breakers.Balance.Settings(), restapi.BreakerSuccess))
+	if appConfig.ChargeMaxAmount != "" {
+		err = validation.SetMaxAmount(appConfig.ChargeMaxAmount)
+		if err != nil {
+			log.Error().Err(err).Str("charge_max_amount", appConfig.ChargeMaxAmount).Msg("ERRO FATAL valor máximo da transação inválido")
+			os.Exit(3)
+		}
 	}
 
-	if os.Getenv("DB_HOST") !=  "" {
-		envDB.Host = os.Getenv("DB_HOST")
-	}
-	if os.Getenv("DB_PORT") !=  "" {
-		envDB.Port = os.Getenv("DB_PORT")
-	}
-	if os.Getenv("DB_NAME") !=  "" {	
-		envDB.DatabaseName = os.Getenv("DB_NAME")
+	// Charges in another currency than the balance are rejected, or converted (FX_MODE=convert)
+	var rateProvider fx.RateProvider
+	switch appConfig.Fx.Mode {
+	case "reject":
+	case "convert":
+		provider, err := fx.NewRateProvider(appConfig.Fx.Provider, appConfig.Fx.RatesFile, appConfig.Fx.RatesUrl, time.Duration(appConfig.Fx.RatesTtl) * time.Second)
+		if err != nil {
+			log.Error().Err(err).Msg("ERRO FATAL na criação do provedor de câmbio")
+			os.Exit(3)
+		}
+		rateProvider = fx.NewBreakerRateProvider(provider, breakers.Register("fx", appConfig.Breakers.Fx.Settings(), fx.BreakerSuccess))
+	default:
+		log.Error().Str("fx_mode", appConfig.Fx.Mode).Msg("ERRO FATAL modo de câmbio desconhecido (reject|convert)")
+		os.Exit(3)
 	}
-	if os.Getenv("DB_SCHEMA") !=  "" {	
-		envDB.Schema = os.Getenv("DB_SCHEMA")
+
+	httpAppServerConfig.Server = appConfig.Server.CoreServer()
+	workerService := service.NewWorkerService(repoDB, balanceClient, breakers, cache, rateProvider, appConfig.Fx.Mode == "convert")
+
+	// reconcile [-auto-correct] runs the reconciliation once and exits
+	if len(args) > 0 && args[0] == "reconcile" {
+		os.Exit(runReconcile(workerService, args[1:]))
 	}
 
-	if os.Getenv("SERVER_URL_DOMAIN") !=  "" {	
-		serverUrlDomain = os.Getenv("SERVER_URL_DOMAIN")
+	// Relay of the charge events written in the outbox
+	publisher, err := event.NewPublisher(appConfig.Outbox.Publisher, appConfig.Outbox.FilePath, appConfig.Outbox.WebhookUrl)
+	if err != nil {
+		log.Error().Err(err).Msg("ERRO FATAL na criação do publisher do outbox")
+		os.Exit(3)
 	}
-	if os.Getenv("SERVER_PATH") !=  "" {	
-		path = os.Getenv("SERVER_PATH")
+	ctxRelay, cancelRelay := context.WithCancel(context.Background())
+	defer cancelRelay()
+	outboxRelay := service.NewOutboxRelay(repoDB, publisher, time.Duration(appConfig.Outbox.Interval) * time.Second, appConfig.Outbox.MaxAttempts)
+	go outboxRelay.Start(ctxRelay)
+	go workerService.StartHoldSweeper(ctxRelay, time.Duration(appConfig.HoldSweepInterval) * time.Second)
+	go workerService.StartIdempotencySweeper(ctxRelay, time.Duration(appConfig.Idempotency.SweepInterval) * time.Second, time.Duration(appConfig.Idempotency.Ttl) * time.Second)
+	// Compensate the sagas left incomplete by a crash, of this pod or of another one
+	go workerService.StartSagaResumer(ctxRelay, time.Duration(appConfig.SagaResumeInterval) * time.Second)
+	if appConfig.Reconciliation.Interval > 0 {
+		go workerService.StartReconciliation(ctxRelay, time.Duration(appConfig.Reconciliation.Interval) * time.Second, appConfig.Reconciliation.AutoCorrect)
 	}
 
-	if os.Getenv("NO_AZ") == "false" {	
-		noAZ = false
+	// The config requires a JWKS unless auth.disabled, then the tenant is the one of the payload
+	// and the admin endpoints are closed
+	var verifier *auth.Verifier
+	if appConfig.Auth.Disabled {
+		log.Warn().Msg("Authentication disabled (AUTH_DISABLED), admin endpoints closed")
 	} else {
-		noAZ = true
+		verifier, err = auth.NewVerifier(appConfig.Auth.AuthConfig())
+		if err != nil {
+			log.Error().Err(err).Msg("ERRO FATAL na leitura do JWKS")
+			os.Exit(3)
+		}
 	}
 
-	if os.Getenv("REDIS_ADDRESS") !=  "" {	
-		envCache.Addr =  os.Getenv("REDIS_ADDRESS")
-	}
-	if os.Getenv("REDIS_CLUSTER_ADDRESS") !=  "" {	
-		envCacheCluster.Addrs =  strings.Split(os.Getenv("REDIS_CLUSTER_ADDRESS"), ",") 
-	}
-	if os.Getenv("REDIS_DB_NAME") !=  "" {	
-		intVar, _ := strconv.Atoi(os.Getenv("REDIS_DB_NAME"))
-		envCache.DB = intVar
+	readiness := health.NewChecker(	time.Duration(appConfig.Ready.Timeout) * time.Millisecond,
+									time.Duration(appConfig.Ready.CacheTtl) * time.Millisecond,
+									workerService.ReadinessChecks()...)
+
+	httpWorkerAdapter := handler.NewHttpWorkerAdapter(workerService, verifier, readiness)
+
+	httpAppServerConfig.InfoPod = &infoPod
+	httpServer := handler.NewHttpAppServer(httpAppServerConfig)
+
+	httpServer.StartHttpAppServer(ctx, httpWorkerAdapter)
+
+	// Send the spans still in the batch
+	ctxTracer, cancelTracer := context.WithTimeout(context.Background(), time.Second * 5)
+	defer cancelTracer()
+	err = tracer.Shutdown(ctxTracer)
+	if err != nil {
+		log.Error().Err(err).Msg("Erro no shutdown do tracer")
 	}
-	if os.Getenv("REDIS_PASSWORD") !=  "" {	
-		envCache.Password = os.Getenv("REDIS_PASSWORD")
+}
+
+// runReconcile is the reconcile subcommand, it prints the report and exits 0 when every
+// account matches (or was corrected), 1 on errors and 2 when discrepancies are left open
+func runReconcile(workerService *service.WorkerService, args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	autoCorrect := flags.Bool("auto-correct", appConfig.Reconciliation.AutoCorrect, "fix the confirmed discrepancies with an ADJUSTMENT charge")
+	flags.Parse(args)
+
+	report, err := workerService.Reconcile(context.Background(), *autoCorrect)
+	if err != nil {
+		log.Error().Err(err).Msg("Erro na reconciliação")
+		return 1
 	}
+	json.NewEncoder(os.Stdout).Encode(report)
 
+	switch {
+	case report.Failed > 0:
+		return 1
+	case report.Discrepancies > report.Corrected:
+		return 2
+	}
+	return 0
 }
 
-func main() {
-	log.Debug().Msg("main")
-	log.Debug().Interface("",envDB).Msg("getEnv")
-	log.Debug().Msg("--------")
-	log.Debug().Interface("",server).Msg("server")
-	log.Debug().Msg("--------")
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration( server.ReadTimeout ) * time.Second)
-	defer cancel()
+// runMigrate is the migrate subcommand: up, down [-steps n] (default 1) or status
+func runMigrate(migrator *db_postgre.Migrator, args []string) int {
+	if len(args) == 0 {
+		log.Error().Msg("usage: migrate up|down [-steps n]|status")
+		return 1
+	}
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	flags.Parse(args[1:])
 
-	count := 1
-	var err error
-	for {
-		dataBaseHelper, err = db_postgre.NewDatabaseHelper(ctx, envDB)
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		count, err := migrator.Up(ctx)
 		if err != nil {
-			if count < 3 {
-				log.Error().Err(err).Msg("Erro na abertura do Database")
-			} else {
-				log.Error().Err(err).Msg("ERRO FATAL na abertura do Database aborting")
-				panic(err)	
-			}
-			time.Sleep(3 * time.Second)
-			count = count + 1
-			continue
+			log.Error().Err(err).Msg("Erro no migrate up")
+			return 1
 		}
-		break
-	}
-
-	if !strings.Contains(envCache.Addr, "127.0.0.1") {
-		envCache.TLSConfig = &tls.Config{
-			MinVersion: tls.VersionTLS12,
+		log.Info().Int("applied", count).Int("version", migrator.Latest()).Msg("migrate up")
+	case "down":
+		count, err := migrator.Down(ctx, *steps)
+		if err != nil {
+			log.Error().Err(err).Msg("Erro no migrate down")
+			return 1
 		}
-	}
-	if !strings.Contains(envCacheCluster.Addrs[0], "127.0.0.1") {
-		log.Debug().Msg("tls ok")
-		envCacheCluster.TLSConfig = &tls.Config{
-			MinVersion: tls.VersionTLS12,
+		log.Info().Int("reverted", count).Msg("migrate down")
+	case "status":
+		status_list, err := migrator.Status(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Erro no migrate status")
+			return 1
 		}
+		for _, status := range status_list {
+			applied_at := "pending"
+			if status.Applied {
+				applied_at = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-40s %s\n", status.Version, status.Name, applied_at)
+		}
+	default:
+		log.Error().Str("command", args[0]).Msg("usage: migrate up|down [-steps n]|status")
+		return 1
 	}
-	cache := cache_redis.NewClusterCache(ctx, &envCacheCluster)
-	//cache := cache_redis.NewCache(ctx, &envCache)
-	_, err = cache.Ping(ctx)
-	if err != nil{
-		log.Error().Err(err).Msg("Erro na abertura do Redis")
-	}
-
-	circuitBreaker := circuitbreaker.CircuitBreakerConfig()
-	restapi	:= restapi.NewRestApi(serverUrlDomain, path)
-	httpAppServerConfig.Server = server
-	repoDB = db_postgre.NewWorkerRepository(dataBaseHelper)
-	workerService := service.NewWorkerService(&repoDB, restapi, circuitBreaker, cache)
-	httpWorkerAdapter := handler.NewHttpWorkerAdapter(workerService)
-
-	httpAppServerConfig.InfoPod = &infoPod
-	httpServer := handler.NewHttpAppServer(httpAppServerConfig)
-
-	httpServer.StartHttpAppServer(ctx, httpWorkerAdapter)
+	return 0
 }
\ No newline at end of file
@@ -0,0 +1,141 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+var childLogger = log.With().Str("adapter/event", "event").Logger()
+
+// Publisher delivers an outbox event downstream. The relay retries the events
+// whose Publish returned an error, so implementations may deliver duplicates
+type Publisher interface {
+	Publish(ctx context.Context, outboxEvent core.OutboxEvent) error
+}
+
+// NewPublisher builds the publisher by name (log, file or webhook)
+func NewPublisher(name string, filePath string, webhookUrl string) (Publisher, error) {
+	childLogger.Debug().Str("publisher", name).Msg("NewPublisher")
+
+	switch name {
+	case "", "log":
+		return NewLogPublisher(), nil
+	case "file":
+		return NewFilePublisher(filePath)
+	case "webhook":
+		if webhookUrl == "" {
+			return nil, fmt.Errorf("OUTBOX_WEBHOOK_URL is required by the webhook publisher")
+		}
+		return NewWebhookPublisher(webhookUrl, 10 * time.Second), nil
+	}
+	return nil, fmt.Errorf("outbox publisher %s not supported", name)
+}
+
+// ------------------- log -------------------
+
+type LogPublisher struct {}
+
+func NewLogPublisher() *LogPublisher {
+	return &LogPublisher{}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, outboxEvent core.OutboxEvent) error {
+	childLogger.Info().	Int64("event_id", outboxEvent.ID).
+						Str("event_type", outboxEvent.EventType).
+						Str("aggregate_id", outboxEvent.AggregateID).
+						RawJSON("payload", outboxEvent.Payload).
+						Msg("Event published")
+	return nil
+}
+
+// ------------------- file -------------------
+
+// FilePublisher appends one JSON event per line
+type FilePublisher struct {
+	mutex	sync.Mutex
+	file	*os.File
+}
+
+func NewFilePublisher(filePath string) (*FilePublisher, error) {
+	childLogger.Debug().Str("file", filePath).Msg("NewFilePublisher")
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{ file: file }, nil
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, outboxEvent core.OutboxEvent) error {
+	line, err := json.Marshal(outboxEvent)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, err = p.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+// ------------------- webhook -------------------
+
+// WebhookPublisher posts the event as JSON, any 2xx response is an acknowledgment
+type WebhookPublisher struct {
+	url		string
+	client	*http.Client
+}
+
+func NewWebhookPublisher(url string, timeout time.Duration) *WebhookPublisher {
+	childLogger.Debug().Str("url", url).Msg("NewWebhookPublisher")
+
+	return &WebhookPublisher{
+		url: url,
+		client: tracing.Client(&http.Client{Timeout: timeout}),
+	}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, outboxEvent core.OutboxEvent) error {
+	payload, err := json.Marshal(outboxEvent)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json;charset=UTF-8")
+	req.Header.Add("X-Event-Id", strconv.FormatInt(outboxEvent.ID, 10))
+	req.Header.Add("X-Event-Type", outboxEvent.EventType)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: webhook status %d", erro.ErrPutEvent, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package fx
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+// BreakerRateProvider runs every rate lookup through the breaker of the provider
+type BreakerRateProvider struct {
+	provider	RateProvider
+	breaker		*circuitbreaker.Breaker
+}
+
+func NewBreakerRateProvider(provider RateProvider, breaker *circuitbreaker.Breaker) *BreakerRateProvider {
+	return &BreakerRateProvider{
+		provider:	provider,
+		breaker:	breaker,
+	}
+}
+
+// BreakerSuccess tells the errors that are not a failure of the provider, a pair without
+// rate is about the request
+func BreakerSuccess(err error) bool {
+	return err == nil ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, erro.ErrFxRateNotFound)
+}
+
+func (b *BreakerRateProvider) GetRate(ctx context.Context, base string, quote string) (res core.FxRate, err error) {
+	err = b.breaker.Run(func() (err_call error) {
+		res, err_call = b.provider.GetRate(ctx, base, quote)
+		return err_call
+	})
+	return res, err
+}
@@ -0,0 +1,220 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+var childLogger = log.With().Str("adapter/fx", "fx").Logger()
+
+// Decimal places of the inverted rates
+const inverseRateScale = 10
+
+// RateProvider gives the rate to convert an amount of base into quote (1 base = rate quote).
+// It returns erro.ErrFxRateNotFound when the pair is not quoted
+type RateProvider interface {
+	GetRate(ctx context.Context, base string, quote string) (core.FxRate, error)
+}
+
+// NewRateProvider builds the provider by name (static or http)
+func NewRateProvider(name string, filePath string, ratesUrl string, ttl time.Duration) (RateProvider, error) {
+	childLogger.Debug().Str("provider", name).Msg("NewRateProvider")
+
+	switch name {
+	case "", "static":
+		return NewStaticRateProvider(filePath)
+	case "http":
+		if ratesUrl == "" {
+			return nil, fmt.Errorf("FX_RATES_URL is required by the http rate provider")
+		}
+		return NewHttpRateProvider(ratesUrl, 10 * time.Second, ttl), nil
+	}
+	return nil, fmt.Errorf("fx rate provider %s not supported", name)
+}
+
+// invert returns 1/rate, for the pairs quoted only in the other direction
+func invert(rate string) (string, error) {
+	rate_parsed, ok := new(big.Rat).SetString(rate)
+	if !ok || rate_parsed.Sign() <= 0 {
+		return "", erro.ErrFxRate
+	}
+	inverse := strings.TrimRight(new(big.Rat).Inv(rate_parsed).FloatString(inverseRateScale), "0")
+	return strings.TrimSuffix(inverse, "."), nil
+}
+
+// ------------------- static -------------------
+
+// StaticRateProvider reads the rates from a JSON file, loaded once:
+// {"as_of": "2024-01-02T00:00:00Z", "rates": {"USD/BRL": "4.9123", "EUR/BRL": "5.3810"}}
+type StaticRateProvider struct {
+	asOf	time.Time
+	rates	map[string]string
+}
+
+type staticRates struct {
+	AsOf	time.Time			`json:"as_of"`
+	Rates	map[string]string	`json:"rates"`
+}
+
+func NewStaticRateProvider(filePath string) (*StaticRateProvider, error) {
+	childLogger.Debug().Str("file", filePath).Msg("NewStaticRateProvider")
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	file := staticRates{}
+	err = json.Unmarshal(content, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := map[string]string{}
+	for pair, rate := range file.Rates {
+		rate_parsed, ok := new(big.Rat).SetString(rate)
+		if !ok || rate_parsed.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate %s for %s", rate, pair)
+		}
+		rates[strings.ToUpper(pair)] = rate
+	}
+	if file.AsOf.IsZero() {
+		file.AsOf = time.Now()
+	}
+
+	return &StaticRateProvider{ asOf: file.AsOf, rates: rates }, nil
+}
+
+func (p *StaticRateProvider) GetRate(ctx context.Context, base string, quote string) (core.FxRate, error) {
+	childLogger.Debug().Str("base", base).Str("quote", quote).Msg("GetRate")
+
+	fxRate := core.FxRate{ Base: base, Quote: quote, Provider: "static", AsOf: p.asOf }
+	if rate, ok := p.rates[base + "/" + quote]; ok {
+		fxRate.Rate = rate
+		return fxRate, nil
+	}
+	if rate, ok := p.rates[quote + "/" + base]; ok {
+		inverse, err := invert(rate)
+		if err != nil {
+			return core.FxRate{}, err
+		}
+		fxRate.Rate = inverse
+		return fxRate, nil
+	}
+	return core.FxRate{}, erro.ErrFxRateNotFound
+}
+
+// ------------------- http -------------------
+
+// HttpRateProvider asks the rate to a service, GET url?base=USD&quote=BRL answering
+// {"rate": "4.9123", "as_of": "2024-01-02T10:00:00Z"}. The rates are kept for ttl
+type HttpRateProvider struct {
+	url		string
+	client	*http.Client
+	ttl		time.Duration
+	mutex	sync.Mutex
+	cached	map[string]cachedRate
+}
+
+type cachedRate struct {
+	fxRate		core.FxRate
+	expiresAt	time.Time
+}
+
+type httpRate struct {
+	Rate	string		`json:"rate"`
+	AsOf	time.Time	`json:"as_of"`
+}
+
+func NewHttpRateProvider(url string, timeout time.Duration, ttl time.Duration) *HttpRateProvider {
+	childLogger.Debug().Str("url", url).Msg("NewHttpRateProvider")
+
+	return &HttpRateProvider{
+		url:	url,
+		client:	tracing.Client(&http.Client{Timeout: timeout}),
+		ttl:	ttl,
+		cached:	map[string]cachedRate{},
+	}
+}
+
+func (p *HttpRateProvider) GetRate(ctx context.Context, base string, quote string) (core.FxRate, error) {
+	childLogger.Debug().Str("base", base).Str("quote", quote).Msg("GetRate")
+
+	pair := base + "/" + quote
+	p.mutex.Lock()
+	cached, ok := p.cached[pair]
+	p.mutex.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.fxRate, nil
+	}
+
+	ctx, root := tracing.Start(ctx, "FX.GetRate")
+	defer func() {
+		root.End(nil)
+	}()
+
+	query := url.Values{}
+	query.Set("base", base)
+	query.Set("quote", quote)
+	separator := "?"
+	if strings.Contains(p.url, "?") {
+		separator = "&"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url + separator + query.Encode(), nil)
+	if err != nil {
+		return core.FxRate{}, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("error Do Request")
+		return core.FxRate{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body)
+		return core.FxRate{}, erro.ErrFxRateNotFound
+	case resp.StatusCode != http.StatusOK:
+		io.Copy(io.Discard, resp.Body)
+		return core.FxRate{}, fmt.Errorf("fx rate service status %d", resp.StatusCode)
+	}
+
+	result := httpRate{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("error Unmarshal")
+		return core.FxRate{}, err
+	}
+	rate_parsed, ok := new(big.Rat).SetString(result.Rate)
+	if !ok || rate_parsed.Sign() <= 0 {
+		return core.FxRate{}, erro.ErrFxRate
+	}
+	if result.AsOf.IsZero() {
+		result.AsOf = time.Now()
+	}
+
+	fxRate := core.FxRate{ Base: base, Quote: quote, Rate: result.Rate, Provider: "http", AsOf: result.AsOf }
+	if p.ttl > 0 {
+		p.mutex.Lock()
+		p.cached[pair] = cachedRate{ fxRate: fxRate, expiresAt: time.Now().Add(p.ttl) }
+		p.mutex.Unlock()
+	}
+
+	return fxRate, nil
+}
@@ -0,0 +1,217 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+// testContext has a segment, so the spans of the default tracer (X-Ray) can be started
+func testContext(t *testing.T) context.Context {
+	ctx, segment := xray.BeginSegment(context.Background(), t.Name())
+	t.Cleanup(func() { segment.Close(nil) })
+	return ctx
+}
+
+func writeRates(t *testing.T, content string) string {
+	filePath := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(filePath, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return filePath
+}
+
+func TestInvert(t *testing.T) {
+	tests := []struct {
+		rate	string
+		want	string
+		err		error
+	}{
+		{ "8", "0.125", nil },
+		{ "0.5", "2", nil },
+		{ "3", "0.3333333333", nil },
+		{ "4.9123", "0.2035706288", nil },
+		{ "0.0067", "149.2537313433", nil },
+		{ "0", "", erro.ErrFxRate },
+		{ "-2", "", erro.ErrFxRate },
+		{ "abc", "", erro.ErrFxRate },
+	}
+	for _, tt := range tests {
+		got, err := invert(tt.rate)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("invert(%q) err = %v, want %v", tt.rate, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("invert(%q) = %q, %v, want %q", tt.rate, got, err, tt.want)
+		}
+	}
+}
+
+func TestStaticRateProvider(t *testing.T) {
+	filePath := writeRates(t, `{"as_of": "2024-01-02T00:00:00Z", "rates": {"USD/BRL": "4.9123", "jpy/usd": "0.0067", "BHD/BRL": "13.245"}}`)
+	provider, err := NewStaticRateProvider(filePath)
+	if err != nil {
+		t.Fatalf("NewStaticRateProvider: %v", err)
+	}
+
+	tests := []struct {
+		base, quote	string
+		want		string
+		err			error
+	}{
+		{ "USD", "BRL", "4.9123", nil },
+		{ "BRL", "USD", "0.2035706288", nil },
+		{ "JPY", "USD", "0.0067", nil },
+		{ "USD", "JPY", "149.2537313433", nil },
+		{ "BHD", "BRL", "13.245", nil },
+		{ "BRL", "JPY", "", erro.ErrFxRateNotFound },
+	}
+	for _, tt := range tests {
+		got, err := provider.GetRate(context.Background(), tt.base, tt.quote)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("GetRate(%s, %s) err = %v, want %v", tt.base, tt.quote, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got.Rate != tt.want || got.Base != tt.base || got.Quote != tt.quote || got.Provider != "static" {
+			t.Errorf("GetRate(%s, %s) = %+v, %v, want %s", tt.base, tt.quote, got, err, tt.want)
+		}
+		if !got.AsOf.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("GetRate(%s, %s) as_of = %v", tt.base, tt.quote, got.AsOf)
+		}
+	}
+
+	// The rate of the provider converts between the scales of the currencies
+	fxRate, _ := provider.GetRate(context.Background(), "USD", "JPY")
+	converted, err := core.NewMoney(100, "USD").Convert(fxRate.Rate, "JPY")
+	if err != nil || converted.Units != 149 {
+		t.Errorf("1.00 USD in JPY = %+v, %v, want 149", converted, err)
+	}
+	fxRate, _ = provider.GetRate(context.Background(), "BHD", "BRL")
+	converted, err = core.NewMoney(-1000, "BHD").Convert(fxRate.Rate, "BRL")
+	if err != nil || converted.Units != -1325 {
+		t.Errorf("-1.000 BHD in BRL = %+v, %v, want -1325", converted, err)
+	}
+}
+
+func TestStaticRateProviderInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"rates": {"USD/BRL": "0"}}`,
+		`{"rates": {"USD/BRL": "-4.9"}}`,
+		`{"rates": {"USD/BRL": "abc"}}`,
+		`{"rates": `,
+	} {
+		_, err := NewStaticRateProvider(writeRates(t, content))
+		if err == nil {
+			t.Errorf("NewStaticRateProvider(%s) err = nil", content)
+		}
+	}
+
+	_, err := NewStaticRateProvider(filepath.Join(t.TempDir(), "missing.json"))
+	if err == nil {
+		t.Errorf("NewStaticRateProvider of a missing file err = nil")
+	}
+}
+
+// newRateServer quotes USD/BRL, answers 404 to XXX and a bad rate to BAD
+func newRateServer(t *testing.T, calls *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(calls, 1)
+		base, quote := req.URL.Query().Get("base"), req.URL.Query().Get("quote")
+		switch {
+		case base == "USD" && quote == "BRL":
+			fmt.Fprint(rw, `{"rate": "4.9123", "as_of": "2024-01-02T10:00:00Z"}`)
+		case base == "XXX":
+			rw.WriteHeader(http.StatusNotFound)
+		case base == "BAD":
+			fmt.Fprint(rw, `{"rate": "0"}`)
+		default:
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHttpRateProvider(t *testing.T) {
+	ctx := testContext(t)
+	var calls int32
+	server := newRateServer(t, &calls)
+	provider := NewHttpRateProvider(server.URL, time.Second, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		got, err := provider.GetRate(ctx, "USD", "BRL")
+		if err != nil || got.Rate != "4.9123" || got.Provider != "http" {
+			t.Errorf("GetRate(USD, BRL) = %+v, %v", got, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1 (the rate is kept for the ttl)", calls)
+	}
+
+	tests := []struct {
+		base	string
+		err		error
+	}{
+		{ "XXX", erro.ErrFxRateNotFound },
+		{ "BAD", erro.ErrFxRate },
+	}
+	for _, tt := range tests {
+		_, err := provider.GetRate(ctx, tt.base, "BRL")
+		if !errors.Is(err, tt.err) {
+			t.Errorf("GetRate(%s, BRL) err = %v, want %v", tt.base, err, tt.err)
+		}
+	}
+	_, err := provider.GetRate(ctx, "EUR", "BRL")
+	if err == nil {
+		t.Errorf("GetRate(EUR, BRL) of status 500 err = nil")
+	}
+}
+
+func TestBreakerRateProvider(t *testing.T) {
+	ctx := testContext(t)
+	var calls int32
+	server := newRateServer(t, &calls)
+	settings := circuitbreaker.DefaultSettings()
+	settings.MaxFailures = 2
+	breaker := circuitbreaker.NewRegistry().Register("fx", settings, BreakerSuccess)
+	provider := NewBreakerRateProvider(NewHttpRateProvider(server.URL, time.Second, 0), breaker)
+
+	// A pair without rate is not a failure of the provider
+	for i := 0; i < 3; i++ {
+		_, err := provider.GetRate(ctx, "XXX", "BRL")
+		if !errors.Is(err, erro.ErrFxRateNotFound) {
+			t.Fatalf("GetRate(XXX, BRL) err = %v, want ErrFxRateNotFound", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		provider.GetRate(ctx, "EUR", "BRL")
+	}
+	_, err := provider.GetRate(ctx, "USD", "BRL")
+	if !errors.Is(err, erro.ErrBreakerOpen) {
+		t.Errorf("GetRate after the failures err = %v, want ErrBreakerOpen", err)
+	}
+	if calls != 5 {
+		t.Errorf("calls = %d, want 5 (none while open)", calls)
+	}
+}
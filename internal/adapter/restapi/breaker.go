@@ -0,0 +1,57 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+)
+
+// BreakerBalanceClient runs every call to go-rest-balance (all its retries included)
+// through the breaker of the service
+type BreakerBalanceClient struct {
+	client	BalanceClient
+	breaker	*circuitbreaker.Breaker
+}
+
+func NewBreakerBalanceClient(client BalanceClient, breaker *circuitbreaker.Breaker) *BreakerBalanceClient {
+	return &BreakerBalanceClient{
+		client:		client,
+		breaker:	breaker,
+	}
+}
+
+// BreakerSuccess tells the errors that are not a failure of go-rest-balance, the
+// 4xx answers (but 429) are about the request
+func BreakerSuccess(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return true
+	}
+	var err_http *HTTPError
+	if errors.As(err, &err_http) {
+		return err_http.StatusCode < 500 && err_http.StatusCode != 429
+	}
+	return false
+}
+
+func (b *BreakerBalanceClient) GetBalance(ctx context.Context, accountID string) (res core.Balance, err error) {
+	err = b.breaker.Run(func() (err_call error) {
+		res, err_call = b.client.GetBalance(ctx, accountID)
+		return err_call
+	})
+	return res, err
+}
+
+func (b *BreakerBalanceClient) UpdateBalance(ctx context.Context, accountID string, balance core.Balance) (res core.Balance, err error) {
+	err = b.breaker.Run(func() (err_call error) {
+		res, err_call = b.client.UpdateBalance(ctx, accountID, balance)
+		return err_call
+	})
+	return res, err
+}
+
+// Ping is not counted, the readiness check must see the real state of go-rest-balance
+func (b *BreakerBalanceClient) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx)
+}
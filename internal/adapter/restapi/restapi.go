@@ -2,151 +2,304 @@ package restapi
 
 import(
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"time"
 	"encoding/json"
 	"bytes"
 	"context"
+	"strconv"
 
 	"github.com/rs/zerolog/log"
+	"github.com/go-rest-balance-charges/internal/core"
 	"github.com/go-rest-balance-charges/internal/erro"
-	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-rest-balance-charges/internal/metrics"
+	"github.com/go-rest-balance-charges/internal/tracing"
 )
 
 var childLogger = log.With().Str("adapter/restapi", "restapi").Logger()
 
-type RestApiSConfig struct {
-	serverUrlDomain			string
-	path					string
+// Max size of an error body kept in HTTPError
+const maxErrorBody = 4096
+
+// BalanceClient is the go-rest-balance service, owner of the account balances
+type BalanceClient interface {
+	GetBalance(ctx context.Context, accountID string) (core.Balance, error)
+	UpdateBalance(ctx context.Context, accountID string, balance core.Balance) (core.Balance, error)
+	Ping(ctx context.Context) error
+}
+
+type BalanceClientConfig struct {
+	ServerUrlDomain		string
+	GetPath				string
+	UpdatePath			string
+	HealthPath			string
+	Timeout				time.Duration
+	DialTimeout			time.Duration
+	MaxIdleConns		int
+	IdleConnTimeout		time.Duration
+	Retry				RetryPolicy
+}
+
+// HTTPError is returned when go-rest-balance answers with a status other than 200, it
+// keeps the remote status and body and unwraps to the erro sentinel of the status
+type HTTPError struct {
+	StatusCode	int
+	Body		string
+	Err			error
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s (go-rest-balance status %d: %s)", e.Err.Error(), e.StatusCode, e.Body)
 }
 
-func NewRestApi(serverUrlDomain string, path string) (*RestApiSConfig){
-	childLogger.Debug().Msg("*** NewRestApi")
-	return &RestApiSConfig {
-		serverUrlDomain: 	serverUrlDomain,
-		path: 	path,
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+func newHTTPError(resp *http.Response) *HTTPError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	var err error
+	switch {
+		case resp.StatusCode == 401, resp.StatusCode == 403:
+			err = erro.ErrRemoteForbidden
+		case resp.StatusCode == 400, resp.StatusCode == 404:
+			err = erro.ErrNotFound
+		case resp.StatusCode == 429:
+			err = erro.ErrTooManyRequests
+		case resp.StatusCode >= 500:
+			err = erro.ErrStatusInternalServerError
+		default:
+			err = erro.ErrUnexpectedStatus
 	}
+
+	return &HTTPError{ StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body)), Err: err }
 }
 
-func (r *RestApiSConfig) GetData(ctx context.Context, id string) (interface{}, error) {
-	childLogger.Debug().Msg("GetData")
+type BalanceRestClient struct {
+	config		BalanceClientConfig
+	client		*http.Client
+}
+
+// NewBalanceClient builds the client once, the transport (and its pool of connections)
+// is shared by all the requests
+func NewBalanceClient(config BalanceClientConfig) *BalanceRestClient {
+	childLogger.Debug().Msg("*** NewBalanceClient")
+
+	if config.GetPath == "" {
+		config.GetPath = "/get"
+	}
+	if config.UpdatePath == "" {
+		config.UpdatePath = "/update"
+	}
+	if config.HealthPath == "" {
+		config.HealthPath = "/health"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Second * 29
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = time.Second * 5
+	}
+	if config.MaxIdleConns == 0 {
+		config.MaxIdleConns = 100
+	}
+	if config.IdleConnTimeout == 0 {
+		config.IdleConnTimeout = time.Second * 90
+	}
 
-	domain := r.serverUrlDomain + r.path +"/" + id
+	config.Retry.setDefaults()
 
-	childLogger.Debug().Str("domain : ", domain).Msg("GetData")
+	transport := &http.Transport{
+		Proxy:					http.ProxyFromEnvironment,
+		DialContext:			(&net.Dialer{ Timeout: config.DialTimeout, KeepAlive: time.Second * 30 }).DialContext,
+		MaxIdleConns:			config.MaxIdleConns,
+		MaxIdleConnsPerHost:	config.MaxIdleConns,
+		IdleConnTimeout:		config.IdleConnTimeout,
+		TLSHandshakeTimeout:	config.DialTimeout,
+		ExpectContinueTimeout:	time.Second * 1,
+	}
 
-	data_interface, err := makeGet(ctx, domain, id)
-	if err != nil {
-		childLogger.Error().Err(err).Msg("error Request")
-		return nil, errors.New(err.Error())
+	return &BalanceRestClient{
+		config:	config,
+		client:	tracing.Client(&http.Client{ Timeout: config.Timeout, Transport: transport }),
 	}
-    
-	return data_interface, nil
 }
 
-func (r *RestApiSConfig) PostData(ctx context.Context, id string, data interface{}) (interface{}, error) {
-	childLogger.Debug().Msg("PostData")
+func (r *BalanceRestClient) GetBalance(ctx context.Context, accountID string) (core.Balance, error) {
+	childLogger.Debug().Msg("GetBalance")
 
-	domain := r.serverUrlDomain + "/update" +"/" + id
+	domain := r.config.ServerUrlDomain + r.config.GetPath + "/" + accountID
 
-	childLogger.Debug().Str("domain : ", domain).Msg("PostData")
+	childLogger.Debug().Str("domain : ", domain).Msg("GetBalance")
 
-	data_interface, err := makePost(ctx, domain, id ,data)
+	var balance core.Balance
+	err := r.do(ctx, http.MethodGet, domain, nil, &balance)
 	if err != nil {
-		childLogger.Error().Err(err).Msg("error Request")
-		return nil, errors.New(err.Error())
+		return core.Balance{}, err
 	}
-    
-	return data_interface, nil
+
+	return balance, nil
 }
 
-func makeGet(ctx context.Context, url string, id interface{}) (interface{}, error) {
-	childLogger.Debug().Msg("makeGet")
+func (r *BalanceRestClient) UpdateBalance(ctx context.Context, accountID string, balance core.Balance) (core.Balance, error) {
+	childLogger.Debug().Msg("UpdateBalance")
 
-	client := xray.Client(&http.Client{Timeout: time.Second * 29})
-	
-	req, err := http.NewRequest("GET", url, nil)
+	domain := r.config.ServerUrlDomain + r.config.UpdatePath + "/" + accountID
+
+	childLogger.Debug().Str("domain : ", domain).Msg("UpdateBalance")
+
+	var result core.Balance
+	err := r.do(ctx, http.MethodPost, domain, balance, &result)
 	if err != nil {
-		childLogger.Error().Err(err).Msg("error Request")
-		return false, errors.New(err.Error())
+		return core.Balance{}, err
 	}
 
-	req.Header.Add("Content-Type", "application/json;charset=UTF-8");
+	return result, nil
+}
+
+// Ping asks the health endpoint of go-rest-balance once, without retries
+func (r *BalanceRestClient) Ping(ctx context.Context) error {
+	childLogger.Debug().Msg("Ping")
 
-	resp, err := client.Do(req.WithContext(ctx))
+	ctx, root := tracing.Start(ctx, "Balance.Ping")
+	defer func() {
+		root.End(nil)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.config.ServerUrlDomain + r.config.HealthPath, nil)
 	if err != nil {
-		childLogger.Error().Err(err).Msg("error Do Request")
-		return false, errors.New(err.Error())
+		return errors.New(err.Error())
 	}
 
-	childLogger.Debug().Int("StatusCode :", resp.StatusCode).Msg("")
-	switch (resp.StatusCode) {
-		case 401:
-			return false, erro.ErrHTTPForbiden
-		case 403:
-			return false, erro.ErrHTTPForbiden
-		case 200:
-		case 400:
-			return false, erro.ErrNotFound
-		case 404:
-			return false, erro.ErrNotFound
-		default:
-			return false, erro.ErrHTTPForbiden
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", erro.ErrRemoteUnavailable, err.Error())
 	}
+	defer resp.Body.Close()
 
-	result := id
-	err = json.NewDecoder(resp.Body).Decode(&result)
-    if err != nil {
-		childLogger.Error().Err(err).Msg("error no ErrUnmarshal")
-		return false, errors.New(err.Error())
-    }
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPError(resp)
+	}
+	io.Copy(io.Discard, resp.Body)
 
-	return result, nil
+	return nil
 }
 
-func makePost(ctx context.Context, url string, inter interface{}, data interface{}) (interface{}, error) {
-	childLogger.Debug().Msg("makePost")
+// do sends the request and decodes a 200 response into result, any other status
+// is returned as *HTTPError. Transient failures are retried following the RetryPolicy
+func (r *BalanceRestClient) do(ctx context.Context, method string, url string, data interface{}, result interface{}) error {
+	childLogger.Debug().Str("method", method).Msg("do")
+
+	var body []byte
+	if data != nil {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("error Marshal")
+			return errors.New(err.Error())
+		}
+		body = payload
+	}
+
+	policy := r.config.Retry
+	key := idempotencyKey(ctx)
+	retryable := method == http.MethodGet || key != ""
+	policy.Budget.deposit()
 
-	client := xray.Client(&http.Client{Timeout: time.Second * 29})
-	
-	payload := new(bytes.Buffer)
-	json.NewEncoder(payload).Encode(data)
+	for attempt := 1; ; attempt++ {
+		transient, retry_after, err := r.attempt(ctx, method, url, body, key, attempt, result)
+		if err == nil {
+			return nil
+		}
+		if !retryable || !transient || attempt >= policy.MaxAttempts {
+			return err
+		}
 
-	req, err := http.NewRequest("POST", url, payload)
+		wait := policy.backoff(attempt)
+		if retry_after > 0 {
+			if retry_after > policy.MaxRetryAfter {
+				childLogger.Error().Dur("retry_after", retry_after).Msg("Retry-After too long, giving up")
+				return err
+			}
+			wait = retry_after
+		}
+		if !policy.Budget.withdraw() {
+			childLogger.Error().Msg("Retry budget exhausted, giving up")
+			return err
+		}
+
+		childLogger.Warn().Err(err).Int("attempt", attempt).Dur("wait", wait).Msg("Retrying go-rest-balance")
+		if sleep(ctx, wait) != nil {
+			return err
+		}
+	}
+}
+
+// attempt sends the request once, traced as its own subsegment. It tells whether a
+// failure is transient and the wait asked by go-rest-balance (Retry-After)
+func (r *BalanceRestClient) attempt(ctx context.Context,
+									method string,
+									url string,
+									body []byte,
+									key string,
+									attempt int,
+									result interface{}) (transient bool, retry_after time.Duration, err error) {
+
+	ctx, root := tracing.Start(ctx, fmt.Sprintf("Balance.%s-attempt-%d", method, attempt))
+	start := time.Now()
+	status := "error"
+	defer func() {
+		metrics.BalanceRequestDuration.WithLabelValues(method, status).Observe(time.Since(start).Seconds())
+		root.End(err)
+	}()
+	root.SetAttribute("attempt", attempt)
+
+	var payload io.Reader
+	if body != nil {
+		payload = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
 	if err != nil {
 		childLogger.Error().Err(err).Msg("error Request")
-		return false, errors.New(err.Error())
+		return false, 0, errors.New(err.Error())
 	}
 
 	req.Header.Add("Content-Type", "application/json;charset=UTF-8");
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if id := core.CorrelationID(ctx); id != "" {
+		req.Header.Set(core.CorrelationHeader, id)
+	}
 
-	resp, err := client.Do(req.WithContext(ctx))
+	resp, err := r.client.Do(req)
 	if err != nil {
 		childLogger.Error().Err(err).Msg("error Do Request")
-		return false, errors.New(err.Error())
+		// Network errors are transient, the end of the caller context is not
+		return ctx.Err() == nil, 0, fmt.Errorf("%w: %s", erro.ErrRemoteUnavailable, err.Error())
 	}
+	defer resp.Body.Close()
+	status = strconv.Itoa(resp.StatusCode)
 
 	childLogger.Debug().Int("StatusCode :", resp.StatusCode).Msg("")
-	switch (resp.StatusCode) {
-		case 401:
-			return false, erro.ErrHTTPForbiden
-		case 403:
-			return false, erro.ErrHTTPForbiden
-		case 200:
-		case 400:
-			return false, erro.ErrNotFound
-		case 404:
-			return false, erro.ErrNotFound
-		default:
-			return false, erro.ErrHTTPForbiden
+	if resp.StatusCode != http.StatusOK {
+		retry_after, _ = retryAfter(resp)
+		err_http := newHTTPError(resp)
+		childLogger.Error().Err(err_http).Msg("error Response")
+		return retryableStatus(resp.StatusCode), retry_after, err_http
 	}
 
-	result := inter
-	err = json.NewDecoder(resp.Body).Decode(&result)
-    if err != nil {
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
 		childLogger.Error().Err(err).Msg("error no ErrUnmarshal")
-		return false, errors.New(err.Error())
-    }
+		return false, 0, fmt.Errorf("%w: %s", erro.ErrRemoteUnavailable, err.Error())
+	}
+	// Drain so the connection goes back to the pool
+	io.Copy(io.Discard, resp.Body)
 
-	return result, nil
+	return false, 0, nil
 }
@@ -0,0 +1,143 @@
+package restapi
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+)
+
+type idempotencyKeyCtx struct{}
+
+// WithIdempotencyKey marks the calls made with ctx as safe to retry, the key is sent in
+// the Idempotency-Key header so go-rest-balance applies a retried POST only once
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+func idempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyCtx{}).(string)
+	return key
+}
+
+// RetryPolicy of the calls to go-rest-balance, GETs are always retried and POSTs only
+// when they carry an idempotency key
+type RetryPolicy struct {
+	MaxAttempts		int
+	BaseBackoff		time.Duration
+	MaxBackoff		time.Duration
+	MaxRetryAfter	time.Duration
+	Budget			*RetryBudget
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseBackoff == 0 {
+		p.BaseBackoff = time.Millisecond * 100
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = time.Second * 2
+	}
+	if p.MaxRetryAfter == 0 {
+		p.MaxRetryAfter = time.Second * 10
+	}
+	if p.Budget == nil {
+		p.Budget = NewRetryBudget(0.2, 10)
+	}
+}
+
+// backoff is exponential with full jitter: a random wait between 0 and base * 2^(attempt-1)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.BaseBackoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait = wait * 2
+	}
+	if wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(wait) + 1))
+}
+
+func retryableStatus(statusCode int) bool {
+	switch statusCode {
+		case http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+	}
+	return false
+}
+
+// retryAfter reads the Retry-After header (seconds or http date) of a 429/503
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// RetryBudget limits the retries to a ratio of the requests, so a go-rest-balance
+// already in trouble does not get the load multiplied. Every request deposits ratio
+// tokens (up to max) and every retry withdraws one.
+type RetryBudget struct {
+	mutex	sync.Mutex
+	ratio	float64
+	max		float64
+	tokens	float64
+}
+
+func NewRetryBudget(ratio float64, max float64) *RetryBudget {
+	return &RetryBudget{ ratio: ratio, max: max, tokens: max }
+}
+
+func (b *RetryBudget) deposit() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens = b.tokens + b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *RetryBudget) withdraw() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens = b.tokens - 1
+	return true
+}
+
+// sleep waits for d or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+var childLogger = log.With().Str("auth", "auth").Logger()
+
+// Scopes of the endpoints
+const (
+	ScopeRead		= "charges:read"
+	ScopeWrite		= "charges:write"
+	ScopeAdmin		= "charges:admin"
+)
+
+// Config of the JWT validation, the keys come from JwksFile or else JwksUrl (kept for JwksTtl).
+// Issuer and Audience are checked when set, the tenant is the claim TenantClaim
+type Config struct {
+	JwksFile		string
+	JwksUrl			string
+	JwksTtl			time.Duration
+	Issuer			string
+	Audience		string
+	TenantClaim		string
+	Leeway			time.Duration
+}
+
+// Claims of a validated token
+type Claims struct {
+	Subject			string
+	TenantID		string
+	Scopes			[]string
+	ExpiresAt		time.Time
+}
+
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type claimsCtx struct{}
+
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsCtx{}, claims)
+}
+
+// FromContext returns nil when the request is not authenticated
+func FromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsCtx{}).(*Claims)
+	return claims
+}
+
+// Verifier validates the RS256 and ES256 tokens (JWS compact) signed by the keys of a KeySet
+type Verifier struct {
+	config		Config
+	keys		KeySet
+}
+
+// NewVerifier returns nil, without error, when no JWKS is configured (authentication disabled)
+func NewVerifier(config Config) (*Verifier, error) {
+	childLogger.Debug().Msg("NewVerifier")
+
+	if config.TenantClaim == "" {
+		config.TenantClaim = "tenant_id"
+	}
+	if config.JwksTtl == 0 {
+		config.JwksTtl = 5 * time.Minute
+	}
+
+	var keys KeySet
+	switch {
+	case config.JwksFile != "":
+		file_keys, err := NewFileKeySet(config.JwksFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = file_keys
+	case config.JwksUrl != "":
+		keys = NewUrlKeySet(config.JwksUrl, 10 * time.Second, config.JwksTtl)
+	default:
+		return nil, nil
+	}
+
+	return &Verifier{ config: config, keys: keys }, nil
+}
+
+type header struct {
+	Alg		string	`json:"alg"`
+	Kid		string	`json:"kid"`
+}
+
+// Verify checks the signature, exp/nbf, iss and aud of the token and returns its claims.
+// The errors unwrap to erro.ErrUnauthorized
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", erro.ErrUnauthorized)
+	}
+
+	token_header := header{}
+	err := decodeSegment(parts[0], &token_header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed header", erro.ErrUnauthorized)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", erro.ErrUnauthorized)
+	}
+
+	key, err := v.keys.Key(ctx, token_header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	err = verifySignature(token_header.Alg, key, parts[0] + "." + parts[1], signature)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := map[string]interface{}{}
+	err = decodeSegment(parts[1], &payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", erro.ErrUnauthorized)
+	}
+
+	return v.claims(payload)
+}
+
+// verifySignature accepts only the algorithm of the type of the key, a RSA key can not
+// verify an ES256 token or the opposite
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "RS256":
+		rsa_key, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key is not RSA", erro.ErrUnauthorized)
+		}
+		if rsa.VerifyPKCS1v15(rsa_key, crypto.SHA256, digest[:], signature) != nil {
+			return fmt.Errorf("%w: invalid signature", erro.ErrUnauthorized)
+		}
+	case "ES256":
+		ec_key, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key is not EC", erro.ErrUnauthorized)
+		}
+		if len(signature) != 64 {
+			return fmt.Errorf("%w: invalid signature", erro.ErrUnauthorized)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ec_key, digest[:], r, s) {
+			return fmt.Errorf("%w: invalid signature", erro.ErrUnauthorized)
+		}
+	default:
+		return fmt.Errorf("%w: alg %s not allowed", erro.ErrUnauthorized, alg)
+	}
+	return nil
+}
+
+func (v *Verifier) claims(payload map[string]interface{}) (*Claims, error) {
+	now := time.Now()
+
+	exp, ok := numericDate(payload["exp"])
+	if !ok {
+		return nil, fmt.Errorf("%w: exp required", erro.ErrUnauthorized)
+	}
+	if now.After(exp.Add(v.config.Leeway)) {
+		return nil, fmt.Errorf("%w: token expired", erro.ErrUnauthorized)
+	}
+	if nbf, ok := numericDate(payload["nbf"]); ok && now.Add(v.config.Leeway).Before(nbf) {
+		return nil, fmt.Errorf("%w: token not yet valid", erro.ErrUnauthorized)
+	}
+	if v.config.Issuer != "" && payload["iss"] != v.config.Issuer {
+		return nil, fmt.Errorf("%w: invalid issuer", erro.ErrUnauthorized)
+	}
+	if v.config.Audience != "" && !contains(stringList(payload["aud"]), v.config.Audience) {
+		return nil, fmt.Errorf("%w: invalid audience", erro.ErrUnauthorized)
+	}
+
+	claims := Claims{ ExpiresAt: exp }
+	claims.Subject, _ = payload["sub"].(string)
+	claims.TenantID, _ = payload[v.config.TenantClaim].(string)
+	// scope is a space separated string (RFC 8693), scp a list or a string
+	claims.Scopes = strings.Fields(strings.Join(stringList(payload["scope"]), " "))
+	claims.Scopes = append(claims.Scopes, strings.Fields(strings.Join(stringList(payload["scp"]), " "))...)
+
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+func numericDate(value interface{}) (time.Time, bool) {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// stringList reads a claim that is a string or a list of strings
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{ v }
+	case []interface{}:
+		list := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
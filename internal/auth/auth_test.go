@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+const (
+	testIssuer		= "https://idp.example.com"
+	testAudience	= "go-rest-balance-charges"
+)
+
+var (
+	rsaKeyOnce		sync.Once
+	rsaTestKey		*rsa.PrivateKey
+	rsaOtherKey		*rsa.PrivateKey
+	ecTestKey		*ecdsa.PrivateKey
+)
+
+// testKeys are generated once, the RSA ones are slow
+func testKeys(t *testing.T) (*rsa.PrivateKey, *rsa.PrivateKey, *ecdsa.PrivateKey) {
+	rsaKeyOnce.Do(func() {
+		rsaTestKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+		rsaOtherKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+		ecTestKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	})
+	if rsaTestKey == nil || rsaOtherKey == nil || ecTestKey == nil {
+		t.Fatalf("key generation failed")
+	}
+	return rsaTestKey, rsaOtherKey, ecTestKey
+}
+
+func segment(v interface{}) string {
+	content, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// sign builds the JWS compact token, key is *rsa.PrivateKey (RS256), *ecdsa.PrivateKey
+// (ES256), []byte (HS256) or nil (no signature)
+func sign(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	token_header := map[string]string{ "alg": alg, "typ": "JWT" }
+	if kid != "" {
+		token_header["kid"] = kid
+	}
+	signed := segment(token_header) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("SignPKCS1v15: %v", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatalf("ecdsa.Sign: %v", err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func rsaJwk(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{	"kty": "RSA",
+								"kid": kid,
+								"use": "sig",
+								"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+								"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+							}
+}
+
+func ecJwk(kid string, key *ecdsa.PublicKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return map[string]string{	"kty": "EC",
+								"kid": kid,
+								"crv": "P-256",
+								"x": base64.RawURLEncoding.EncodeToString(x),
+								"y": base64.RawURLEncoding.EncodeToString(y),
+							}
+}
+
+func jwksContent(keys ...map[string]string) []byte {
+	content, _ := json.Marshal(map[string]interface{}{ "keys": keys })
+	return content
+}
+
+// jwksServer serves the content of jwks, it may be changed (key rotation) or emptied (down)
+type jwksServer struct {
+	*httptest.Server
+	mutex		sync.Mutex
+	content		[]byte
+	fetches		int32
+}
+
+func newJwksServer(t *testing.T, content []byte) *jwksServer {
+	server := &jwksServer{ content: content }
+	server.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&server.fetches, 1)
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		if server.content == nil {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write(server.content)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *jwksServer) set(content []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.content = content
+}
+
+// testContext has a segment, so the spans of the default tracer (X-Ray) can be started
+func testContext(t *testing.T) context.Context {
+	ctx, segment := xray.BeginSegment(context.Background(), t.Name())
+	t.Cleanup(func() { segment.Close(nil) })
+	return ctx
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":			testIssuer,
+		"aud":			testAudience,
+		"sub":			"client-001",
+		"tenant_id":	"TENANT-001",
+		"scope":		"charges:read charges:write",
+		"iat":			now.Unix(),
+		"exp":			now.Add(time.Hour).Unix(),
+	}
+}
+
+// with returns the valid claims changed by changes, a nil value removes the claim
+func with(changes map[string]interface{}) map[string]interface{} {
+	claims := validClaims()
+	for name, value := range changes {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	return claims
+}
+
+func TestVerify(t *testing.T) {
+	rsa_key, other_key, ec_key := testKeys(t)
+	server := newJwksServer(t, jwksContent(rsaJwk("rsa-1", &rsa_key.PublicKey), ecJwk("ec-1", &ec_key.PublicKey)))
+	verifier, err := NewVerifier(Config{ JwksUrl: server.URL, Issuer: testIssuer, Audience: testAudience, Leeway: time.Minute })
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name	string
+		token	string
+		valid	bool
+	}{
+		{ "RS256", sign(t, "RS256", "rsa-1", rsa_key, validClaims()), true },
+		{ "ES256", sign(t, "ES256", "ec-1", ec_key, validClaims()), true },
+		{ "audience in a list", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "aud": []string{ "other", testAudience } })), true },
+		{ "expired within the leeway", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "exp": now.Add(-30 * time.Second).Unix() })), true },
+		{ "nbf within the leeway", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "nbf": now.Add(30 * time.Second).Unix() })), true },
+		{ "nbf passed", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "nbf": now.Add(-time.Hour).Unix() })), true },
+		{ "alg none", sign(t, "none", "rsa-1", nil, validClaims()), false },
+		{ "alg none without kid", sign(t, "none", "", nil, validClaims()), false },
+		{ "alg HS256 with the public key", sign(t, "HS256", "rsa-1", rsa_key.PublicKey.N.Bytes(), validClaims()), false },
+		{ "RS256 with the EC key", sign(t, "RS256", "ec-1", rsa_key, validClaims()), false },
+		{ "ES256 with the RSA key", sign(t, "ES256", "rsa-1", ec_key, validClaims()), false },
+		{ "signed by another key", sign(t, "RS256", "rsa-1", other_key, validClaims()), false },
+		{ "unknown kid", sign(t, "RS256", "rsa-2", rsa_key, validClaims()), false },
+		{ "kid required with two keys", sign(t, "RS256", "", rsa_key, validClaims()), false },
+		{ "expired", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "exp": now.Add(-2 * time.Minute).Unix() })), false },
+		{ "exp missing", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "exp": nil })), false },
+		{ "exp not a number", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "exp": "tomorrow" })), false },
+		{ "not yet valid", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "nbf": now.Add(2 * time.Minute).Unix() })), false },
+		{ "wrong audience", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "aud": "other" })), false },
+		{ "audience missing", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "aud": nil })), false },
+		{ "wrong issuer", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "iss": "https://evil.example.com" })), false },
+		{ "issuer missing", sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "iss": nil })), false },
+		{ "malformed", "abc.def", false },
+		{ "empty", "", false },
+	}
+	for _, tt := range tests {
+		claims, err := verifier.Verify(testContext(t), tt.token)
+		if tt.valid {
+			if err != nil || claims == nil {
+				t.Errorf("%s: Verify err = %v, want valid", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, erro.ErrUnauthorized) {
+			t.Errorf("%s: Verify err = %v, want ErrUnauthorized", tt.name, err)
+		}
+	}
+
+	// A tampered payload breaks the signature
+	parts := strings.Split(sign(t, "RS256", "rsa-1", rsa_key, validClaims()), ".")
+	tampered := parts[0] + "." + segment(with(map[string]interface{}{ "tenant_id": "TENANT-002" })) + "." + parts[2]
+	_, err = verifier.Verify(testContext(t), tampered)
+	if !errors.Is(err, erro.ErrUnauthorized) {
+		t.Errorf("tampered token err = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	rsa_key, _, _ := testKeys(t)
+	filePath := filepath.Join(t.TempDir(), "jwks.json")
+	err := os.WriteFile(filePath, jwksContent(rsaJwk("rsa-1", &rsa_key.PublicKey)), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name		string
+		config		Config
+		claims		map[string]interface{}
+		tenantID	string
+		scopes		[]string
+	}{
+		{ "scope string", Config{}, with(map[string]interface{}{ "exp": exp }), "TENANT-001", []string{ ScopeRead, ScopeWrite } },
+		{ "scp list", Config{}, with(map[string]interface{}{ "scope": nil, "scp": []string{ ScopeRead, ScopeAdmin } }), "TENANT-001", []string{ ScopeRead, ScopeAdmin } },
+		{ "scope and scp", Config{}, with(map[string]interface{}{ "scp": "charges:admin" }), "TENANT-001", []string{ ScopeRead, ScopeWrite, ScopeAdmin } },
+		{ "without scope", Config{}, with(map[string]interface{}{ "scope": nil }), "TENANT-001", []string{} },
+		{ "tenant claim", Config{ TenantClaim: "org" }, with(map[string]interface{}{ "org": "TENANT-002" }), "TENANT-002", []string{ ScopeRead, ScopeWrite } },
+		{ "tenant claim missing", Config{ TenantClaim: "org" }, validClaims(), "", []string{ ScopeRead, ScopeWrite } },
+		{ "tenant not a string", Config{}, with(map[string]interface{}{ "tenant_id": 1 }), "", []string{ ScopeRead, ScopeWrite } },
+	}
+	for _, tt := range tests {
+		tt.config.JwksFile = filePath
+		verifier, err := NewVerifier(tt.config)
+		if err != nil {
+			t.Fatalf("NewVerifier: %v", err)
+		}
+		// A single key, the kid is optional
+		claims, err := verifier.Verify(context.Background(), sign(t, "RS256", "", rsa_key, tt.claims))
+		if err != nil {
+			t.Errorf("%s: Verify: %v", tt.name, err)
+			continue
+		}
+		if claims.Subject != "client-001" || claims.TenantID != tt.tenantID {
+			t.Errorf("%s: claims = %+v, want tenant %q", tt.name, claims, tt.tenantID)
+		}
+		if !reflect.DeepEqual(claims.Scopes, tt.scopes) {
+			t.Errorf("%s: scopes = %v, want %v", tt.name, claims.Scopes, tt.scopes)
+		}
+		for _, scope := range tt.scopes {
+			if !claims.HasScope(scope) {
+				t.Errorf("%s: HasScope(%s) = false", tt.name, scope)
+			}
+		}
+	}
+
+	verifier, _ := NewVerifier(Config{ JwksFile: filePath })
+	claims, _ := verifier.Verify(context.Background(), sign(t, "RS256", "rsa-1", rsa_key, with(map[string]interface{}{ "exp": exp })))
+	if claims == nil || claims.ExpiresAt.Unix() != exp || claims.HasScope(ScopeAdmin) {
+		t.Errorf("claims = %+v, want exp %d without admin", claims, exp)
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	verifier, err := NewVerifier(Config{})
+	if verifier != nil || err != nil {
+		t.Errorf("NewVerifier without jwks = %v, %v, want disabled", verifier, err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "jwks.json")
+	for _, content := range []string{ `{"keys": []}`, `{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`, `{"keys": [{"kty": "EC", "crv": "P-256", "x": "AA", "y": "AA"}]}`, `{` } {
+		os.WriteFile(filePath, []byte(content), 0o600)
+		_, err = NewVerifier(Config{ JwksFile: filePath })
+		if err == nil {
+			t.Errorf("NewVerifier of %s err = nil", content)
+		}
+	}
+}
+
+func TestUrlKeySet(t *testing.T) {
+	ctx := testContext(t)
+	rsa_key, other_key, _ := testKeys(t)
+	server := newJwksServer(t, jwksContent(rsaJwk("rsa-1", &rsa_key.PublicKey)))
+	keys := NewUrlKeySet(server.URL, time.Second, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, err := keys.Key(ctx, "rsa-1"); err != nil {
+			t.Fatalf("Key(rsa-1): %v", err)
+		}
+	}
+	if server.fetches != 1 {
+		t.Errorf("fetches = %d, want 1 (kept for the ttl)", server.fetches)
+	}
+
+	// Rotation: an unknown kid fetches again, at most once every minRefreshInterval
+	server.set(jwksContent(rsaJwk("rsa-1", &rsa_key.PublicKey), rsaJwk("rsa-2", &other_key.PublicKey)))
+	_, err := keys.Key(ctx, "rsa-2")
+	if !errors.Is(err, erro.ErrUnauthorized) || server.fetches != 1 {
+		t.Errorf("Key(rsa-2) right after the fetch = %v, %d fetches, want ErrUnauthorized without fetch", err, server.fetches)
+	}
+	keys.checkedAt = time.Time{}
+	if _, err = keys.Key(ctx, "rsa-2"); err != nil || server.fetches != 2 {
+		t.Errorf("Key(rsa-2) after the interval = %v, %d fetches, want the rotated key", err, server.fetches)
+	}
+
+	// The last keys are kept when the provider is down
+	server.set(nil)
+	keys.checkedAt = time.Time{}
+	keys.fetchedAt = time.Time{}
+	if _, err = keys.Key(ctx, "rsa-1"); err != nil || server.fetches != 3 {
+		t.Errorf("Key(rsa-1) with the provider down = %v, %d fetches, want the last key", err, server.fetches)
+	}
+
+	// Never fetched
+	down := NewUrlKeySet(server.URL, time.Second, time.Hour)
+	if _, err = down.Key(ctx, "rsa-1"); !errors.Is(err, erro.ErrUnauthorized) {
+		t.Errorf("Key without jwks err = %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+// Min interval between two fetches of the JWKS url when a token has an unknown kid
+const minRefreshInterval = 30 * time.Second
+
+// KeySet gives the public key of a kid, kid may be empty when the set has a single key
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+type jwk struct {
+	Kty		string	`json:"kty"`
+	Kid		string	`json:"kid"`
+	Use		string	`json:"use"`
+	N		string	`json:"n"`
+	E		string	`json:"e"`
+	Crv		string	`json:"crv"`
+	X		string	`json:"x"`
+	Y		string	`json:"y"`
+}
+
+type jwks struct {
+	Keys	[]jwk	`json:"keys"`
+}
+
+// parseJWKS reads the RSA and EC (P-256) signing keys of a JWK set, the others are skipped
+func parseJWKS(content []byte) (map[string]crypto.PublicKey, error) {
+	set := jwks{}
+	err := json.Unmarshal(content, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		var public_key crypto.PublicKey
+		switch key.Kty {
+		case "RSA":
+			public_key, err = rsaKey(key)
+		case "EC":
+			public_key, err = ecKey(key)
+		default:
+			childLogger.Warn().Str("kid", key.Kid).Str("kty", key.Kty).Msg("JWK type not supported, skipped")
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwk %s: %s", key.Kid, err.Error())
+		}
+		keys[key.Kid] = public_key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks without RSA or EC signing keys")
+	}
+
+	return keys, nil
+}
+
+func rsaKey(key jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid RSA key")
+	}
+	return &rsa.PublicKey{ N: new(big.Int).SetBytes(n), E: int(exponent.Int64()) }, nil
+}
+
+func ecKey(key jwk) (*ecdsa.PublicKey, error) {
+	if key.Crv != "P-256" {
+		return nil, fmt.Errorf("curve %s not supported", key.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(key.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(key.Y)
+	if err != nil {
+		return nil, err
+	}
+	public_key := &ecdsa.PublicKey{ Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y) }
+	if !public_key.Curve.IsOnCurve(public_key.X, public_key.Y) {
+		return nil, fmt.Errorf("invalid EC key")
+	}
+	return public_key, nil
+}
+
+func lookup(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+// ------------------- file -------------------
+
+// FileKeySet reads the JWKS from a file, loaded once
+type FileKeySet struct {
+	keys	map[string]crypto.PublicKey
+}
+
+func NewFileKeySet(filePath string) (*FileKeySet, error) {
+	childLogger.Debug().Str("file", filePath).Msg("NewFileKeySet")
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileKeySet{ keys: keys }, nil
+}
+
+func (k *FileKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, ok := lookup(k.keys, kid)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown kid %s", erro.ErrUnauthorized, kid)
+	}
+	return key, nil
+}
+
+// ------------------- url -------------------
+
+// UrlKeySet fetches the JWKS from the identity provider and keeps it for ttl. An unknown
+// kid (key rotation) fetches it again, at most once every minRefreshInterval, and the last
+// keys are kept when the provider does not answer
+type UrlKeySet struct {
+	url			string
+	client		*http.Client
+	ttl			time.Duration
+	mutex		sync.Mutex
+	keys		map[string]crypto.PublicKey
+	fetchedAt	time.Time
+	checkedAt	time.Time
+}
+
+func NewUrlKeySet(url string, timeout time.Duration, ttl time.Duration) *UrlKeySet {
+	childLogger.Debug().Str("url", url).Msg("NewUrlKeySet")
+
+	return &UrlKeySet{
+		url:	url,
+		client:	tracing.Client(&http.Client{Timeout: timeout}),
+		ttl:	ttl,
+	}
+}
+
+func (k *UrlKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key, ok := lookup(k.keys, kid)
+	if ok && time.Since(k.fetchedAt) < k.ttl {
+		return key, nil
+	}
+	if time.Since(k.checkedAt) >= minRefreshInterval {
+		k.checkedAt = time.Now()
+		err := k.fetch(ctx)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("error fetch JWKS")
+		}
+		key, ok = lookup(k.keys, kid)
+	}
+
+	switch {
+	case k.keys == nil:
+		return nil, fmt.Errorf("%w: jwks unavailable", erro.ErrUnauthorized)
+	case !ok:
+		return nil, fmt.Errorf("%w: unknown kid %s", erro.ErrUnauthorized, kid)
+	}
+	return key, nil
+}
+
+func (k *UrlKeySet) fetch(ctx context.Context) error {
+	ctx, root := tracing.Start(ctx, "Auth.FetchJWKS")
+	defer func() {
+		root.End(nil)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("jwks status %d", resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1 << 20))
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return err
+	}
+
+	k.keys = keys
+	k.fetchedAt = time.Now()
+	return nil
+}
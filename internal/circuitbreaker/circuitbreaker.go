@@ -1,25 +1,194 @@
 package circuitbreaker
 
 import (
-	"github.com/sony/gobreaker"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
-    "github.com/go-rest-balance-charges/internal/erro"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sony/gobreaker"
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+var childLogger = log.With().Str("circuitbreaker", "Registry").Logger()
+
+// The states are kept in the memory of each pod, the status tells the pod that answered
+var podName, _ = os.Hostname()
+
+const (
+	ForceNone	int32 = iota
+	ForceOpen
+	ForceClosed
 )
 
-func CircuitBreakerConfig() *gobreaker.CircuitBreaker {
-    settings := gobreaker.Settings{
-                                        Name:    "server-circuit-breaker",
-                                        Timeout: 5 * time.Second,
-                                        Interval: 10 * time.Second,
-                                        IsSuccessful: func(err error) bool {
-                                            if (err == erro.ErrNotFound) || (err == nil) {
-                                                return true
-                                            } 
-                                            return false
-                                        },
-                                        ReadyToTrip: func(counts gobreaker.Counts) bool {
-                                            return counts.TotalFailures >= 3
-                                        },
-    }
-    return gobreaker.NewCircuitBreaker(settings)
+// Settings of a breaker, it opens after MaxFailures consecutive failures and tries
+// MaxRequests calls (half-open) after Timeout. Interval clears the counts while closed
+type Settings struct {
+	MaxFailures		uint32
+	MaxRequests		uint32
+	Timeout			time.Duration
+	Interval		time.Duration
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		MaxFailures:	3,
+		MaxRequests:	1,
+		Timeout:		5 * time.Second,
+		Interval:		10 * time.Second,
+	}
+}
+
+// Breaker is a gobreaker.CircuitBreaker that an admin can force open or closed
+type Breaker struct {
+	*gobreaker.CircuitBreaker
+	forced	*int32
+}
+
+// Execute runs req through the breaker, the calls refused by an open (or half-open and
+// full) breaker fail with erro.ErrBreakerOpen
+func (b *Breaker) Execute(req func() (interface{}, error)) (interface{}, error) {
+	switch atomic.LoadInt32(b.forced) {
+	case ForceOpen:
+		return nil, fmt.Errorf("%w: %s (%s)", erro.ErrBreakerOpen, b.Name(), gobreaker.ErrOpenState.Error())
+	case ForceClosed:
+		return req()
+	}
+	res, err := b.CircuitBreaker.Execute(req)
+	if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+		return nil, fmt.Errorf("%w: %s (%s)", erro.ErrBreakerOpen, b.Name(), err.Error())
+	}
+	return res, err
+}
+
+// Run is Execute for the calls that return their results by closure
+func (b *Breaker) Run(req func() error) error {
+	_, err := b.Execute(func() (interface{}, error) {
+		return nil, req()
+	})
+	return err
+}
+
+type BreakerCounts struct {
+	Requests				uint32	`json:"requests"`
+	TotalSuccesses			uint32	`json:"total_successes"`
+	TotalFailures			uint32	`json:"total_failures"`
+	ConsecutiveSuccesses	uint32	`json:"consecutive_successes"`
+	ConsecutiveFailures		uint32	`json:"consecutive_failures"`
+}
+
+type BreakerStatus struct {
+	Name		string			`json:"name"`
+	State		string			`json:"state"`
+	Forced		string			`json:"forced,omitempty"`
+	Scope		string			`json:"scope,omitempty"`
+	Pod			string			`json:"pod"`
+	Counts		BreakerCounts	`json:"counts"`
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	counts := b.Counts()
+	status := BreakerStatus{
+		Name:	b.Name(),
+		State:	b.State().String(),
+		Pod:	podName,
+		Counts:	BreakerCounts{	Requests: counts.Requests,
+								TotalSuccesses: counts.TotalSuccesses,
+								TotalFailures: counts.TotalFailures,
+								ConsecutiveSuccesses: counts.ConsecutiveSuccesses,
+								ConsecutiveFailures: counts.ConsecutiveFailures,
+							},
+	}
+	switch atomic.LoadInt32(b.forced) {
+	case ForceOpen:
+		status.Forced = "open"
+	case ForceClosed:
+		status.Forced = "closed"
+	}
+	return status
+}
+
+// Registry keeps a breaker per dependency (postgres, redis, balance)
+type Registry struct {
+	mutex		sync.RWMutex
+	breakers	map[string]*Breaker
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		breakers: map[string]*Breaker{},
+	}
+}
+
+// Register creates the breaker of the dependency, isSuccessful tells the errors that are
+// not a failure of the dependency (not found, cancelled by the client...)
+func (r *Registry) Register(name string, settings Settings, isSuccessful func(err error) bool) *Breaker {
+	childLogger.Debug().Str("name", name).Interface("settings", settings).Msg("Register")
+
+	breaker := &Breaker{
+		CircuitBreaker: gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:			name,
+			MaxRequests:	settings.MaxRequests,
+			Timeout:		settings.Timeout,
+			Interval:		settings.Interval,
+			IsSuccessful:	isSuccessful,
+			ReadyToTrip: func(counts gobreaker.Counts) bool {
+				return counts.ConsecutiveFailures >= settings.MaxFailures
+			},
+			OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+				childLogger.Warn().Str("breaker", name).Str("from", from.String()).Str("to", to.String()).Msg("Circuit breaker state change")
+			},
+		}),
+		forced: new(int32),
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.breakers[name] = breaker
+
+	return breaker
+}
+
+func (r *Registry) Status() []BreakerStatus {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	list := []BreakerStatus{}
+	for _, breaker := range r.breakers {
+		list = append(list, breaker.Status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// Force keeps the breaker open (every call fails fast) or closed (every call goes
+// through, not counted) until reset. Only the breaker of this pod is forced, the call
+// must reach every pod
+func (r *Registry) Force(name string, action string) (*BreakerStatus, error) {
+	r.mutex.RLock()
+	breaker, ok := r.breakers[name]
+	r.mutex.RUnlock()
+	if !ok {
+		return nil, erro.ErrNotFound
+	}
+
+	switch action {
+	case "open":
+		atomic.StoreInt32(breaker.forced, ForceOpen)
+	case "close":
+		atomic.StoreInt32(breaker.forced, ForceClosed)
+	case "reset":
+		atomic.StoreInt32(breaker.forced, ForceNone)
+	default:
+		return nil, erro.ErrBreakerAction
+	}
+	childLogger.Warn().Str("breaker", name).Str("action", action).Str("pod", podName).Msg("Circuit breaker forced")
+
+	status := breaker.Status()
+	status.Scope = "pod"
+	return &status, nil
 }
\ No newline at end of file
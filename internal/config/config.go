@@ -0,0 +1,293 @@
+package config
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/auth"
+	"github.com/go-rest-balance-charges/internal/tracing"
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+	"github.com/go-rest-balance-charges/internal/adapter/restapi"
+)
+
+var childLogger = log.With().Str("config", "config").Logger()
+
+// Config is the effective configuration of the service: the defaults, overridden by the
+// config file, then by the env vars and then by the flags. The durations are integers in
+// the unit of their env var (seconds, or ms where noted)
+type Config struct {
+	ApiVersion		string			`json:"api_version"`
+	PodName			string			`json:"pod_name"`
+	NoAZ			bool			`json:"no_az"`
+	Server			Server			`json:"server"`
+	Repository		string			`json:"repository"`
+	Database		Database		`json:"database"`
+	Redis			Redis			`json:"redis"`
+	Balance			Balance			`json:"balance"`
+	Outbox			Outbox			`json:"outbox"`
+	Idempotency		Idempotency		`json:"idempotency"`
+	HoldSweepInterval	int			`json:"hold_sweep_interval"`
+	SagaResumeInterval	int			`json:"saga_resume_interval"`
+	Reconciliation	Reconciliation	`json:"reconciliation"`
+	Fx				Fx				`json:"fx"`
+	ChargeMaxAmount	string			`json:"charge_max_amount"`
+	Auth			Auth			`json:"auth"`
+	Tracing			Tracing			`json:"tracing"`
+	Ready			Ready			`json:"ready"`
+	Breakers		Breakers		`json:"breakers"`
+}
+
+// Server, the timeouts in seconds
+type Server struct {
+	Port			int		`json:"port"`
+	ReadTimeout		int		`json:"read_timeout"`
+	WriteTimeout	int		`json:"write_timeout"`
+	IdleTimeout		int		`json:"idle_timeout"`
+	CtxTimeout		int		`json:"ctx_timeout"`
+}
+
+// Database, the credentials are User and Password or read from UserFile and PasswordFile
+// (the secret mounted in the pod)
+type Database struct {
+	Host				string	`json:"host"`
+	Port				string	`json:"port"`
+	Schema				string	`json:"schema"`
+	DatabaseName		string	`json:"database_name"`
+	User				string	`json:"user"`
+	Password			string	`json:"password"`
+	Timeout				int		`json:"db_timeout"`
+	Driver				string	`json:"postgres_driver"`
+	UserFile			string	`json:"user_file"`
+	PasswordFile		string	`json:"password_file"`
+	MigrateOnStartup	bool	`json:"migrate_on_startup"`
+}
+
+// Redis, mode cluster (ClusterAddress), single (Address) or memory
+type Redis struct {
+	Mode			string		`json:"mode"`
+	Address			string		`json:"address"`
+	ClusterAddress	[]string	`json:"cluster_address"`
+	DB				int			`json:"db"`
+	Username		string		`json:"username"`
+	Password		string		`json:"password"`
+	PasswordFile	string		`json:"password_file"`
+}
+
+// Balance is the go-rest-balance client, Timeout in seconds and the backoffs in ms
+type Balance struct {
+	Url					string	`json:"url"`
+	GetPath				string	`json:"get_path"`
+	UpdatePath			string	`json:"update_path"`
+	HealthPath			string	`json:"health_path"`
+	Timeout				int		`json:"timeout"`
+	MaxIdleConns		int		`json:"max_idle_conns"`
+	RetryMaxAttempts	int		`json:"retry_max_attempts"`
+	RetryBaseBackoff	int		`json:"retry_base_backoff"`
+	RetryMaxBackoff		int		`json:"retry_max_backoff"`
+	RetryBudget			float64	`json:"retry_budget"`
+}
+
+type Outbox struct {
+	Publisher		string	`json:"publisher"`
+	FilePath		string	`json:"file_path"`
+	WebhookUrl		string	`json:"webhook_url"`
+	Interval		int		`json:"interval"`
+	MaxAttempts		int		`json:"max_attempts"`
+}
+
+// Idempotency, Ttl and SweepInterval in seconds
+type Idempotency struct {
+	Ttl				int		`json:"ttl"`
+	SweepInterval	int		`json:"sweep_interval"`
+}
+
+type Reconciliation struct {
+	Interval		int		`json:"interval"`
+	AutoCorrect		bool	`json:"auto_correct"`
+}
+
+type Fx struct {
+	Mode			string	`json:"mode"`
+	Provider		string	`json:"provider"`
+	RatesFile		string	`json:"rates_file"`
+	RatesUrl		string	`json:"rates_url"`
+	RatesTtl		int		`json:"rates_ttl"`
+}
+
+// Auth needs a JWKS unless Disabled is set explicitly
+type Auth struct {
+	Disabled		bool	`json:"disabled"`
+	JwksFile		string	`json:"jwks_file"`
+	JwksUrl			string	`json:"jwks_url"`
+	JwksTtl			int		`json:"jwks_ttl"`
+	Issuer			string	`json:"issuer"`
+	Audience		string	`json:"audience"`
+	TenantClaim		string	`json:"tenant_claim"`
+	Leeway			int		`json:"leeway"`
+}
+
+type Tracing struct {
+	Tracer			string	`json:"tracer"`
+	Exporter		string	`json:"exporter"`
+	Endpoint		string	`json:"endpoint"`
+	Insecure		bool	`json:"insecure"`
+	ServiceName		string	`json:"service_name"`
+	SampleRatio		float64	`json:"sample_ratio"`
+}
+
+// Ready, Timeout and CacheTtl in ms
+type Ready struct {
+	Timeout			int		`json:"timeout"`
+	CacheTtl		int		`json:"cache_ttl"`
+}
+
+// Breaker, Timeout and Interval in seconds
+type Breaker struct {
+	MaxFailures		int		`json:"max_failures"`
+	MaxRequests		int		`json:"max_requests"`
+	Timeout			int		`json:"timeout"`
+	Interval		int		`json:"interval"`
+}
+
+type Breakers struct {
+	Postgres		Breaker	`json:"postgres"`
+	Redis			Breaker	`json:"redis"`
+	Balance			Breaker	`json:"balance"`
+	Fx				Breaker	`json:"fx"`
+}
+
+// Default is the configuration of a local run
+func Default() Config {
+	breaker := newBreaker(circuitbreaker.DefaultSettings())
+
+	return Config{
+		NoAZ:			true,
+		Server:			Server{ Port: 5001, ReadTimeout: 60, WriteTimeout: 60, IdleTimeout: 60, CtxTimeout: 60 },
+		Repository:		"postgres",
+		Database:		Database{	Host: "127.0.0.1",
+									Port: "5432",
+									Schema: "public",
+									DatabaseName: "postgres",
+									Timeout: 90,
+									Driver: "postgres",
+									UserFile: "/var/pod/secret/username",
+									PasswordFile: "/var/pod/secret/password",
+									MigrateOnStartup: true },
+		Redis:			Redis{	Mode: "cluster",
+								Address: "127.0.0.1:6379",
+								ClusterAddress: []string{ "clustercfg.memdb-arch.vovqz2.memorydb.us-east-2.amazonaws.com:6379" } },
+		Balance:		Balance{	Url: "http://localhost:5000",
+									GetPath: "/get",
+									UpdatePath: "/update",
+									HealthPath: "/health",
+									Timeout: 29,
+									MaxIdleConns: 100,
+									RetryMaxAttempts: 3,
+									RetryBaseBackoff: 100,
+									RetryMaxBackoff: 2000,
+									RetryBudget: 0.2 },
+		Outbox:			Outbox{ Publisher: "log", FilePath: "/tmp/balance-charges-events.jsonl", Interval: 5, MaxAttempts: 20 },
+		Idempotency:	Idempotency{ Ttl: 86400, SweepInterval: 3600 },
+		HoldSweepInterval:	30,
+		SagaResumeInterval:	60,
+		Reconciliation:	Reconciliation{ Interval: 3600 },
+		Fx:				Fx{ Mode: "reject", Provider: "static", RatesFile: "/var/pod/fx/rates.json", RatesTtl: 60 },
+		Tracing:		Tracing{ Tracer: "xray", Exporter: "otlp", ServiceName: "go-rest-balance-charges", SampleRatio: 1 },
+		Ready:			Ready{ Timeout: 2000, CacheTtl: 2000 },
+		Breakers:		Breakers{ Postgres: breaker, Redis: breaker, Balance: breaker, Fx: breaker },
+	}
+}
+
+func newBreaker(settings circuitbreaker.Settings) Breaker {
+	return Breaker{	MaxFailures: int(settings.MaxFailures),
+					MaxRequests: int(settings.MaxRequests),
+					Timeout: int(settings.Timeout / time.Second),
+					Interval: int(settings.Interval / time.Second) }
+}
+
+func (b Breaker) Settings() circuitbreaker.Settings {
+	return circuitbreaker.Settings{	MaxFailures: uint32(b.MaxFailures),
+									MaxRequests: uint32(b.MaxRequests),
+									Timeout: time.Duration(b.Timeout) * time.Second,
+									Interval: time.Duration(b.Interval) * time.Second }
+}
+
+func (s Server) CoreServer() core.Server {
+	return core.Server{	Port: s.Port,
+						ReadTimeout: s.ReadTimeout,
+						WriteTimeout: s.WriteTimeout,
+						IdleTimeout: s.IdleTimeout,
+						CtxTimeout: s.CtxTimeout }
+}
+
+func (d Database) DatabaseRDS() core.DatabaseRDS {
+	return core.DatabaseRDS{	Host: d.Host,
+								Port: d.Port,
+								Schema: d.Schema,
+								DatabaseName: d.DatabaseName,
+								User: d.User,
+								Password: d.Password,
+								Db_timeout: d.Timeout,
+								Postgres_Driver: d.Driver }
+}
+
+func (b Balance) ClientConfig() restapi.BalanceClientConfig {
+	return restapi.BalanceClientConfig{
+		ServerUrlDomain:	b.Url,
+		GetPath:			b.GetPath,
+		UpdatePath:			b.UpdatePath,
+		HealthPath:			b.HealthPath,
+		Timeout:			time.Duration(b.Timeout) * time.Second,
+		MaxIdleConns:		b.MaxIdleConns,
+		Retry:				restapi.RetryPolicy{	MaxAttempts: b.RetryMaxAttempts,
+													BaseBackoff: time.Duration(b.RetryBaseBackoff) * time.Millisecond,
+													MaxBackoff: time.Duration(b.RetryMaxBackoff) * time.Millisecond,
+													Budget: restapi.NewRetryBudget(b.RetryBudget, 10) },
+	}
+}
+
+func (r Redis) Options() *redis.Options {
+	return &redis.Options{ Addr: r.Address, DB: r.DB, Username: r.Username, Password: r.Password }
+}
+
+func (r Redis) ClusterOptions() *redis.ClusterOptions {
+	return &redis.ClusterOptions{ Addrs: r.ClusterAddress, Username: r.Username, Password: r.Password }
+}
+
+func (a Auth) AuthConfig() auth.Config {
+	return auth.Config{
+		JwksFile:		a.JwksFile,
+		JwksUrl:		a.JwksUrl,
+		JwksTtl:		time.Duration(a.JwksTtl) * time.Second,
+		Issuer:			a.Issuer,
+		Audience:		a.Audience,
+		TenantClaim:	a.TenantClaim,
+		Leeway:			time.Duration(a.Leeway) * time.Second,
+	}
+}
+
+func (t Tracing) TracingConfig() tracing.Config {
+	return tracing.Config{
+		Tracer:			t.Tracer,
+		Exporter:		t.Exporter,
+		Endpoint:		t.Endpoint,
+		Insecure:		t.Insecure,
+		ServiceName:	t.ServiceName,
+		SampleRatio:	t.SampleRatio,
+	}
+}
+
+// splitList splits a comma separated list, dropping the blanks
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
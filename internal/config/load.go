@@ -0,0 +1,354 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+// binding is a setting of the file at the key path key (database.host), read from the
+// env var env and from the flag of the same name in lower case with '-' (DB_HOST and -db-host)
+type binding struct {
+	key		string
+	env		string
+	value	flag.Value
+	usage	string
+}
+
+func (b binding) flagName() string {
+	return strings.ToLower(strings.ReplaceAll(b.env, "_", "-"))
+}
+
+func (c *Config) bindings() []binding {
+	return []binding{
+		{ "api_version", "API_VERSION", stringValue{ &c.ApiVersion }, "version of the api" },
+		{ "pod_name", "POD_NAME", stringValue{ &c.PodName }, "name of the pod" },
+		{ "no_az", "NO_AZ", boolValue{ &c.NoAZ }, "do not ask the availability zone to the EC2 metadata" },
+		{ "server.port", "PORT", intValue{ &c.Server.Port }, "http port" },
+		{ "server.read_timeout", "HTTP_READ_TIMEOUT", intValue{ &c.Server.ReadTimeout }, "http read timeout (s)" },
+		{ "server.write_timeout", "HTTP_WRITE_TIMEOUT", intValue{ &c.Server.WriteTimeout }, "http write timeout (s)" },
+		{ "server.idle_timeout", "HTTP_IDLE_TIMEOUT", intValue{ &c.Server.IdleTimeout }, "http idle timeout (s)" },
+		{ "server.ctx_timeout", "HTTP_CTX_TIMEOUT", intValue{ &c.Server.CtxTimeout }, "timeout of the graceful shutdown (s)" },
+		{ "repository", "REPOSITORY", stringValue{ &c.Repository }, "postgres or memory" },
+		{ "database.host", "DB_HOST", stringValue{ &c.Database.Host }, "database host" },
+		{ "database.port", "DB_PORT", stringValue{ &c.Database.Port }, "database port" },
+		{ "database.database_name", "DB_NAME", stringValue{ &c.Database.DatabaseName }, "database name" },
+		{ "database.schema", "DB_SCHEMA", stringValue{ &c.Database.Schema }, "database schema" },
+		{ "database.db_timeout", "DB_TIMEOUT", intValue{ &c.Database.Timeout }, "database timeout (s)" },
+		{ "database.user", "DB_USER", stringValue{ &c.Database.User }, "database user, read from DB_USER_FILE when empty" },
+		{ "database.password", "DB_PASSWORD", stringValue{ &c.Database.Password }, "database password, read from DB_PASSWORD_FILE when empty" },
+		{ "database.user_file", "DB_USER_FILE", stringValue{ &c.Database.UserFile }, "file with the database user" },
+		{ "database.password_file", "DB_PASSWORD_FILE", stringValue{ &c.Database.PasswordFile }, "file with the database password" },
+		{ "database.migrate_on_startup", "DB_MIGRATE_ON_STARTUP", boolValue{ &c.Database.MigrateOnStartup }, "apply the migrations on startup" },
+		{ "redis.mode", "REDIS_MODE", stringValue{ &c.Redis.Mode }, "cluster, single or memory" },
+		{ "redis.address", "REDIS_ADDRESS", stringValue{ &c.Redis.Address }, "redis host:port (single)" },
+		{ "redis.cluster_address", "REDIS_CLUSTER_ADDRESS", listValue{ &c.Redis.ClusterAddress }, "redis host:port list, comma separated (cluster)" },
+		{ "redis.db", "REDIS_DB_NAME", intValue{ &c.Redis.DB }, "redis db (single)" },
+		{ "redis.username", "REDIS_USERNAME", stringValue{ &c.Redis.Username }, "redis user" },
+		{ "redis.password", "REDIS_PASSWORD", stringValue{ &c.Redis.Password }, "redis password, read from REDIS_PASSWORD_FILE when empty" },
+		{ "redis.password_file", "REDIS_PASSWORD_FILE", stringValue{ &c.Redis.PasswordFile }, "file with the redis password" },
+		{ "balance.url", "SERVER_URL_DOMAIN", stringValue{ &c.Balance.Url }, "base url of go-rest-balance" },
+		{ "balance.get_path", "SERVER_PATH", stringValue{ &c.Balance.GetPath }, "get path of go-rest-balance" },
+		{ "balance.update_path", "SERVER_UPDATE_PATH", stringValue{ &c.Balance.UpdatePath }, "update path of go-rest-balance" },
+		{ "balance.health_path", "SERVER_HEALTH_PATH", stringValue{ &c.Balance.HealthPath }, "health path of go-rest-balance" },
+		{ "balance.timeout", "BALANCE_TIMEOUT", intValue{ &c.Balance.Timeout }, "timeout of a request to go-rest-balance (s)" },
+		{ "balance.max_idle_conns", "BALANCE_MAX_IDLE_CONNS", intValue{ &c.Balance.MaxIdleConns }, "connections kept open to go-rest-balance" },
+		{ "balance.retry_max_attempts", "BALANCE_RETRY_MAX_ATTEMPTS", intValue{ &c.Balance.RetryMaxAttempts }, "attempts of a request to go-rest-balance" },
+		{ "balance.retry_base_backoff", "BALANCE_RETRY_BASE_BACKOFF", intValue{ &c.Balance.RetryBaseBackoff }, "base backoff of the retries (ms)" },
+		{ "balance.retry_max_backoff", "BALANCE_RETRY_MAX_BACKOFF", intValue{ &c.Balance.RetryMaxBackoff }, "max backoff of the retries (ms)" },
+		{ "balance.retry_budget", "BALANCE_RETRY_BUDGET", floatValue{ &c.Balance.RetryBudget }, "retries allowed per request" },
+		{ "outbox.publisher", "OUTBOX_PUBLISHER", stringValue{ &c.Outbox.Publisher }, "log, file or webhook" },
+		{ "outbox.file_path", "OUTBOX_FILE_PATH", stringValue{ &c.Outbox.FilePath }, "file of the file publisher" },
+		{ "outbox.webhook_url", "OUTBOX_WEBHOOK_URL", stringValue{ &c.Outbox.WebhookUrl }, "url of the webhook publisher" },
+		{ "outbox.interval", "OUTBOX_INTERVAL", intValue{ &c.Outbox.Interval }, "interval of the outbox relay (s)" },
+		{ "outbox.max_attempts", "OUTBOX_MAX_ATTEMPTS", intValue{ &c.Outbox.MaxAttempts }, "attempts to publish an event before it is DEAD" },
+		{ "idempotency.ttl", "IDEMPOTENCY_TTL", intValue{ &c.Idempotency.Ttl }, "time the idempotency keys are kept (s)" },
+		{ "idempotency.sweep_interval", "IDEMPOTENCY_SWEEP_INTERVAL", intValue{ &c.Idempotency.SweepInterval }, "interval of the expired idempotency keys sweep (s)" },
+		{ "hold_sweep_interval", "HOLD_SWEEP_INTERVAL", intValue{ &c.HoldSweepInterval }, "interval of the expired holds sweep (s)" },
+		{ "saga_resume_interval", "SAGA_RESUME_INTERVAL", intValue{ &c.SagaResumeInterval }, "interval of the resume of the incomplete sagas (s)" },
+		{ "reconciliation.interval", "RECONCILIATION_INTERVAL", intValue{ &c.Reconciliation.Interval }, "interval of the reconciliation (s), 0 disables it" },
+		{ "reconciliation.auto_correct", "RECONCILIATION_AUTO_CORRECT", boolValue{ &c.Reconciliation.AutoCorrect }, "fix the confirmed discrepancies" },
+		{ "fx.mode", "FX_MODE", stringValue{ &c.Fx.Mode }, "reject or convert" },
+		{ "fx.provider", "FX_PROVIDER", stringValue{ &c.Fx.Provider }, "static or http" },
+		{ "fx.rates_file", "FX_RATES_FILE", stringValue{ &c.Fx.RatesFile }, "file of the static rates" },
+		{ "fx.rates_url", "FX_RATES_URL", stringValue{ &c.Fx.RatesUrl }, "url of the http rates" },
+		{ "fx.rates_ttl", "FX_RATES_TTL", intValue{ &c.Fx.RatesTtl }, "cache of the http rates (s)" },
+		{ "charge_max_amount", "CHARGE_MAX_AMOUNT", stringValue{ &c.ChargeMaxAmount }, "max amount of a charge" },
+		{ "auth.disabled", "AUTH_DISABLED", boolValue{ &c.Auth.Disabled }, "run without authentication (admin endpoints closed)" },
+		{ "auth.jwks_file", "AUTH_JWKS_FILE", stringValue{ &c.Auth.JwksFile }, "file of the JWKS" },
+		{ "auth.jwks_url", "AUTH_JWKS_URL", stringValue{ &c.Auth.JwksUrl }, "url of the JWKS" },
+		{ "auth.jwks_ttl", "AUTH_JWKS_TTL", intValue{ &c.Auth.JwksTtl }, "cache of the JWKS (s)" },
+		{ "auth.issuer", "AUTH_ISSUER", stringValue{ &c.Auth.Issuer }, "expected iss of the tokens" },
+		{ "auth.audience", "AUTH_AUDIENCE", stringValue{ &c.Auth.Audience }, "expected aud of the tokens" },
+		{ "auth.tenant_claim", "AUTH_TENANT_CLAIM", stringValue{ &c.Auth.TenantClaim }, "claim of the tenant" },
+		{ "auth.leeway", "AUTH_LEEWAY", intValue{ &c.Auth.Leeway }, "clock skew allowed (s)" },
+		{ "tracing.tracer", "TRACING", stringValue{ &c.Tracing.Tracer }, "xray or otel" },
+		{ "tracing.exporter", "OTEL_EXPORTER", stringValue{ &c.Tracing.Exporter }, "otlp or stdout" },
+		{ "tracing.endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", stringValue{ &c.Tracing.Endpoint }, "OTLP/HTTP collector" },
+		{ "tracing.insecure", "OTEL_INSECURE", boolValue{ &c.Tracing.Insecure }, "OTLP without TLS" },
+		{ "tracing.service_name", "OTEL_SERVICE_NAME", stringValue{ &c.Tracing.ServiceName }, "service name of the spans" },
+		{ "tracing.sample_ratio", "OTEL_SAMPLE_RATIO", floatValue{ &c.Tracing.SampleRatio }, "ratio of the traces sampled" },
+		{ "ready.timeout", "READY_TIMEOUT", intValue{ &c.Ready.Timeout }, "timeout of each readiness check (ms)" },
+		{ "ready.cache_ttl", "READY_CACHE_TTL", intValue{ &c.Ready.CacheTtl }, "cache of the readiness report (ms)" },
+		{ "breakers.postgres.max_failures", "CB_POSTGRES_MAX_FAILURES", intValue{ &c.Breakers.Postgres.MaxFailures }, "failures that open the postgres breaker" },
+		{ "breakers.postgres.max_requests", "CB_POSTGRES_MAX_REQUESTS", intValue{ &c.Breakers.Postgres.MaxRequests }, "calls of the half-open postgres breaker" },
+		{ "breakers.postgres.timeout", "CB_POSTGRES_TIMEOUT", intValue{ &c.Breakers.Postgres.Timeout }, "open time of the postgres breaker (s)" },
+		{ "breakers.postgres.interval", "CB_POSTGRES_INTERVAL", intValue{ &c.Breakers.Postgres.Interval }, "counts reset of the postgres breaker (s)" },
+		{ "breakers.redis.max_failures", "CB_REDIS_MAX_FAILURES", intValue{ &c.Breakers.Redis.MaxFailures }, "failures that open the redis breaker" },
+		{ "breakers.redis.max_requests", "CB_REDIS_MAX_REQUESTS", intValue{ &c.Breakers.Redis.MaxRequests }, "calls of the half-open redis breaker" },
+		{ "breakers.redis.timeout", "CB_REDIS_TIMEOUT", intValue{ &c.Breakers.Redis.Timeout }, "open time of the redis breaker (s)" },
+		{ "breakers.redis.interval", "CB_REDIS_INTERVAL", intValue{ &c.Breakers.Redis.Interval }, "counts reset of the redis breaker (s)" },
+		{ "breakers.balance.max_failures", "CB_BALANCE_MAX_FAILURES", intValue{ &c.Breakers.Balance.MaxFailures }, "failures that open the balance breaker" },
+		{ "breakers.balance.max_requests", "CB_BALANCE_MAX_REQUESTS", intValue{ &c.Breakers.Balance.MaxRequests }, "calls of the half-open balance breaker" },
+		{ "breakers.balance.timeout", "CB_BALANCE_TIMEOUT", intValue{ &c.Breakers.Balance.Timeout }, "open time of the balance breaker (s)" },
+		{ "breakers.balance.interval", "CB_BALANCE_INTERVAL", intValue{ &c.Breakers.Balance.Interval }, "counts reset of the balance breaker (s)" },
+		{ "breakers.fx.max_failures", "CB_FX_MAX_FAILURES", intValue{ &c.Breakers.Fx.MaxFailures }, "failures that open the fx breaker" },
+		{ "breakers.fx.max_requests", "CB_FX_MAX_REQUESTS", intValue{ &c.Breakers.Fx.MaxRequests }, "calls of the half-open fx breaker" },
+		{ "breakers.fx.timeout", "CB_FX_TIMEOUT", intValue{ &c.Breakers.Fx.Timeout }, "open time of the fx breaker (s)" },
+		{ "breakers.fx.interval", "CB_FX_INTERVAL", intValue{ &c.Breakers.Fx.Interval }, "counts reset of the fx breaker (s)" },
+	}
+}
+
+// Load reads the defaults, the config file (-config or CONFIG_FILE, YAML or JSON), the env
+// vars and the flags, in this order, then the secret files, and validates the result.
+// args are the command line without the program name, the arguments left after the
+// flags (the subcommand) are returned
+func Load(args []string) (*Config, []string, error) {
+	childLogger.Debug().Msg("Load")
+
+	config := Default()
+	bindings := config.bindings()
+
+	// The flags are applied last, they are only kept while the file and the env are read
+	flags := flag.NewFlagSet("go-rest-balance-charges", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "config file, YAML or JSON (env CONFIG_FILE)")
+	for _, b := range bindings {
+		_, is_bool := b.value.(boolValue)
+		flags.Var(&flagValue{ value: b.value.String(), isBool: is_bool }, b.flagName(), b.usage + " (env " + b.env + ")")
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("%w: %s", erro.ErrConfig, err.Error())
+	}
+
+	if *file != "" {
+		err = config.loadFile(*file)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	errs := Errors{}
+	for _, b := range bindings {
+		if value := os.Getenv(b.env); value != "" {
+			err = b.value.Set(value)
+			if err != nil {
+				errs.add(b.key, err.Error())
+			}
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		for _, b := range bindings {
+			if b.flagName() == f.Name {
+				err := b.value.Set(f.Value.String())
+				if err != nil {
+					errs.add(b.key + " (-" + f.Name + ")", err.Error())
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	// A missing secret file is reported with the other problems
+	errs = config.readSecrets()
+	err = config.Validate()
+	if err_validate, ok := err.(Errors); ok {
+		errs = append(errs, err_validate...)
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	return &config, flags.Args(), nil
+}
+
+// loadFile decodes the file with the json tags, YAML being a superset of JSON. Unknown
+// keys are an error, so a typo does not silently keep the default
+func (c *Config) loadFile(path string) error {
+	childLogger.Debug().Str("path", path).Msg("loadFile")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %s", erro.ErrConfig, err.Error())
+	}
+
+	var document interface{}
+	err = yaml.Unmarshal(data, &document)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", erro.ErrConfig, path, err.Error())
+	}
+	payload, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", erro.ErrConfig, path, err.Error())
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(c)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", erro.ErrConfig, path, err.Error())
+	}
+	return nil
+}
+
+// readSecrets reads the credentials that were not given from their files, the database
+// ones only for the postgres repository
+func (c *Config) readSecrets() Errors {
+	errs := Errors{}
+	if c.Repository == "postgres" {
+		err := readSecret(&c.Database.User, c.Database.UserFile)
+		if err != nil {
+			errs.add("database.user_file", err.Error())
+		}
+		err = readSecret(&c.Database.Password, c.Database.PasswordFile)
+		if err != nil {
+			errs.add("database.password_file", err.Error())
+		}
+	}
+	err := readSecret(&c.Redis.Password, c.Redis.PasswordFile)
+	if err != nil {
+		errs.add("redis.password_file", err.Error())
+	}
+
+	return errs
+}
+
+func readSecret(value *string, path string) error {
+	if *value != "" || path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	*value = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+// flagValue keeps the value of a flag until the file and the env are read
+type flagValue struct {
+	value	string
+	isBool	bool
+}
+
+func (f *flagValue) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f != nil && f.isBool
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(value string) error {
+	*v.p = value
+	return nil
+}
+
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(value string) error {
+	intVar, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("inteiro inválido %q", value)
+	}
+	*v.p = intVar
+	return nil
+}
+
+func (v intValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.Itoa(*v.p)
+}
+
+type floatValue struct{ p *float64 }
+
+func (v floatValue) Set(value string) error {
+	floatVar, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return fmt.Errorf("número inválido %q", value)
+	}
+	*v.p = floatVar
+	return nil
+}
+
+func (v floatValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v.p, 'f', -1, 64)
+}
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(value string) error {
+	boolVar, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("booleano inválido %q (true|false)", value)
+	}
+	*v.p = boolVar
+	return nil
+}
+
+func (v boolValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v.p)
+}
+
+type listValue struct{ p *[]string }
+
+func (v listValue) Set(value string) error {
+	*v.p = splitList(value)
+	return nil
+}
+
+func (v listValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
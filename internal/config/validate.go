@@ -0,0 +1,262 @@
+package config
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+// Masked as url.URL.Redacted does, the mask is not escaped in the urls
+const redacted = "xxxxx"
+
+// Errors are all the problems found in the configuration, one per setting, so they can
+// be fixed at once
+type Errors []string
+
+func (e Errors) Error() string {
+	return erro.ErrConfig.Error() + ": " + strings.Join(e, "; ")
+}
+
+func (e Errors) Unwrap() error {
+	return erro.ErrConfig
+}
+
+// envs are the env vars of the key paths of the file
+var envs = func() map[string]string {
+	res := map[string]string{}
+	for _, b := range (&Config{}).bindings() {
+		res[b.key] = b.env
+	}
+	return res
+}()
+
+// add reports the problem of field, the key path of the file, followed by its env var
+// (server.read_timeout (HTTP_READ_TIMEOUT))
+func (e *Errors) add(field string, message string) {
+	if env, ok := envs[field]; ok {
+		field = field + " (" + env + ")"
+	}
+	*e = append(*e, field + ": " + message)
+}
+
+func (e *Errors) oneOf(field string, value string, values ...string) {
+	for _, v := range values {
+		if value == v {
+			return
+		}
+	}
+	e.add(field, strconv.Quote(value) + " inválido (" + strings.Join(values, "|") + ")")
+}
+
+func (e *Errors) positive(field string, value int) {
+	if value <= 0 {
+		e.add(field, "deve ser maior que zero")
+	}
+}
+
+func (e *Errors) notNegative(field string, value int) {
+	if value < 0 {
+		e.add(field, "não pode ser negativo")
+	}
+}
+
+func (e *Errors) address(field string, value string) {
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		e.add(field, strconv.Quote(value) + " inválido (host:porta)")
+		return
+	}
+	e.port(field, port)
+}
+
+func (e *Errors) port(field string, value string) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		e.add(field, strconv.Quote(value) + " porta inválida (1-65535)")
+	}
+}
+
+func (e *Errors) url(field string, value string) {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		e.add(field, strconv.Quote(redactUrl(value)) + " url inválida (http ou https)")
+	}
+}
+
+func (e *Errors) path(field string, value string) {
+	if !strings.HasPrefix(value, "/") {
+		e.add(field, strconv.Quote(value) + " deve começar com /")
+	}
+}
+
+func (e *Errors) breaker(field string, breaker Breaker) {
+	e.positive(field + ".max_failures", breaker.MaxFailures)
+	e.positive(field + ".max_requests", breaker.MaxRequests)
+	e.positive(field + ".timeout", breaker.Timeout)
+	e.notNegative(field + ".interval", breaker.Interval)
+}
+
+// Validate checks every setting, the ones of a dependency only when it is used
+func (c Config) Validate() error {
+	errs := Errors{}
+
+	e := &errs
+	e.port("server.port", strconv.Itoa(c.Server.Port))
+	e.positive("server.read_timeout", c.Server.ReadTimeout)
+	e.positive("server.write_timeout", c.Server.WriteTimeout)
+	e.positive("server.idle_timeout", c.Server.IdleTimeout)
+	e.positive("server.ctx_timeout", c.Server.CtxTimeout)
+
+	e.oneOf("repository", c.Repository, "postgres", "memory")
+	if c.Repository == "postgres" {
+		if c.Database.Host == "" {
+			e.add("database.host", "obrigatório")
+		}
+		e.port("database.port", c.Database.Port)
+		if c.Database.DatabaseName == "" {
+			e.add("database.database_name", "obrigatório")
+		}
+		if c.Database.User == "" {
+			e.add("database.user", "obrigatório (ou database.user_file)")
+		}
+		if c.Database.Password == "" {
+			e.add("database.password", "obrigatório (ou database.password_file)")
+		}
+		e.notNegative("database.db_timeout", c.Database.Timeout)
+	}
+
+	e.oneOf("redis.mode", c.Redis.Mode, "cluster", "single", "memory")
+	switch c.Redis.Mode {
+	case "single":
+		e.address("redis.address", c.Redis.Address)
+		e.notNegative("redis.db", c.Redis.DB)
+	case "cluster":
+		if len(c.Redis.ClusterAddress) == 0 {
+			e.add("redis.cluster_address", "obrigatório")
+		}
+		for _, address := range c.Redis.ClusterAddress {
+			e.address("redis.cluster_address", address)
+		}
+	}
+
+	e.url("balance.url", c.Balance.Url)
+	e.path("balance.get_path", c.Balance.GetPath)
+	e.path("balance.update_path", c.Balance.UpdatePath)
+	e.path("balance.health_path", c.Balance.HealthPath)
+	e.positive("balance.timeout", c.Balance.Timeout)
+	e.positive("balance.max_idle_conns", c.Balance.MaxIdleConns)
+	e.positive("balance.retry_max_attempts", c.Balance.RetryMaxAttempts)
+	e.positive("balance.retry_base_backoff", c.Balance.RetryBaseBackoff)
+	if c.Balance.RetryMaxBackoff < c.Balance.RetryBaseBackoff {
+		e.add("balance.retry_max_backoff", "menor que balance.retry_base_backoff")
+	}
+	if c.Balance.RetryBudget < 0 {
+		e.add("balance.retry_budget", "não pode ser negativo")
+	}
+
+	e.oneOf("outbox.publisher", c.Outbox.Publisher, "log", "file", "webhook")
+	switch c.Outbox.Publisher {
+	case "file":
+		if c.Outbox.FilePath == "" {
+			e.add("outbox.file_path", "obrigatório para o publisher file")
+		}
+	case "webhook":
+		e.url("outbox.webhook_url", c.Outbox.WebhookUrl)
+	}
+	e.positive("outbox.interval", c.Outbox.Interval)
+	e.positive("outbox.max_attempts", c.Outbox.MaxAttempts)
+	e.positive("idempotency.ttl", c.Idempotency.Ttl)
+	e.positive("idempotency.sweep_interval", c.Idempotency.SweepInterval)
+	e.positive("hold_sweep_interval", c.HoldSweepInterval)
+	e.positive("saga_resume_interval", c.SagaResumeInterval)
+	e.notNegative("reconciliation.interval", c.Reconciliation.Interval)
+
+	e.oneOf("fx.mode", c.Fx.Mode, "reject", "convert")
+	if c.Fx.Mode == "convert" {
+		e.oneOf("fx.provider", c.Fx.Provider, "static", "http")
+		switch c.Fx.Provider {
+		case "static":
+			if c.Fx.RatesFile == "" {
+				e.add("fx.rates_file", "obrigatório para o provider static")
+			}
+		case "http":
+			e.url("fx.rates_url", c.Fx.RatesUrl)
+			e.positive("fx.rates_ttl", c.Fx.RatesTtl)
+		}
+	}
+
+	switch {
+	case c.Auth.Disabled && (c.Auth.JwksFile != "" || c.Auth.JwksUrl != ""):
+		e.add("auth.disabled", "não combina com auth.jwks_file ou auth.jwks_url")
+	case !c.Auth.Disabled && c.Auth.JwksFile == "" && c.Auth.JwksUrl == "":
+		e.add("auth.jwks_file", "obrigatório (ou auth.jwks_url) sem auth.disabled=true")
+	}
+	if c.Auth.JwksUrl != "" {
+		e.url("auth.jwks_url", c.Auth.JwksUrl)
+	}
+	e.notNegative("auth.jwks_ttl", c.Auth.JwksTtl)
+	e.notNegative("auth.leeway", c.Auth.Leeway)
+
+	e.oneOf("tracing.tracer", c.Tracing.Tracer, "xray", "otel")
+	if c.Tracing.Tracer == "otel" {
+		e.oneOf("tracing.exporter", c.Tracing.Exporter, "otlp", "stdout")
+		if c.Tracing.SampleRatio <= 0 || c.Tracing.SampleRatio > 1 {
+			e.add("tracing.sample_ratio", "deve estar entre 0 (exclusive) e 1")
+		}
+	}
+
+	e.positive("ready.timeout", c.Ready.Timeout)
+	e.notNegative("ready.cache_ttl", c.Ready.CacheTtl)
+
+	e.breaker("breakers.postgres", c.Breakers.Postgres)
+	e.breaker("breakers.redis", c.Breakers.Redis)
+	e.breaker("breakers.balance", c.Breakers.Balance)
+	e.breaker("breakers.fx", c.Breakers.Fx)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Redacted is a copy to be logged or printed, the passwords are masked and so are the
+// credentials of the urls
+func (c Config) Redacted() Config {
+	if c.Database.Password != "" {
+		c.Database.Password = redacted
+	}
+	if c.Redis.Password != "" {
+		c.Redis.Password = redacted
+	}
+	c.Balance.Url = redactUrl(c.Balance.Url)
+	c.Outbox.WebhookUrl = redactUrl(c.Outbox.WebhookUrl)
+	c.Fx.RatesUrl = redactUrl(c.Fx.RatesUrl)
+	c.Auth.JwksUrl = redactUrl(c.Auth.JwksUrl)
+	c.Tracing.Endpoint = redactUrl(c.Tracing.Endpoint)
+	return c
+}
+
+// redactUrl masks the password of the user info and the values of the query string
+// (tokens are often sent as ?token=)
+func redactUrl(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return value
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), redacted)
+	}
+	if parsed.RawQuery != "" {
+		keys := []string{}
+		for key := range parsed.Query() {
+			keys = append(keys, url.QueryEscape(key) + "=" + redacted)
+		}
+		sort.Strings(keys)
+		parsed.RawQuery = strings.Join(keys, "&")
+	}
+	return parsed.String()
+}
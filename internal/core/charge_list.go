@@ -0,0 +1,104 @@
+package core
+
+import (
+	"time"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+const (
+	SortChargedAtDesc	= "charged_at_desc"
+	SortChargedAtAsc	= "charged_at_asc"
+	SortAmountDesc		= "amount_desc"
+	SortAmountAsc		= "amount_asc"
+
+	DefaultListLimit	= 50
+	MaxListLimit		= 200
+)
+
+func ValidSort(sort string) bool {
+	switch sort {
+	case SortChargedAtDesc, SortChargedAtAsc, SortAmountDesc, SortAmountAsc:
+		return true
+	}
+	return false
+}
+
+// ChargeFilter selects a page of the charges of a balance. From is inclusive and To
+// exclusive, the amount bounds are inclusive. After is the last charge of the previous
+// page (keyset pagination), ties of the sort key are broken by id. TenantID, when set,
+// keeps only the charges of the tenant
+type ChargeFilter struct {
+	FkBalanceID		int
+	TenantID		string
+	Type			string
+	Currency		string
+	From			*time.Time
+	To				*time.Time
+	MinAmount		*Money
+	MaxAmount		*Money
+	Sort			string
+	Limit			int
+	After			*ChargeCursor
+}
+
+// ChargeCursor is the position of a charge in a listing, it is sent to the client as an
+// opaque string and only valid for the same sort
+type ChargeCursor struct {
+	Sort			string		`json:"s"`
+	ID				int			`json:"i"`
+	ChargeAt		time.Time	`json:"t,omitempty"`
+	Amount			string		`json:"a,omitempty"`
+	Currency		string		`json:"c,omitempty"`
+}
+
+type ChargePage struct {
+	Data			[]BalanceCharge	`json:"data"`
+	Limit			int				`json:"limit"`
+	NextCursor		string			`json:"next_cursor,omitempty"`
+}
+
+func NewChargeCursor(sort string, charge BalanceCharge) ChargeCursor {
+	cursor := ChargeCursor{ Sort: sort, ID: charge.ID }
+	switch sort {
+	case SortAmountDesc, SortAmountAsc:
+		cursor.Amount = charge.Amount.String()
+		cursor.Currency = charge.Currency
+	default:
+		cursor.ChargeAt = charge.ChargeAt
+	}
+	return cursor
+}
+
+// Money of the cursor, for the amount sorts
+func (c ChargeCursor) Money() (Money, error) {
+	return ParseMoney(c.Amount, c.Currency)
+}
+
+func (c ChargeCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeChargeCursor(value string, sort string) (*ChargeCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, erro.ErrInvalidCursor
+	}
+
+	cursor := ChargeCursor{}
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.Sort != sort || cursor.ID <= 0 {
+		return nil, erro.ErrInvalidCursor
+	}
+	if sort == SortAmountDesc || sort == SortAmountAsc {
+		if _, err := cursor.Money(); err != nil {
+			return nil, erro.ErrInvalidCursor
+		}
+	}
+
+	return &cursor, nil
+}
@@ -0,0 +1,25 @@
+package core
+
+import (
+	"context"
+	"sync/atomic"
+
+)
+
+type committedCtx struct{}
+
+// WithCommitTracking lets the caller know whether the request left changes that a retry
+// would apply again (e.g. a saga that could not be compensated), see MarkCommitted
+func WithCommitTracking(ctx context.Context) (context.Context, func() bool) {
+	committed := new(int32)
+	return context.WithValue(ctx, committedCtx{}, committed), func() bool {
+		return atomic.LoadInt32(committed) == 1
+	}
+}
+
+// MarkCommitted, a no-op when the context is not tracked
+func MarkCommitted(ctx context.Context) {
+	if committed, ok := ctx.Value(committedCtx{}).(*int32); ok {
+		atomic.StoreInt32(committed, 1)
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"time"
+	"encoding/json"
 
 )
 
@@ -44,8 +45,16 @@ type BalanceCharge struct {
 	Type			string  	`json:"type_charge,omitempty"`
 	ChargeAt		time.Time 	`json:"charged_at,omitempty"`
 	Currency		string  	`json:"currency,omitempty"`
-	Amount			float64 	`json:"amount,omitempty"`
+	Amount			Money	 	`json:"amount"`
 	TenantID		string  	`json:"tenant_id,omitempty"`
+	ReversalOf		int			`json:"reversal_of,omitempty"`
+	OriginalAmount	*Money		`json:"original_amount,omitempty"`
+	FxRate			*FxRate		`json:"fx_rate,omitempty"`
+}
+
+type ChargeReversal struct {
+	AccountID		string		`json:"account_id,omitempty"`
+	Amount			*Money	 	`json:"amount,omitempty"`
 }
 
 type Balance struct {
@@ -53,9 +62,106 @@ type Balance struct {
 	AccountID		string	`json:"account_id,omitempty"`
 	PersonID		string  `json:"person_id,omitempty"`
 	Currency		string  `json:"currency,omitempty"`
-	Amount			float64 `json:"amount,omitempty"`
+	Amount			Money	`json:"amount"`
 	CreateAt		time.Time 	`json:"create_at,omitempty"`
 	UpdateAt		*time.Time 	`json:"update_at,omitempty"`
 	TenantID		string  `json:"tenant_id,omitempty"`
 	UserLastUpdate	*string  `json:"user_last_update,omitempty"`
+}
+type IdempotencyKey struct {
+	Key				string		`json:"idempotency_key"`
+	Operation		string		`json:"operation"`
+	RequestHash		string		`json:"request_hash"`
+	Status			string		`json:"status"`
+	ResponseStatus	int			`json:"response_status,omitempty"`
+	ResponseBody	[]byte		`json:"response_body,omitempty"`
+	CreatedAt		time.Time 	`json:"created_at,omitempty"`
+	UpdatedAt		time.Time 	`json:"updated_at,omitempty"`
+}
+
+type Saga struct {
+	ID				int			`json:"id,omitempty"`
+	Type			string		`json:"saga_type,omitempty"`
+	Status			string		`json:"status,omitempty"`
+	Payload			[]byte		`json:"payload,omitempty"`
+	Error			string		`json:"error,omitempty"`
+	Steps			[]SagaStep	`json:"steps,omitempty"`
+	CreatedAt		time.Time 	`json:"created_at,omitempty"`
+	UpdatedAt		time.Time 	`json:"updated_at,omitempty"`
+}
+
+type SagaStep struct {
+	SagaID			int			`json:"saga_id,omitempty"`
+	Seq				int			`json:"step_seq"`
+	Name			string		`json:"step_name,omitempty"`
+	Status			string		`json:"status,omitempty"`
+	Error			string		`json:"error,omitempty"`
+	UpdatedAt		time.Time 	`json:"updated_at,omitempty"`
+}
+
+type OutboxEvent struct {
+	ID				int64			`json:"event_id"`
+	AggregateID		string			`json:"aggregate_id"`
+	EventType		string			`json:"event_type"`
+	Payload			json.RawMessage	`json:"payload"`
+	Status			string			`json:"-"`
+	Attempts		int				`json:"-"`
+	NextAttemptAt	time.Time		`json:"-"`
+	LastError		string			`json:"-"`
+	CreatedAt		time.Time 		`json:"created_at"`
+}
+
+type BalanceHold struct {
+	ID				int			`json:"id,omitempty"`
+	AccountID		string		`json:"account_id,omitempty"`
+	FkBalanceID		int			`json:"fk_balance_id,omitempty"`
+	Currency		string  	`json:"currency,omitempty"`
+	Amount			Money	 	`json:"amount"`
+	CapturedAmount	Money	 	`json:"captured_amount"`
+	Status			string		`json:"status,omitempty"`
+	ExpiresIn		int			`json:"expires_in,omitempty"`
+	ExpiresAt		time.Time 	`json:"expires_at,omitempty"`
+	ChargeID		int			`json:"charge_id,omitempty"`
+	TenantID		string  	`json:"tenant_id,omitempty"`
+	CreatedAt		time.Time 	`json:"created_at,omitempty"`
+	UpdatedAt		time.Time 	`json:"updated_at,omitempty"`
+}
+
+type HoldCapture struct {
+	Amount			*Money	 	`json:"amount,omitempty"`
+}
+
+type AvailableBalance struct {
+	AccountID		string		`json:"account_id,omitempty"`
+	Currency		string  	`json:"currency,omitempty"`
+	Balance			Money	 	`json:"balance"`
+	Held			Money	 	`json:"held"`
+	Available		Money	 	`json:"available"`
+}
+
+// Reconciliation is a discrepancy between the sum of the charges of a balance (ledger)
+// and the amount reported by go-rest-balance (remote), Difference is remote - ledger
+type Reconciliation struct {
+	ID				int			`json:"id"`
+	AccountID		string		`json:"account_id"`
+	FkBalanceID		int			`json:"fk_balance_id"`
+	Currency		string		`json:"currency"`
+	LedgerAmount	Money		`json:"ledger_amount"`
+	RemoteAmount	Money		`json:"remote_amount"`
+	Difference		Money		`json:"difference"`
+	Status			string		`json:"status"`
+	Checks			int			`json:"checks"`
+	AdjustmentID	int			`json:"adjustment_charge_id,omitempty"`
+	CreatedAt		time.Time 	`json:"created_at"`
+	UpdatedAt		time.Time 	`json:"updated_at"`
+}
+
+type ReconciliationReport struct {
+	Accounts		int			`json:"accounts"`
+	Matched			int			`json:"matched"`
+	Discrepancies	int			`json:"discrepancies"`
+	Corrected		int			`json:"corrected"`
+	Failed			int			`json:"failed"`
+	StartedAt		time.Time	`json:"started_at"`
+	FinishedAt		time.Time	`json:"finished_at"`
 }
\ No newline at end of file
@@ -0,0 +1,29 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+)
+
+// CorrelationHeader carries the id of a request across the services and in the error responses
+const CorrelationHeader = "X-Correlation-ID"
+
+type correlationIDCtx struct{}
+
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDCtx{}, id)
+}
+
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDCtx{}).(string)
+	return id
+}
+
+// NewCorrelationID returns a random id of 16 bytes in hex
+func NewCorrelationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
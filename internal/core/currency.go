@@ -0,0 +1,75 @@
+package core
+
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+// Active codes of ISO 4217 (funds and precious metals excluded)
+var iso4217 = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true,
+	"AWG": true, "AZN": true, "BAM": true, "BBD": true, "BDT": true, "BGN": true, "BHD": true, "BIF": true,
+	"BMD": true, "BND": true, "BOB": true, "BRL": true, "BSD": true, "BTN": true, "BWP": true, "BYN": true,
+	"BZD": true, "CAD": true, "CDF": true, "CHF": true, "CLF": true, "CLP": true, "CNY": true, "COP": true,
+	"CRC": true, "CUP": true, "CVE": true, "CZK": true, "DJF": true, "DKK": true, "DOP": true, "DZD": true,
+	"EGP": true, "ERN": true, "ETB": true, "EUR": true, "FJD": true, "FKP": true, "GBP": true, "GEL": true,
+	"GHS": true, "GIP": true, "GMD": true, "GNF": true, "GTQ": true, "GYD": true, "HKD": true, "HNL": true,
+	"HTG": true, "HUF": true, "IDR": true, "ILS": true, "INR": true, "IQD": true, "IRR": true, "ISK": true,
+	"JMD": true, "JOD": true, "JPY": true, "KES": true, "KGS": true, "KHR": true, "KMF": true, "KPW": true,
+	"KRW": true, "KWD": true, "KYD": true, "KZT": true, "LAK": true, "LBP": true, "LKR": true, "LRD": true,
+	"LSL": true, "LYD": true, "MAD": true, "MDL": true, "MGA": true, "MKD": true, "MMK": true, "MNT": true,
+	"MOP": true, "MRU": true, "MUR": true, "MVR": true, "MWK": true, "MXN": true, "MYR": true, "MZN": true,
+	"NAD": true, "NGN": true, "NIO": true, "NOK": true, "NPR": true, "NZD": true, "OMR": true, "PAB": true,
+	"PEN": true, "PGK": true, "PHP": true, "PKR": true, "PLN": true, "PYG": true, "QAR": true, "RON": true,
+	"RSD": true, "RUB": true, "RWF": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true,
+	"SGD": true, "SHP": true, "SLE": true, "SOS": true, "SRD": true, "SSP": true, "STN": true, "SVC": true,
+	"SYP": true, "SZL": true, "THB": true, "TJS": true, "TMT": true, "TND": true, "TOP": true, "TRY": true,
+	"TTD": true, "TWD": true, "TZS": true, "UAH": true, "UGX": true, "USD": true, "UYI": true, "UYU": true,
+	"UYW": true, "UZS": true, "VES": true, "VND": true, "VUV": true, "WST": true, "XAF": true, "XCD": true,
+	"XOF": true, "XPF": true, "YER": true, "ZAR": true, "ZMW": true, "ZWL": true,
+}
+
+// ValidCurrency tells whether the code is an active ISO 4217 currency, in upper case
+func ValidCurrency(currency string) bool {
+	return iso4217[currency]
+}
+
+// FxRate is the snapshot of the rate used to convert a charge into the currency of the
+// balance: 1 Base = Rate Quote, as given by the Provider at AsOf
+type FxRate struct {
+	Base		string		`json:"base"`
+	Quote		string		`json:"quote"`
+	Rate		string		`json:"rate"`
+	Provider	string		`json:"provider"`
+	AsOf		time.Time	`json:"as_of"`
+}
+
+// Convert applies the rate (a decimal string) and returns the amount in the minor units of
+// currency, rounded half away from zero
+func (m Money) Convert(rate string, currency string) (Money, error) {
+	rate_parsed, ok := new(big.Rat).SetString(strings.TrimSpace(rate))
+	if !ok || rate_parsed.Sign() <= 0 {
+		return Money{}, erro.ErrFxRate
+	}
+
+	value := new(big.Rat).SetInt64(m.Units)
+	value.Mul(value, rate_parsed)
+	value.Mul(value, new(big.Rat).SetFrac(pow10(CurrencyScale(currency)), pow10(CurrencyScale(m.Currency))))
+
+	// |value| + 1/2 truncated, with the sign put back
+	num := new(big.Int).Abs(value.Num())
+	den := value.Denom()
+	units := new(big.Int).Quo(new(big.Int).Add(new(big.Int).Mul(num, big.NewInt(2)), den), new(big.Int).Mul(den, big.NewInt(2)))
+	if value.Sign() < 0 {
+		units.Neg(units)
+	}
+	if !units.IsInt64() {
+		return Money{}, erro.ErrAmountOverflow
+	}
+
+	return Money{Units: units.Int64(), Currency: currency}, nil
+}
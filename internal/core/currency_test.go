@@ -0,0 +1,71 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name		string
+		amount		Money
+		rate		string
+		currency	string
+		want		int64
+		err			error
+	}{
+		{ "same scale", NewMoney(10000, "USD"), "4.9123", "BRL", 49123, nil },
+		{ "JPY to USD", NewMoney(100, "JPY"), "0.0067", "USD", 67, nil },
+		{ "JPY to USD half up", NewMoney(1, "JPY"), "0.005", "USD", 1, nil },
+		{ "JPY to USD below half", NewMoney(1, "JPY"), "0.0049", "USD", 0, nil },
+		{ "JPY to USD negative half", NewMoney(-1, "JPY"), "0.005", "USD", -1, nil },
+		{ "USD to JPY", NewMoney(100, "USD"), "149.505", "JPY", 150, nil },
+		{ "USD to JPY negative", NewMoney(-100, "USD"), "149.505", "JPY", -150, nil },
+		{ "USD to JPY below half", NewMoney(1, "USD"), "149.49", "JPY", 1, nil },
+		{ "BRL to BHD", NewMoney(100, "BRL"), "0.0755", "BHD", 76, nil },
+		{ "BRL to BHD negative", NewMoney(-100, "BRL"), "0.0755", "BHD", -76, nil },
+		{ "BHD to BRL", NewMoney(1000, "BHD"), "13.245", "BRL", 1325, nil },
+		{ "BHD to BRL negative", NewMoney(-1000, "BHD"), "13.245", "BRL", -1325, nil },
+		{ "BHD to BRL below half", NewMoney(1, "BHD"), "13.25", "BRL", 1, nil },
+		{ "negative at half", NewMoney(-1, "USD"), "0.5", "EUR", -1, nil },
+		{ "negative below half", NewMoney(-1, "USD"), "0.4999", "EUR", 0, nil },
+		{ "negative above half", NewMoney(-3, "USD"), "0.5", "EUR", -2, nil },
+		{ "rate with spaces", NewMoney(100, "USD"), " 2 ", "EUR", 200, nil },
+		{ "zero", NewMoney(0, "USD"), "4.9123", "BRL", 0, nil },
+		{ "overflow", NewMoney(math.MaxInt64, "USD"), "2", "EUR", 0, erro.ErrAmountOverflow },
+		{ "overflow of the scale", NewMoney(math.MaxInt64, "JPY"), "1", "BHD", 0, erro.ErrAmountOverflow },
+		{ "empty rate", NewMoney(100, "USD"), "", "BRL", 0, erro.ErrFxRate },
+		{ "invalid rate", NewMoney(100, "USD"), "abc", "BRL", 0, erro.ErrFxRate },
+		{ "zero rate", NewMoney(100, "USD"), "0", "BRL", 0, erro.ErrFxRate },
+		{ "negative rate", NewMoney(100, "USD"), "-4.9", "BRL", 0, erro.ErrFxRate },
+	}
+	for _, tt := range tests {
+		got, err := tt.amount.Convert(tt.rate, tt.currency)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: Convert err = %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got.Units != tt.want || got.Currency != tt.currency {
+			t.Errorf("%s: Convert = %+v, %v, want %d %s", tt.name, got, err, tt.want, tt.currency)
+		}
+	}
+}
+
+func TestValidCurrency(t *testing.T) {
+	for _, currency := range []string{ "BRL", "USD", "JPY", "BHD" } {
+		if !ValidCurrency(currency) {
+			t.Errorf("ValidCurrency(%s) = false", currency)
+		}
+	}
+	for _, currency := range []string{ "", "brl", "XXX", "BR", "BRLL" } {
+		if ValidCurrency(currency) {
+			t.Errorf("ValidCurrency(%q) = true", currency)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"time"
+
+)
+
+const (
+	PostingDebit		= "DEBIT"
+	PostingCredit		= "CREDIT"
+
+	AccountCustomer		= "CUSTOMER"
+	AccountFees			= "FEES"
+	AccountSuspense		= "SUSPENSE"
+	AccountSettlement	= "SETTLEMENT"
+)
+
+// LedgerPosting is one side of a charge in the double-entry ledger, the amount is
+// always positive and the direction tells the side
+type LedgerPosting struct {
+	ID				int64		`json:"id,omitempty"`
+	ChargeID		int			`json:"charge_id"`
+	Account			string		`json:"account"`
+	AccountType		string		`json:"account_type"`
+	Direction		string		`json:"direction"`
+	Amount			Money		`json:"amount"`
+	Currency		string		`json:"currency"`
+	CreatedAt		time.Time	`json:"created_at"`
+}
+
+// LedgerAccountBalance is the sum of the postings of an account. Balance follows the
+// normal side of the account: credits - debits for CUSTOMER and FEES, debits - credits
+// for SETTLEMENT and SUSPENSE
+type LedgerAccountBalance struct {
+	Account			string		`json:"account"`
+	AccountType		string		`json:"account_type"`
+	Currency		string		`json:"currency"`
+	Debits			Money		`json:"debits"`
+	Credits			Money		`json:"credits"`
+	Balance			Money		`json:"balance"`
+}
+
+type TrialBalance struct {
+	Currency		string					`json:"currency"`
+	Accounts		[]LedgerAccountBalance	`json:"accounts"`
+	TotalDebits		Money					`json:"total_debits"`
+	TotalCredits	Money					`json:"total_credits"`
+	Balanced		bool					`json:"balanced"`
+}
+
+func CreditNormal(accountType string) bool {
+	return accountType == AccountCustomer || accountType == AccountFees
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+// Money is an exact amount expressed in the minor units of its currency
+// (e.g. 150.00 BRL is Units 15000). It is encoded in JSON as a decimal string.
+type Money struct {
+	Units		int64
+	Currency	string
+	raw			string
+}
+
+const defaultCurrencyScale = 2
+
+// Number of decimal places of the currencies that do not use 2 (ISO 4217)
+var currencyScale = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+func CurrencyScale(currency string) int {
+	if scale, ok := currencyScale[strings.ToUpper(currency)]; ok {
+		return scale
+	}
+	return defaultCurrencyScale
+}
+
+func NewMoney(units int64, currency string) Money {
+	return Money{Units: units, Currency: currency}
+}
+
+// ParseMoney converts a decimal string into Money, rejecting values with more
+// significant decimal places than the currency allows
+func ParseMoney(value string, currency string) (Money, error) {
+	scale := CurrencyScale(currency)
+
+	negative, integer, fraction, ok := splitDecimal(value)
+	if !ok {
+		return Money{}, erro.ErrInvalidAmount
+	}
+	if len(fraction) > scale {
+		if strings.Trim(fraction[scale:], "0") != "" {
+			return Money{}, erro.ErrAmountScale
+		}
+		fraction = fraction[:scale]
+	}
+	fraction = fraction + strings.Repeat("0", scale - len(fraction))
+
+	units, err := strconv.ParseInt(integer + fraction, 10, 64)
+	if err != nil {
+		return Money{}, erro.ErrAmountOverflow
+	}
+	if negative {
+		units = -units
+	}
+
+	return Money{Units: units, Currency: currency}, nil
+}
+
+// splitDecimal breaks "-123.45" into its sign, integer and fraction digits
+func splitDecimal(value string) (bool, string, string, bool) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	integer, fraction := value, ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		integer, fraction = value[:i], value[i+1:]
+	}
+	if integer == "" || !isDigits(integer) || !isDigits(fraction) {
+		return false, "", "", false
+	}
+	return negative, integer, fraction, true
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) String() string {
+	scale := CurrencyScale(m.Currency)
+
+	sign := ""
+	digits := strconv.FormatUint(uint64(m.Units), 10)
+	if m.Units < 0 {
+		sign = "-"
+		digits = strconv.FormatUint(uint64(-m.Units), 10)
+	}
+	if scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale - len(digits) + 1) + digits
+	}
+
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, erro.ErrCurrencyMismatch
+	}
+	if (other.Units > 0 && m.Units > math.MaxInt64 - other.Units) ||
+		(other.Units < 0 && m.Units < math.MinInt64 - other.Units) {
+		return Money{}, erro.ErrAmountOverflow
+	}
+	return Money{Units: m.Units + other.Units, Currency: m.Currency}, nil
+}
+
+func (m Money) Neg() Money {
+	return Money{Units: -m.Units, Currency: m.Currency}
+}
+
+func (m Money) Sign() int {
+	switch {
+	case m.Units > 0:
+		return 1
+	case m.Units < 0:
+		return -1
+	}
+	return 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Units == 0
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts a decimal string or a JSON number. The scale depends on the
+// currency, which is a sibling field, so the value is only converted by Bind
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = Money{}
+		return nil
+	}
+
+	raw := string(data)
+	if strings.HasPrefix(raw, `"`) {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	}
+	if _, _, _, ok := splitDecimal(raw); !ok {
+		return erro.ErrInvalidAmount
+	}
+
+	*m = Money{raw: raw}
+	return nil
+}
+
+// Bind converts a decimal decoded from JSON into minor units of the currency,
+// it is done by the structs that carry the currency
+func (m *Money) Bind(currency string) error {
+	if m.raw == "" {
+		m.Currency = currency
+		return nil
+	}
+	parsed, err := ParseMoney(m.raw, currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (b *BalanceCharge) UnmarshalJSON(data []byte) error {
+	type balanceCharge BalanceCharge
+	if err := json.Unmarshal(data, (*balanceCharge)(b)); err != nil {
+		return err
+	}
+	// The original amount of a converted charge is in the base currency of the rate
+	if b.OriginalAmount != nil && b.FxRate != nil {
+		if err := b.OriginalAmount.Bind(b.FxRate.Base); err != nil {
+			return err
+		}
+	}
+	return b.Amount.Bind(b.Currency)
+}
+
+func (b *Balance) UnmarshalJSON(data []byte) error {
+	type balance Balance
+	if err := json.Unmarshal(data, (*balance)(b)); err != nil {
+		return err
+	}
+	return b.Amount.Bind(b.Currency)
+}
+
+func (b *BalanceHold) UnmarshalJSON(data []byte) error {
+	type balanceHold BalanceHold
+	if err := json.Unmarshal(data, (*balanceHold)(b)); err != nil {
+		return err
+	}
+	if err := b.Amount.Bind(b.Currency); err != nil {
+		return err
+	}
+	return b.CapturedAmount.Bind(b.Currency)
+}
+
+// Cmp compares the values of two amounts, which may be of currencies with different scales
+func (m Money) Cmp(other Money) int {
+	a := new(big.Int).Mul(big.NewInt(m.Units), pow10(CurrencyScale(other.Currency)))
+	b := new(big.Int).Mul(big.NewInt(other.Units), pow10(CurrencyScale(m.Currency)))
+	return a.Cmp(b)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,254 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+func TestCurrencyScale(t *testing.T) {
+	tests := []struct {
+		currency	string
+		want		int
+	}{
+		{ "BRL", 2 },
+		{ "USD", 2 },
+		{ "JPY", 0 },
+		{ "jpy", 0 },
+		{ "BHD", 3 },
+		{ "CLF", 4 },
+		{ "XXX", 2 },
+	}
+	for _, tt := range tests {
+		if got := CurrencyScale(tt.currency); got != tt.want {
+			t.Errorf("CurrencyScale(%q) = %d, want %d", tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value		string
+		currency	string
+		want		int64
+		err			error
+	}{
+		{ "150.00", "BRL", 15000, nil },
+		{ "150", "BRL", 15000, nil },
+		{ "150.5", "BRL", 15050, nil },
+		{ "0.01", "BRL", 1, nil },
+		{ "-0.01", "BRL", -1, nil },
+		{ "-12.34", "USD", -1234, nil },
+		{ "+1.00", "BRL", 100, nil },
+		{ " 1.00 ", "BRL", 100, nil },
+		{ "1.", "BRL", 100, nil },
+		{ "1.2300", "BRL", 123, nil },
+		{ "1000", "JPY", 1000, nil },
+		{ "1000.0", "JPY", 1000, nil },
+		{ "1.234", "BHD", 1234, nil },
+		{ "-0.001", "BHD", -1, nil },
+		{ "1.2345", "CLF", 12345, nil },
+		{ "92233720368547758.07", "BRL", math.MaxInt64, nil },
+		{ "1.001", "BRL", 0, erro.ErrAmountScale },
+		{ "1.5", "JPY", 0, erro.ErrAmountScale },
+		{ "1.2345", "BHD", 0, erro.ErrAmountScale },
+		{ "92233720368547758.08", "BRL", 0, erro.ErrAmountOverflow },
+		{ "9223372036854775808", "JPY", 0, erro.ErrAmountOverflow },
+		{ "", "BRL", 0, erro.ErrInvalidAmount },
+		{ "-", "BRL", 0, erro.ErrInvalidAmount },
+		{ ".50", "BRL", 0, erro.ErrInvalidAmount },
+		{ "1,50", "BRL", 0, erro.ErrInvalidAmount },
+		{ "1e3", "BRL", 0, erro.ErrInvalidAmount },
+		{ "abc", "BRL", 0, erro.ErrInvalidAmount },
+		{ "1.2.3", "BRL", 0, erro.ErrInvalidAmount },
+		{ "--1", "BRL", 0, erro.ErrInvalidAmount },
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value, tt.currency)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ParseMoney(%q, %s) err = %v, want %v", tt.value, tt.currency, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got.Units != tt.want || got.Currency != tt.currency {
+			t.Errorf("ParseMoney(%q, %s) = %+v, %v, want %d", tt.value, tt.currency, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money	Money
+		want	string
+	}{
+		{ NewMoney(15000, "BRL"), "150.00" },
+		{ NewMoney(1, "BRL"), "0.01" },
+		{ NewMoney(-1, "BRL"), "-0.01" },
+		{ NewMoney(0, "BRL"), "0.00" },
+		{ NewMoney(-1234, "USD"), "-12.34" },
+		{ NewMoney(1000, "JPY"), "1000" },
+		{ NewMoney(-5, "JPY"), "-5" },
+		{ NewMoney(1, "BHD"), "0.001" },
+		{ NewMoney(math.MaxInt64, "BRL"), "92233720368547758.07" },
+		{ NewMoney(math.MinInt64, "BRL"), "-92233720368547758.08" },
+	}
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	tests := []struct {
+		name	string
+		a, b	Money
+		want	int64
+		err		error
+	}{
+		{ "sum", NewMoney(150, "BRL"), NewMoney(-50, "BRL"), 100, nil },
+		{ "to max", NewMoney(math.MaxInt64 - 1, "BRL"), NewMoney(1, "BRL"), math.MaxInt64, nil },
+		{ "to min", NewMoney(math.MinInt64 + 1, "BRL"), NewMoney(-1, "BRL"), math.MinInt64, nil },
+		{ "overflow", NewMoney(math.MaxInt64, "BRL"), NewMoney(1, "BRL"), 0, erro.ErrAmountOverflow },
+		{ "underflow", NewMoney(math.MinInt64, "BRL"), NewMoney(-1, "BRL"), 0, erro.ErrAmountOverflow },
+		{ "currencies", NewMoney(100, "BRL"), NewMoney(100, "USD"), 0, erro.ErrCurrencyMismatch },
+	}
+	for _, tt := range tests {
+		got, err := tt.a.Add(tt.b)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: Add err = %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got.Units != tt.want || got.Currency != tt.a.Currency {
+			t.Errorf("%s: Add = %+v, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyCmp(t *testing.T) {
+	tests := []struct {
+		a, b	Money
+		want	int
+	}{
+		{ NewMoney(100, "BRL"), NewMoney(100, "BRL"), 0 },
+		{ NewMoney(99, "BRL"), NewMoney(100, "BRL"), -1 },
+		{ NewMoney(-100, "BRL"), NewMoney(-101, "BRL"), 1 },
+		// 1.00 USD and 1 JPY, the values are compared, not the units
+		{ NewMoney(100, "USD"), NewMoney(1, "JPY"), 0 },
+		{ NewMoney(1, "JPY"), NewMoney(101, "USD"), -1 },
+		{ NewMoney(1000, "BHD"), NewMoney(100, "BRL"), 0 },
+		{ NewMoney(1001, "BHD"), NewMoney(100, "BRL"), 1 },
+		{ NewMoney(math.MaxInt64, "JPY"), NewMoney(math.MaxInt64, "BHD"), 1 },
+	}
+	for _, tt := range tests {
+		if got := tt.a.Cmp(tt.b); got != tt.want {
+			t.Errorf("%s %s Cmp %s %s = %d, want %d", tt.a.String(), tt.a.Currency, tt.b.String(), tt.b.Currency, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyBind(t *testing.T) {
+	tests := []struct {
+		raw			string
+		currency	string
+		want		int64
+		err			error
+	}{
+		{ `"10.5"`, "BRL", 1050, nil },
+		{ `10.5`, "BRL", 1050, nil },
+		{ `"10.5"`, "BHD", 10500, nil },
+		{ `"10"`, "JPY", 10, nil },
+		{ `"10.5"`, "JPY", 0, erro.ErrAmountScale },
+		{ `"1.001"`, "BRL", 0, erro.ErrAmountScale },
+	}
+	for _, tt := range tests {
+		var money Money
+		err := json.Unmarshal([]byte(tt.raw), &money)
+		if err != nil {
+			t.Fatalf("Unmarshal(%s): %v", tt.raw, err)
+		}
+		// The currency is only known when the struct is decoded
+		err = money.Bind(tt.currency)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Bind(%s, %s) err = %v, want %v", tt.raw, tt.currency, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || money.Units != tt.want || money.Currency != tt.currency {
+			t.Errorf("Bind(%s, %s) = %+v, %v, want %d", tt.raw, tt.currency, money, err, tt.want)
+		}
+	}
+
+	// Not decoded from JSON, only the currency is set
+	money := NewMoney(100, "")
+	if err := money.Bind("BRL"); err != nil || money.Units != 100 || money.Currency != "BRL" {
+		t.Errorf("Bind of a Money not decoded = %+v, %v", money, err)
+	}
+}
+
+func TestMoneyUnmarshalInvalid(t *testing.T) {
+	for _, raw := range []string{ `"abc"`, `"1,5"`, `"1e3"`, `true`, `{}` } {
+		var money Money
+		if err := json.Unmarshal([]byte(raw), &money); err == nil {
+			t.Errorf("Unmarshal(%s) err = nil", raw)
+		}
+	}
+
+	var money Money
+	if err := json.Unmarshal([]byte(`null`), &money); err != nil || money.Units != 0 {
+		t.Errorf("Unmarshal(null) = %+v, %v", money, err)
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		currency	string
+		units		int64
+		want		string
+	}{
+		{ "BRL", 1234, `"12.34"` },
+		{ "BRL", -5, `"-0.05"` },
+		{ "JPY", 1234, `"1234"` },
+		{ "BHD", 1234, `"1.234"` },
+		{ "USD", math.MaxInt64, `"92233720368547758.07"` },
+	}
+	for _, tt := range tests {
+		charge := BalanceCharge{ Currency: tt.currency, Amount: NewMoney(tt.units, tt.currency) }
+		data, err := json.Marshal(charge)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+
+		var amount struct {
+			Amount	json.RawMessage	`json:"amount"`
+		}
+		json.Unmarshal(data, &amount)
+		if string(amount.Amount) != tt.want {
+			t.Errorf("amount of %d %s = %s, want %s", tt.units, tt.currency, amount.Amount, tt.want)
+		}
+
+		var decoded BalanceCharge
+		err = json.Unmarshal(data, &decoded)
+		if err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+		if decoded.Amount.Units != tt.units || decoded.Amount.Currency != tt.currency {
+			t.Errorf("round trip of %d %s = %+v", tt.units, tt.currency, decoded.Amount)
+		}
+	}
+
+	// The scale is checked against the currency of the charge
+	var decoded BalanceCharge
+	err := json.Unmarshal([]byte(`{"currency": "JPY", "amount": "1.5"}`), &decoded)
+	if !errors.Is(err, erro.ErrAmountScale) {
+		t.Errorf("Unmarshal of 1.5 JPY err = %v, want ErrAmountScale", err)
+	}
+}
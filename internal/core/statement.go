@@ -0,0 +1,35 @@
+package core
+
+import (
+	"time"
+
+)
+
+// Statement of an account in [From, To), the closing balance is the current balance of
+// go-rest-balance minus the charges made since To
+type Statement struct {
+	AccountID		string				`json:"account_id"`
+	Currency		string				`json:"currency"`
+	From			time.Time			`json:"from"`
+	To				time.Time			`json:"to"`
+	OpeningBalance	Money				`json:"opening_balance"`
+	ClosingBalance	Money				`json:"closing_balance"`
+	Lines			[]StatementLine		`json:"lines"`
+	Totals			[]StatementTotal	`json:"totals"`
+	GeneratedAt		time.Time			`json:"generated_at"`
+}
+
+type StatementLine struct {
+	ID				int			`json:"id"`
+	Type			string		`json:"type_charge"`
+	ChargeAt		time.Time	`json:"charged_at"`
+	Amount			Money		`json:"amount"`
+	Balance			Money		`json:"balance"`
+	ReversalOf		int			`json:"reversal_of,omitempty"`
+}
+
+type StatementTotal struct {
+	Type			string		`json:"type_charge"`
+	Count			int			`json:"count"`
+	Amount			Money		`json:"amount"`
+}
@@ -0,0 +1,20 @@
+package core
+
+import (
+	"context"
+
+)
+
+type tenantIDCtx struct{}
+
+// WithTenantID keeps the tenant of the authenticated caller, the reads are filtered by it
+// and the writes are made on it
+func WithTenantID(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantIDCtx{}, tenantID)
+}
+
+// TenantID is empty when the request is not authenticated (authentication disabled)
+func TenantID(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantIDCtx{}).(string)
+	return tenantID
+}
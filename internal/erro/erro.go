@@ -6,45 +6,125 @@ import (
 
 )
 
+// Error is a domain error: a stable code for the clients, the message, the HTTP status it
+// is answered with and whether the same request may succeed later. Details carries the
+// data of one occurrence (e.g. the field errors), copies made by WithDetails keep matching
+// the original with errors.Is
+type Error struct {
+	Code		string
+	Message		string
+	Status		int
+	Retryable	bool
+	Details		interface{}
+}
+
+func New(code string, status int, message string) *Error {
+	return &Error{ Code: code, Status: status, Message: message }
+}
+
+func NewRetryable(code string, status int, message string) *Error {
+	return &Error{ Code: code, Status: status, Message: message, Retryable: true }
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+func (e *Error) WithDetails(details interface{}) *Error {
+	copy := *e
+	copy.Details = details
+	return &copy
+}
+
 var (
-	ErrPending			= errors.New("O requisição não pode ser processada nesse momento, tente depois !!!")
-	ErrHTTPForbiden		= errors.New("Sem permissão")
-	ErrNoFund			= errors.New("Saldo insuficiente para a transação")
-	ErrListNotAllowed 	= errors.New("Lista (SCAN) não permitida para o DynamoDB")
-	ErrList 			= errors.New("Erro na leitura (LIST)")
-	ErrSaveDatabase 	= errors.New("Erro no UPSERT")
-	ErrCreateSession	= errors.New("Erro na Criaçao da Sessao AWS")
-	ErrOpenDatabase 	= errors.New("Erro na abertura do DB")
-	ErrConnectionDatabase 	= errors.New("Erro na conexão com o DB")
-	ErrNotFound 		= errors.New("Item não encontrado")
-	ErrFunctionNotImpl 	= errors.New("Função não implementada")
-	ErrInsert 			= errors.New("Erro na inserção do dado")
-	ErrUpdate			= errors.New("Erro no update do dado")
-	ErrQuery 			= errors.New("Erro na query")
-	ErrDelete 			= errors.New("Erro no Delete")
-	ErrPutEvent			= errors.New("Erro na notificação PUTEVENT")
-	ErrUnmarshal 		= errors.New("Erro na conversão do JSON")
-	ErrUnauthorized 	= errors.New("Erro de autorização")
-	ErrConvertion 		= errors.New("Erro de conversão de String para Inteiro")
-	ErrMethodNotAllowed = errors.New("Metodo não permitido")
-	ErrPreparedQuery 	= errors.New("Erro na preparação da Query para o Dynamo")
-	ErrQueryEmpty	 	= errors.New("Query string não pode ser vazia")
-	ErrEventDetail	 	= errors.New("Evento não suportado")
-	ErrFile			 	= errors.New("Erro no envio do arquivo")
-	ErrFileSize		 	= errors.New("Tamanho do arquivo inválido (Muito grande)")
-	ErrStatusInternalServerError	= errors.New("Erro Interno !!!!")
-	ErrFileInvalid		= errors.New("Tipo do arquivo inválido")
-	ErrRSAInvalidKey	= errors.New("A chave não é um RSA válida")
-	ErrRSAParseKey		= errors.New("Erro na conversão da chave RSA")
-	ErrDecode			= errors.New("Erro na decodificação do Base64")
-	ErrFileToShort		= errors.New("Data muito curto")
+	ErrPending			= NewRetryable("PENDING", http.StatusServiceUnavailable, "O requisição não pode ser processada nesse momento, tente depois !!!")
+	ErrHTTPForbiden		= New("FORBIDDEN", http.StatusForbidden, "Sem permissão")
+	ErrNoFund			= New("NO_FUND", http.StatusUnprocessableEntity, "Saldo insuficiente para a transação")
+	ErrListNotAllowed 	= New("LIST_NOT_ALLOWED", http.StatusBadRequest, "Lista (SCAN) não permitida para o DynamoDB")
+	ErrList 			= New("LIST", http.StatusInternalServerError, "Erro na leitura (LIST)")
+	ErrSaveDatabase 	= New("SAVE_DATABASE", http.StatusInternalServerError, "Erro no UPSERT")
+	ErrCreateSession	= New("CREATE_SESSION", http.StatusInternalServerError, "Erro na Criaçao da Sessao AWS")
+	ErrOpenDatabase 	= New("OPEN_DATABASE", http.StatusInternalServerError, "Erro na abertura do DB")
+	ErrConnectionDatabase 	= NewRetryable("CONNECTION_DATABASE", http.StatusServiceUnavailable, "Erro na conexão com o DB")
+	ErrNotFound 		= New("NOT_FOUND", http.StatusNotFound, "Item não encontrado")
+	ErrFunctionNotImpl 	= New("NOT_IMPLEMENTED", http.StatusNotImplemented, "Função não implementada")
+	ErrInsert 			= New("INSERT", http.StatusInternalServerError, "Erro na inserção do dado")
+	ErrUpdate			= New("UPDATE", http.StatusInternalServerError, "Erro no update do dado")
+	ErrQuery 			= New("QUERY", http.StatusInternalServerError, "Erro na query")
+	ErrDelete 			= New("DELETE", http.StatusInternalServerError, "Erro no Delete")
+	ErrPutEvent			= NewRetryable("PUT_EVENT", http.StatusBadGateway, "Erro na notificação PUTEVENT")
+	ErrUnmarshal 		= New("UNMARSHAL", http.StatusBadRequest, "Erro na conversão do JSON")
+	ErrUnauthorized 	= New("UNAUTHORIZED", http.StatusUnauthorized, "Erro de autorização")
+	ErrConvertion 		= New("CONVERTION", http.StatusBadRequest, "Erro de conversão de String para Inteiro")
+	ErrMethodNotAllowed = New("METHOD_NOT_ALLOWED", http.StatusMethodNotAllowed, "Metodo não permitido")
+	ErrPreparedQuery 	= New("PREPARED_QUERY", http.StatusInternalServerError, "Erro na preparação da Query para o Dynamo")
+	ErrQueryEmpty	 	= New("QUERY_EMPTY", http.StatusBadRequest, "Query string não pode ser vazia")
+	ErrEventDetail	 	= New("EVENT_DETAIL", http.StatusBadRequest, "Evento não suportado")
+	ErrFile			 	= New("FILE", http.StatusBadRequest, "Erro no envio do arquivo")
+	ErrFileSize		 	= New("FILE_SIZE", http.StatusRequestEntityTooLarge, "Tamanho do arquivo inválido (Muito grande)")
+	ErrStatusInternalServerError	= NewRetryable("REMOTE_ERROR", http.StatusBadGateway, "Erro Interno !!!!")
+	ErrFileInvalid		= New("FILE_INVALID", http.StatusBadRequest, "Tipo do arquivo inválido")
+	ErrRSAInvalidKey	= New("RSA_INVALID_KEY", http.StatusInternalServerError, "A chave não é um RSA válida")
+	ErrRSAParseKey		= New("RSA_PARSE_KEY", http.StatusInternalServerError, "Erro na conversão da chave RSA")
+	ErrDecode			= New("DECODE", http.StatusBadRequest, "Erro na decodificação do Base64")
+	ErrFileToShort		= New("FILE_TOO_SHORT", http.StatusBadRequest, "Data muito curto")
+	ErrIdempotencyKey		= New("IDEMPOTENCY_KEY", http.StatusBadRequest, "Idempotency-Key inválida")
+	ErrIdempotencyInProgress	= NewRetryable("IDEMPOTENCY_IN_PROGRESS", http.StatusConflict, "Requisição com a mesma Idempotency-Key ainda em processamento")
+	ErrIdempotencyMismatch	= New("IDEMPOTENCY_MISMATCH", http.StatusUnprocessableEntity, "Idempotency-Key já utilizada com outro payload")
+	ErrInvalidAmount	= New("INVALID_AMOUNT", http.StatusUnprocessableEntity, "Valor monetário inválido")
+	ErrAmountScale		= New("AMOUNT_SCALE", http.StatusUnprocessableEntity, "Valor com mais casas decimais que a moeda permite")
+	ErrAmountOverflow	= New("AMOUNT_OVERFLOW", http.StatusUnprocessableEntity, "Valor monetário fora do limite")
+	ErrCurrencyMismatch	= New("CURRENCY_MISMATCH", http.StatusUnprocessableEntity, "Moeda da transação diferente da moeda do saldo")
+	ErrInvalidCurrency	= New("INVALID_CURRENCY", http.StatusUnprocessableEntity, "Moeda inválida (código ISO 4217)")
+	ErrFxRate			= New("FX_RATE", http.StatusBadGateway, "Cotação de câmbio inválida")
+	ErrFxRateNotFound	= New("FX_RATE_NOT_FOUND", http.StatusUnprocessableEntity, "Cotação de câmbio não disponível para o par de moedas")
+	ErrSagaCompensation	= New("SAGA_COMPENSATION", http.StatusInternalServerError, "Não foi possível compensar a transação, saldo alterado por outra operação")
+	ErrAlreadyReversed	= New("ALREADY_REVERSED", http.StatusConflict, "Transação já estornada")
+	ErrReversalExceeded	= New("REVERSAL_EXCEEDED", http.StatusUnprocessableEntity, "Valor do estorno maior que o valor restante da transação")
+	ErrReversalInvalid	= New("REVERSAL_INVALID", http.StatusUnprocessableEntity, "Estorno não permitido para essa transação")
+	ErrHoldNotActive	= New("HOLD_NOT_ACTIVE", http.StatusConflict, "Reserva não está ativa (capturada, liberada ou expirada)")
+	ErrHoldExpiry		= New("HOLD_EXPIRY", http.StatusUnprocessableEntity, "Validade da reserva inválida")
+	ErrHoldCaptureExceeded	= New("HOLD_CAPTURE_EXCEEDED", http.StatusUnprocessableEntity, "Valor da captura inválido ou maior que o valor reservado")
+	ErrTooManyRequests	= NewRetryable("REMOTE_TOO_MANY_REQUESTS", http.StatusBadGateway, "Limite de requisições excedido no go-rest-balance")
+	ErrUnexpectedStatus	= New("REMOTE_UNEXPECTED_STATUS", http.StatusBadGateway, "Status inesperado na resposta do go-rest-balance")
+	ErrRemoteForbidden	= New("REMOTE_FORBIDDEN", http.StatusBadGateway, "Sem permissão no go-rest-balance")
+	ErrRemoteUnavailable	= NewRetryable("REMOTE_UNAVAILABLE", http.StatusBadGateway, "Falha na comunicação com o go-rest-balance")
+	ErrBreakerOpen		= NewRetryable("DEPENDENCY_UNAVAILABLE", http.StatusServiceUnavailable, "Dependência indisponível (circuit breaker aberto), tente depois")
+	ErrBreakerAction	= New("BREAKER_ACTION", http.StatusBadRequest, "Ação inválida para o circuit breaker (open|close|reset)")
+	ErrTransaction		= New("TRANSACTION", http.StatusInternalServerError, "Transação não pertence a este repositório")
+	ErrTransactionDone	= New("TRANSACTION_DONE", http.StatusInternalServerError, "Transação já finalizada")
+	ErrInvalidCursor	= New("INVALID_CURSOR", http.StatusBadRequest, "Cursor da lista inválido")
+	ErrListFilter		= New("LIST_FILTER", http.StatusBadRequest, "Filtro da lista inválido")
+	ErrStatementPeriod	= New("STATEMENT_PERIOD", http.StatusBadRequest, "Período do extrato inválido")
+	ErrLedgerUnbalanced	= New("LEDGER_UNBALANCED", http.StatusInternalServerError, "Lançamentos contábeis da transação não balanceados (débitos diferentes dos créditos)")
+	ErrSchemaVersion	= New("SCHEMA_VERSION", http.StatusInternalServerError, "Versão do schema do banco anterior à esperada, execute migrate up")
+	ErrValidation		= New("VALIDATION", http.StatusUnprocessableEntity, "Dados da requisição inválidos")
+	ErrInternal			= New("INTERNAL", http.StatusInternalServerError, "Erro interno, tente novamente mais tarde")
+	ErrConfig			= New("CONFIG", http.StatusInternalServerError, "Configuração inválida")
 )
 
-func HandlerHttpError(w http.ResponseWriter, err error) { 
-	switch err {
-		case ErrUnauthorized:
-			w.WriteHeader(http.StatusUnauthorized)	
-		default:
-			w.WriteHeader(http.StatusInternalServerError)
+// detailer is implemented by the errors that carry the details of a domain error
+// (e.g. validation.Errors and its field errors)
+type detailer interface {
+	Details() interface{}
+}
+
+// FromError maps any error to its domain error, the first *Error of the chain. The errors
+// that are not domain errors (driver, network...) are ErrInternal
+func FromError(err error) *Error {
+	var domain *Error
+	if !errors.As(err, &domain) {
+		return ErrInternal
+	}
+
+	var err_details detailer
+	if domain.Details == nil && errors.As(err, &err_details) {
+		return domain.WithDetails(err_details.Details())
 	}
+	return domain
 }
\ No newline at end of file
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/go-rest-balance-charges/internal/auth"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+// MiddleWareAuth validates the bearer token and its scope, the tenant of the token is kept in
+// the context (core.TenantID). Without verifier (auth.disabled) every request goes through but
+// the admin ones, which are never open
+func (h *HttpWorkerAdapter) MiddleWareAuth(scope string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodOptions {
+				next.ServeHTTP(rw, req)
+				return
+			}
+			if h.verifier == nil {
+				if scope == auth.ScopeAdmin {
+					writeError(rw, req, fmt.Errorf("%w: authentication disabled", erro.ErrHTTPForbiden))
+					return
+				}
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			token, ok := bearerToken(req)
+			if !ok {
+				rw.Header().Set("WWW-Authenticate", `Bearer`)
+				writeError(rw, req, erro.ErrUnauthorized)
+				return
+			}
+			claims, err := h.verifier.Verify(req.Context(), token)
+			if err != nil {
+				childLogger.Debug().Err(err).Msg("Invalid token")
+				rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeError(rw, req, erro.ErrUnauthorized)
+				return
+			}
+			if claims.TenantID == "" {
+				writeError(rw, req, fmt.Errorf("%w: token without tenant", erro.ErrHTTPForbiden))
+				return
+			}
+			if !claims.HasScope(scope) {
+				rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+				writeError(rw, req, fmt.Errorf("%w: scope %s required", erro.ErrHTTPForbiden, scope))
+				return
+			}
+
+			ctx := auth.WithClaims(req.Context(), claims)
+			ctx = core.WithTenantID(ctx, claims.TenantID)
+			next.ServeHTTP(rw, req.WithContext(ctx))
+		})
+	}
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(authorization[7:])
+	return token, token != ""
+}
+
+// forceTenant makes the write on the tenant of the caller, a payload of another tenant is
+// forbidden
+func forceTenant(req *http.Request, tenantID *string) error {
+	tenant := core.TenantID(req.Context())
+	if tenant == "" {
+		return nil
+	}
+	if *tenantID != "" && *tenantID != tenant {
+		return fmt.Errorf("%w: tenant_id %s", erro.ErrHTTPForbiden, *tenantID)
+	}
+	*tenantID = tenant
+	return nil
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/auth"
+	"github.com/go-rest-balance-charges/internal/core"
+
+)
+
+// newTestVerifier verifies the RS256 tokens of the returned key, read from a JWKS file
+func newTestVerifier(t *testing.T) (*auth.Verifier, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	content, _ := json.Marshal(map[string]interface{}{ "keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "rsa-1",
+		"n": base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+	}}})
+	filePath := filepath.Join(t.TempDir(), "jwks.json")
+	err = os.WriteFile(filePath, content, 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	verifier, err := auth.NewVerifier(auth.Config{ JwksFile: filePath, Audience: "go-rest-balance-charges" })
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	return verifier, key
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{ "alg": "RS256", "kid": "rsa-1" })
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestMiddleWareAuth(t *testing.T) {
+	verifier, key := newTestVerifier(t)
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name			string
+		authorization	string
+		scope			string
+		want			int
+		wantTenant		string
+	}{
+		{ "read scope", "Bearer " + signToken(t, key, map[string]interface{}{ "aud": "go-rest-balance-charges", "exp": exp, "tenant_id": "TENANT-001", "scope": "charges:read" }), auth.ScopeRead, http.StatusOK, "TENANT-001" },
+		{ "scp list", "bearer " + signToken(t, key, map[string]interface{}{ "aud": "go-rest-balance-charges", "exp": exp, "tenant_id": "TENANT-002", "scp": []string{ "charges:write" } }), auth.ScopeWrite, http.StatusOK, "TENANT-002" },
+		{ "scope missing", "Bearer " + signToken(t, key, map[string]interface{}{ "aud": "go-rest-balance-charges", "exp": exp, "tenant_id": "TENANT-001", "scope": "charges:read" }), auth.ScopeWrite, http.StatusForbidden, "" },
+		{ "admin scope missing", "Bearer " + signToken(t, key, map[string]interface{}{ "aud": "go-rest-balance-charges", "exp": exp, "tenant_id": "TENANT-001", "scope": "charges:read charges:write" }), auth.ScopeAdmin, http.StatusForbidden, "" },
+		{ "tenant missing", "Bearer " + signToken(t, key, map[string]interface{}{ "aud": "go-rest-balance-charges", "exp": exp, "scope": "charges:read" }), auth.ScopeRead, http.StatusForbidden, "" },
+		{ "wrong audience", "Bearer " + signToken(t, key, map[string]interface{}{ "aud": "other", "exp": exp, "tenant_id": "TENANT-001", "scope": "charges:read" }), auth.ScopeRead, http.StatusUnauthorized, "" },
+		{ "expired", "Bearer " + signToken(t, key, map[string]interface{}{ "aud": "go-rest-balance-charges", "exp": time.Now().Add(-time.Hour).Unix(), "tenant_id": "TENANT-001", "scope": "charges:read" }), auth.ScopeRead, http.StatusUnauthorized, "" },
+		{ "not bearer", "Basic dXNlcjpwYXNz", auth.ScopeRead, http.StatusUnauthorized, "" },
+		{ "without token", "", auth.ScopeRead, http.StatusUnauthorized, "" },
+	}
+	for _, tt := range tests {
+		h := NewHttpWorkerAdapter(nil, verifier, nil)
+		tenant := ""
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			tenant = core.TenantID(req.Context())
+			if claims := auth.FromContext(req.Context()); claims == nil || claims.TenantID != tenant {
+				t.Errorf("%s: claims = %+v, want the tenant %s", tt.name, claims, tenant)
+			}
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/list", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rw := httptest.NewRecorder()
+		h.MiddleWareAuth(tt.scope)(next).ServeHTTP(rw, req)
+
+		if rw.Code != tt.want || tenant != tt.wantTenant {
+			t.Errorf("%s: status = %d, tenant = %q, want %d and %q", tt.name, rw.Code, tenant, tt.want, tt.wantTenant)
+		}
+		if tt.want == http.StatusUnauthorized && rw.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: WWW-Authenticate missing", tt.name)
+		}
+	}
+}
+
+func TestMiddleWareAuthDisabled(t *testing.T) {
+	h := NewHttpWorkerAdapter(nil, nil, nil)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	for _, tt := range []struct {
+		scope	string
+		want	int
+	}{
+		{ auth.ScopeRead, http.StatusOK },
+		{ auth.ScopeWrite, http.StatusOK },
+		{ auth.ScopeAdmin, http.StatusForbidden },
+	} {
+		rw := httptest.NewRecorder()
+		h.MiddleWareAuth(tt.scope)(next).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/list", nil))
+		if rw.Code != tt.want {
+			t.Errorf("scope %s without verifier: status = %d, want %d", tt.scope, rw.Code, tt.want)
+		}
+	}
+}
@@ -1,15 +1,22 @@
 package handler
 
 import (
+	"fmt"
+	"io"
 	"strconv"
+	"strings"
+	"time"
 	"net/http"
 	"encoding/json"
 	"github.com/rs/zerolog/log"
 	"github.com/gorilla/mux"
 
 	"github.com/go-rest-balance-charges/internal/service"
+	"github.com/go-rest-balance-charges/internal/auth"
 	"github.com/go-rest-balance-charges/internal/core"
 	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/validation"
+	"github.com/go-rest-balance-charges/internal/health"
 	
 )
 
@@ -17,12 +24,17 @@ var childLogger = log.With().Str("handler", "handler").Logger()
 
 type HttpWorkerAdapter struct {
 	workerService 	*service.WorkerService
+	verifier		*auth.Verifier
+	readiness		*health.Checker
 }
 
-func NewHttpWorkerAdapter(workerService *service.WorkerService) *HttpWorkerAdapter {
+// NewHttpWorkerAdapter, verifier is nil when the authentication is disabled
+func NewHttpWorkerAdapter(workerService *service.WorkerService, verifier *auth.Verifier, readiness *health.Checker) *HttpWorkerAdapter {
 	childLogger.Debug().Msg("NewHttpWorkerAdapter")
 	return &HttpWorkerAdapter{
 		workerService: workerService,
+		verifier: verifier,
+		readiness: readiness,
 	}
 }
 
@@ -41,7 +53,19 @@ func MiddleWareHandlerHeader(next http.Handler) http.Handler {
 
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers","Content-Type,access-control-allow-origin, access-control-allow-headers")
+		w.Header().Set("Access-Control-Allow-Headers","Content-Type,access-control-allow-origin, access-control-allow-headers, Idempotency-Key, X-Correlation-ID, Authorization")
+
+		// The id of the request, the one sent by the client when valid, is answered and
+		// forwarded to go-rest-balance. The middleware runs on the router and the subrouters,
+		// the id is set once
+		if core.CorrelationID(r.Context()) == "" {
+			correlation_id := r.Header.Get(core.CorrelationHeader)
+			if !validCorrelationID(correlation_id) {
+				correlation_id = core.NewCorrelationID()
+			}
+			w.Header().Set(core.CorrelationHeader, correlation_id)
+			r = r.WithContext(core.WithCorrelationID(r.Context(), correlation_id))
+		}
 	
 		//log.Println(r.Header.Get("Host"))
 		//log.Println(r.Header.Get("User-Agent"))
@@ -53,6 +77,19 @@ func MiddleWareHandlerHeader(next http.Handler) http.Handler {
 	})
 }
 
+// validCorrelationID accepts up to 128 letters, digits, '-', '_' and '.'
+func validCorrelationID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
 func (h *HttpWorkerAdapter) Health(rw http.ResponseWriter, req *http.Request) {
 	childLogger.Debug().Msg("Health")
 
@@ -61,6 +98,7 @@ func (h *HttpWorkerAdapter) Health(rw http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// Live is the check of the process only, a dependency down must not restart the pod
 func (h *HttpWorkerAdapter) Live(rw http.ResponseWriter, req *http.Request) {
 	childLogger.Debug().Msg("Live")
 
@@ -69,6 +107,21 @@ func (h *HttpWorkerAdapter) Live(rw http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// Ready answers the status of each dependency, 503 when a critical one is down so the
+// pod stops receiving traffic
+func (h *HttpWorkerAdapter) Ready(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("Ready")
+
+	report := h.readiness.Check()
+
+	rw.Header().Set("Cache-Control", "no-store")
+	if !report.Ready() {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(rw).Encode(report)
+	return
+}
+
 func (h *HttpWorkerAdapter) Header(rw http.ResponseWriter, req *http.Request) {
 	log.Printf("/header")
 	
@@ -79,54 +132,46 @@ func (h *HttpWorkerAdapter) Header(rw http.ResponseWriter, req *http.Request) {
 func (h *HttpWorkerAdapter) Add(rw http.ResponseWriter, req *http.Request) {
 	childLogger.Debug().Msg("Add")
 
-	balanceCharge := core.BalanceCharge{}
-	err := json.NewDecoder(req.Body).Decode(&balanceCharge)
-    if err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(rw).Encode(erro.ErrUnmarshal.Error())
-        return
-    }
+	balanceCharge, err := validation.DecodeCharge(req.Body, validation.OperationAdd, core.TenantID(req.Context()))
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+	err = forceTenant(req, &balanceCharge.TenantID)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
 	
 	res, err := h.workerService.AddCtx(req.Context(), balanceCharge)
 	if err != nil {
-		switch err {
-		default:
-			rw.WriteHeader(500)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		}
+		writeError(rw, req, err)
+		return
 	}
 
 	json.NewEncoder(rw).Encode(res)
 	return
 }
 
+
 func (h *HttpWorkerAdapter) Get(rw http.ResponseWriter, req *http.Request) {
 	childLogger.Debug().Msg("Get")
 
 	vars := mux.Vars(req)
 	varID, err := strconv.Atoi(vars["id"])
 	if err != nil{
-		rw.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(rw).Encode(erro.ErrConvertion.Error())
+		writeError(rw, req, erro.ErrConvertion)
 		return
 	}
 
 	balanceCharge := core.BalanceCharge{}
 	balanceCharge.ID = varID
+	balanceCharge.TenantID = core.TenantID(req.Context())
 	
 	res, err := h.workerService.Get(req.Context(), balanceCharge)
 	if err != nil {
-		switch err {
-		case erro.ErrNotFound:
-			rw.WriteHeader(404)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		default:
-			rw.WriteHeader(500)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		}
+		writeError(rw, req, err)
+		return
 	}
 
 	json.NewEncoder(rw).Encode(res)
@@ -139,53 +184,121 @@ func (h *HttpWorkerAdapter) List(rw http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	varID := (vars["id"])
 
-	balanceCharge := core.BalanceCharge{}
-	balanceCharge.AccountID = varID
+	filter, err := chargeFilter(req)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+	filter.TenantID = core.TenantID(req.Context())
 	
-	res, err := h.workerService.List(req.Context(), balanceCharge)
+	res, err := h.workerService.List(req.Context(), varID, filter)
 	if err != nil {
-		switch err {
-		default:
-			rw.WriteHeader(500)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		}
+		writeError(rw, req, err)
+		return
 	}
 
 	json.NewEncoder(rw).Encode(res)
 	return
 }
 
+// chargeFilter reads the query of the listing: limit, cursor, sort, type_charge, currency,
+// from/to (RFC3339 or 2006-01-02) and min_amount/max_amount
+func chargeFilter(req *http.Request) (core.ChargeFilter, error) {
+	query := req.URL.Query()
+
+	filter := core.ChargeFilter{	Type:		query.Get("type_charge"),
+									Currency:	strings.ToUpper(query.Get("currency")),
+									Sort:		core.SortChargedAtDesc,
+									Limit:		core.DefaultListLimit,
+								}
+
+	if query.Get("sort") != "" {
+		filter.Sort = query.Get("sort")
+		if !core.ValidSort(filter.Sort) {
+			return filter, erro.ErrListFilter
+		}
+	}
+	if query.Get("limit") != "" {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > core.MaxListLimit {
+			return filter, erro.ErrListFilter
+		}
+		filter.Limit = limit
+	}
+	if query.Get("cursor") != "" {
+		cursor, err := core.DecodeChargeCursor(query.Get("cursor"), filter.Sort)
+		if err != nil {
+			return filter, err
+		}
+		filter.After = cursor
+	}
+
+	var err error
+	filter.From, err = queryTime(query.Get("from"))
+	if err != nil {
+		return filter, err
+	}
+	filter.To, err = queryTime(query.Get("to"))
+	if err != nil {
+		return filter, err
+	}
+	filter.MinAmount, err = queryMoney(query.Get("min_amount"), filter.Currency)
+	if err != nil {
+		return filter, err
+	}
+	filter.MaxAmount, err = queryMoney(query.Get("max_amount"), filter.Currency)
+	if err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+func queryTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		date, err = time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, erro.ErrListFilter
+		}
+	}
+	return &date, nil
+}
+
+// queryMoney parses an amount bound, without currency filter it is a plain decimal
+// (compared by value with the charges of any currency)
+func queryMoney(value string, currency string) (*core.Money, error) {
+	if value == "" {
+		return nil, nil
+	}
+	money, err := core.ParseMoney(value, currency)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", erro.ErrListFilter, err.Error())
+	}
+	return &money, nil
+}
+
 func (h *HttpWorkerAdapter) GetCb(rw http.ResponseWriter, req *http.Request) {
 	childLogger.Debug().Msg("GetCb")
 
 	vars := mux.Vars(req)
 	varID, err := strconv.Atoi(vars["id"])
 	if err != nil{
-		rw.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(rw).Encode(erro.ErrConvertion.Error())
+		writeError(rw, req, erro.ErrConvertion)
 		return
 	}
 
 	balanceCharge := core.BalanceCharge{}
 	balanceCharge.ID = varID
+	balanceCharge.TenantID = core.TenantID(req.Context())
 	
 	res, err := h.workerService.GetCb(req.Context(), balanceCharge)
 	if err != nil {
-		switch err {
-		case erro.ErrPending:
-			rw.WriteHeader(200)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		case erro.ErrNotFound:
-			rw.WriteHeader(404)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		default:
-			rw.WriteHeader(500)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		}
+		writeError(rw, req, err)
+		return
 	}
 
 	json.NewEncoder(rw).Encode(res)
@@ -195,22 +308,21 @@ func (h *HttpWorkerAdapter) GetCb(rw http.ResponseWriter, req *http.Request) {
 func (h *HttpWorkerAdapter) WithdrawCbCtx(rw http.ResponseWriter, req *http.Request) {
 	childLogger.Debug().Msg("WithdrawCbCtx")
 
-	balanceCharge := core.BalanceCharge{}
-	err := json.NewDecoder(req.Body).Decode(&balanceCharge)
-    if err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(rw).Encode(erro.ErrUnmarshal.Error())
-        return
-    }
+	balanceCharge, err := validation.DecodeCharge(req.Body, validation.OperationWithdraw, core.TenantID(req.Context()))
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+	err = forceTenant(req, &balanceCharge.TenantID)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
 	
 	res, err := h.workerService.WithdrawCbCtx(req.Context(), balanceCharge)
 	if err != nil {
-		switch err {
-		default:
-			rw.WriteHeader(500)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		}
+		writeError(rw, req, err)
+		return
 	}
 
 	json.NewEncoder(rw).Encode(res)
@@ -223,27 +335,46 @@ func (h *HttpWorkerAdapter) GetCache(rw http.ResponseWriter, req *http.Request)
 	vars := mux.Vars(req)
 	balanceCharge := core.BalanceCharge{}
 	balanceCharge.AccountID = vars["id"]
+	balanceCharge.TenantID = core.TenantID(req.Context())
 	
 	res, err := h.workerService.GetCache(req.Context(), balanceCharge)
 	if err != nil {
-		switch err {
-		case erro.ErrPending:
-			rw.WriteHeader(200)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		case erro.ErrNotFound:
-			rw.WriteHeader(404)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		default:
-			rw.WriteHeader(500)
-			json.NewEncoder(rw).Encode(err.Error())
-			return
-		}
+		writeError(rw, req, err)
+		return
 	}
 
 	json.NewEncoder(rw).Encode(res)
 	return
+}
+
+func (h *HttpWorkerAdapter) Reverse(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("Reverse")
+
+	vars := mux.Vars(req)
+	varID, err := strconv.Atoi(vars["id"])
+	if err != nil{
+		writeError(rw, req, erro.ErrConvertion)
+		return
+	}
+
+	// The body is optional, without amount the whole remaining value is reversed
+	chargeReversal := core.ChargeReversal{}
+	err = json.NewDecoder(req.Body).Decode(&chargeReversal)
+	if err != nil && err != io.EOF {
+		writeError(rw, req, erro.ErrUnmarshal)
+		return
+	}
+
+	balanceCharge := core.BalanceCharge{}
+	balanceCharge.ID = varID
+	balanceCharge.TenantID = core.TenantID(req.Context())
+
+	res, err := h.workerService.Reverse(req.Context(), balanceCharge, chargeReversal)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
 	json.NewEncoder(rw).Encode(res)
 	return
 }
\ No newline at end of file
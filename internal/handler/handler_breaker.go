@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+	"encoding/json"
+	"github.com/gorilla/mux"
+
+
+)
+
+func (h *HttpWorkerAdapter) ListBreakers(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("ListBreakers")
+
+	res := h.workerService.ListBreakers(req.Context())
+
+	json.NewEncoder(rw).Encode(res)
+	return
+}
+
+func (h *HttpWorkerAdapter) ForceBreaker(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("ForceBreaker")
+
+	vars := mux.Vars(req)
+	res, err := h.workerService.ForceBreaker(req.Context(), vars["name"], vars["action"])
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	json.NewEncoder(rw).Encode(res)
+	return
+}
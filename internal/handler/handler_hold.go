@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"io"
+	"strconv"
+	"net/http"
+	"encoding/json"
+	"github.com/gorilla/mux"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+func (h *HttpWorkerAdapter) CreateHold(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("CreateHold")
+
+	balanceHold := core.BalanceHold{}
+	err := json.NewDecoder(req.Body).Decode(&balanceHold)
+	if err != nil {
+		writeError(rw, req, erro.ErrUnmarshal)
+		return
+	}
+	err = forceTenant(req, &balanceHold.TenantID)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	res, err := h.workerService.CreateHold(req.Context(), balanceHold)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(res)
+	return
+}
+
+func (h *HttpWorkerAdapter) GetHold(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("GetHold")
+
+	balanceHold, err := holdFromPath(req)
+	if err != nil {
+		writeError(rw, req, erro.ErrConvertion)
+		return
+	}
+
+	res, err := h.workerService.GetHold(req.Context(), balanceHold)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	json.NewEncoder(rw).Encode(res)
+	return
+}
+
+func (h *HttpWorkerAdapter) CaptureHold(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("CaptureHold")
+
+	balanceHold, err := holdFromPath(req)
+	if err != nil {
+		writeError(rw, req, erro.ErrConvertion)
+		return
+	}
+
+	// The body is optional, without amount the whole hold is captured
+	holdCapture := core.HoldCapture{}
+	err = json.NewDecoder(req.Body).Decode(&holdCapture)
+	if err != nil && err != io.EOF {
+		writeError(rw, req, erro.ErrUnmarshal)
+		return
+	}
+
+	res, err := h.workerService.CaptureHold(req.Context(), balanceHold, holdCapture)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	json.NewEncoder(rw).Encode(res)
+	return
+}
+
+func (h *HttpWorkerAdapter) ReleaseHold(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("ReleaseHold")
+
+	balanceHold, err := holdFromPath(req)
+	if err != nil {
+		writeError(rw, req, erro.ErrConvertion)
+		return
+	}
+
+	res, err := h.workerService.ReleaseHold(req.Context(), balanceHold)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	json.NewEncoder(rw).Encode(res)
+	return
+}
+
+func (h *HttpWorkerAdapter) Available(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("Available")
+
+	vars := mux.Vars(req)
+	balanceCharge := core.BalanceCharge{}
+	balanceCharge.AccountID = vars["id"]
+	balanceCharge.TenantID = core.TenantID(req.Context())
+
+	res, err := h.workerService.Available(req.Context(), balanceCharge)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	json.NewEncoder(rw).Encode(res)
+	return
+}
+
+func holdFromPath(req *http.Request) (core.BalanceHold, error) {
+	vars := mux.Vars(req)
+	varID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return core.BalanceHold{}, err
+	}
+	// The hold of another tenant is not found
+	return core.BalanceHold{ ID: varID, TenantID: core.TenantID(req.Context()) }, nil
+}
+
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"strings"
+	"net/http"
+	"encoding/json"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+// TrialBalance sums the ledger postings per account, ?currency=BRL filters one currency (default all)
+func (h *HttpWorkerAdapter) TrialBalance(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("TrialBalance")
+
+	currency := strings.ToUpper(req.URL.Query().Get("currency"))
+	if currency != "" && len(currency) != 3 {
+		writeError(rw, req, erro.ErrListFilter)
+		return
+	}
+
+	res, err := h.workerService.TrialBalance(req.Context(), currency)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	json.NewEncoder(rw).Encode(res)
+	return
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+	"encoding/json"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/service"
+
+)
+
+// ListReconciliations lists the discrepancies, ?status=OPEN|CORRECTED|RESOLVED (default all)
+func (h *HttpWorkerAdapter) ListReconciliations(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("ListReconciliations")
+
+	status := req.URL.Query().Get("status")
+	switch status {
+	case "", service.ReconciliationOpen, service.ReconciliationCorrected, service.ReconciliationResolved:
+	default:
+		writeError(rw, req, erro.ErrListFilter)
+		return
+	}
+
+	res, err := h.workerService.ListReconciliations(req.Context(), status)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	json.NewEncoder(rw).Encode(res)
+	return
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"net/http"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/gorilla/mux"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+const statementDefaultPeriod = 30 * 24 * time.Hour
+
+// Statement answers in JSON (default), CSV (Accept: text/csv) or a printable text (Accept: text/plain)
+func (h *HttpWorkerAdapter) Statement(rw http.ResponseWriter, req *http.Request) {
+	childLogger.Debug().Msg("Statement")
+
+	vars := mux.Vars(req)
+	query := req.URL.Query()
+
+	to, err := queryTime(query.Get("to"))
+	if err != nil {
+		writeError(rw, req, erro.ErrStatementPeriod)
+		return
+	}
+	if to == nil {
+		now := time.Now()
+		to = &now
+	}
+	from, err := queryTime(query.Get("from"))
+	if err != nil {
+		writeError(rw, req, erro.ErrStatementPeriod)
+		return
+	}
+	if from == nil {
+		start := to.Add(-statementDefaultPeriod)
+		from = &start
+	}
+
+	res, err := h.workerService.Statement(req.Context(), vars["id"], *from, *to)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	accept := req.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "text/csv"):
+		rw.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"statement-%s.csv\"", res.AccountID))
+		err = writeStatementCSV(rw, res)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("error write CSV")
+		}
+	case strings.Contains(accept, "text/plain"):
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeStatementText(rw, res)
+	default:
+		json.NewEncoder(rw).Encode(res)
+	}
+	return
+}
+
+// writeStatementCSV writes one row per charge between the opening and closing rows,
+// so the running balance column reads top to bottom
+func writeStatementCSV(w io.Writer, statement *core.Statement) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "charged_at", "type_charge", "amount", "balance", "reversal_of"})
+	writer.Write([]string{"", statement.From.Format(time.RFC3339), "OPENING_BALANCE", "", statement.OpeningBalance.String(), ""})
+	for _, line := range statement.Lines {
+		reversal_of := ""
+		if line.ReversalOf != 0 {
+			reversal_of = strconv.Itoa(line.ReversalOf)
+		}
+		writer.Write([]string{	strconv.Itoa(line.ID),
+								line.ChargeAt.Format(time.RFC3339),
+								line.Type,
+								line.Amount.String(),
+								line.Balance.String(),
+								reversal_of,
+							})
+	}
+	writer.Write([]string{"", statement.To.Format(time.RFC3339), "CLOSING_BALANCE", "", statement.ClosingBalance.String(), ""})
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeStatementText(w io.Writer, statement *core.Statement) {
+	const rule = "--------------------------------------------------------------------------------"
+
+	fmt.Fprintf(w, "ACCOUNT STATEMENT %s (%s)\n", statement.AccountID, statement.Currency)
+	fmt.Fprintf(w, "Period: %s to %s\n", statement.From.Format("2006-01-02 15:04:05"), statement.To.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "Generated at: %s\n", statement.GeneratedAt.Format(time.RFC3339))
+	fmt.Fprintln(w, rule)
+	fmt.Fprintf(w, "%-20s %-10s %-16s %15s %15s\n", "DATE", "ID", "TYPE", "AMOUNT", "BALANCE")
+	fmt.Fprintln(w, rule)
+	fmt.Fprintf(w, "%-20s %-10s %-16s %15s %15s\n", statement.From.Format("2006-01-02 15:04:05"), "", "OPENING BALANCE", "", statement.OpeningBalance.String())
+	for _, line := range statement.Lines {
+		fmt.Fprintf(w, "%-20s %-10d %-16.16s %15s %15s\n",
+					line.ChargeAt.Format("2006-01-02 15:04:05"),
+					line.ID,
+					line.Type,
+					line.Amount.String(),
+					line.Balance.String())
+	}
+	fmt.Fprintf(w, "%-20s %-10s %-16s %15s %15s\n", statement.To.Format("2006-01-02 15:04:05"), "", "CLOSING BALANCE", "", statement.ClosingBalance.String())
+	fmt.Fprintln(w, rule)
+	fmt.Fprintln(w, "TOTALS")
+	for _, total := range statement.Totals {
+		fmt.Fprintf(w, "%-20s %-10d %-16s %15s\n", total.Type, total.Count, "", total.Amount.String())
+	}
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+const (
+	idempotencyHeader 			= "Idempotency-Key"
+	idempotencyReplayedHeader	= "Idempotent-Replayed"
+	idempotencyKeyMaxLength		= 255
+)
+
+// responseRecorder keeps a copy of the status and body written by the wrapped handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status	int
+	body	bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// detachedContext keeps the values (tracing segment) of the request context but not its
+// cancellation, so the outcome is stored even if the client has already gone away
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{} { return nil }
+func (detachedContext) Err() error { return nil }
+
+func (h *HttpWorkerAdapter) Idempotent(operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(rw, req)
+			return
+		}
+		childLogger.Debug().Str("operation", operation).Str("idempotency_key", key).Msg("Idempotent")
+
+		if len(key) > idempotencyKeyMaxLength {
+			writeError(rw, req, erro.ErrIdempotencyKey)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(rw, req, erro.ErrUnmarshal)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The tenant is part of the hash, a key reused by another tenant is a mismatch and
+		// never replays the response of the first one
+		idempotencyKey := core.IdempotencyKey{	Key: key,
+												Operation: operation,
+												RequestHash: requestHash(core.TenantID(req.Context()) + req.URL.Path, body),
+											}
+
+		res, err := h.workerService.ClaimIdempotencyKey(req.Context(), idempotencyKey)
+		if err != nil {
+			writeError(rw, req, err)
+			return
+		}
+		if res != nil {
+			rw.Header().Set(idempotencyReplayedHeader, "true")
+			rw.WriteHeader(res.ResponseStatus)
+			rw.Write(res.ResponseBody)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: rw, status: http.StatusOK}
+		ctx_tracked, committed := core.WithCommitTracking(req.Context())
+		next(recorder, req.WithContext(ctx_tracked))
+
+		// Server errors are not stored so the client can retry them, unless the request
+		// left changes a retry would apply again (a saga not compensated)
+		ctx := detachedContext{req.Context()}
+		if recorder.status >= http.StatusInternalServerError && !committed() {
+			err = h.workerService.ReleaseIdempotencyKey(ctx, idempotencyKey)
+		} else {
+			idempotencyKey.ResponseStatus = recorder.status
+			idempotencyKey.ResponseBody = recorder.body.Bytes()
+			err = h.workerService.CompleteIdempotencyKey(ctx, idempotencyKey)
+		}
+		if err != nil {
+			childLogger.Error().Err(err).Str("idempotency_key", key).Msg("Error storing the idempotency key outcome")
+		}
+	}
+}
+
+// requestHash hashes the path and the canonical form of a JSON body, so formatting
+// differences are not seen as a different payload
+func requestHash(path string, body []byte) string {
+	var payload interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err == nil {
+		if canonical, err := json.Marshal(payload); err == nil {
+			body = canonical
+		}
+	}
+	sum := sha256.Sum256(append([]byte(path + "\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
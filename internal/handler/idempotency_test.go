@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository/memory"
+	"github.com/go-rest-balance-charges/internal/service"
+
+)
+
+// serve sends the same request with the Idempotency-Key through Idempotent
+func serve(t *testing.T, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	ctx, segment := xray.BeginSegment(core.WithTenantID(context.Background(), "TENANT-001"), t.Name())
+	defer segment.Close(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/add", strings.NewReader(`{"account_id": "ACC-001", "amount": "1.00"}`)).WithContext(ctx)
+	req.Header.Set(idempotencyHeader, "KEY-001")
+	rw := httptest.NewRecorder()
+	handler(rw, req)
+	return rw
+}
+
+func TestIdempotentServerErrors(t *testing.T) {
+	tests := []struct {
+		name		string
+		committed	bool
+		wantCalls	int
+	}{
+		{ "nothing committed, released and retried", false, 2 },
+		{ "committed, the failure is replayed", true, 1 },
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workerService := service.NewWorkerService(db_memory.NewWorkerRepository(), nil, circuitbreaker.NewRegistry(), nil, nil, false)
+			h := NewHttpWorkerAdapter(workerService, nil, nil)
+
+			calls := 0
+			handler := h.Idempotent("add", func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if tt.committed {
+					core.MarkCommitted(req.Context())
+				}
+				rw.WriteHeader(http.StatusInternalServerError)
+			})
+
+			serve(t, handler)
+			rw := serve(t, handler)
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if rw.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want 500", rw.Code)
+			}
+			if replayed := rw.Header().Get(idempotencyReplayedHeader) == "true"; replayed != tt.committed {
+				t.Errorf("%s = %v, want %v", idempotencyReplayedHeader, replayed, tt.committed)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/go-rest-balance-charges/internal/metrics"
+
+)
+
+// statusRecorder keeps the status answered by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status		int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// MiddleWareMetrics measures the requests by route template (not the path, /get/{id} is one
+// series), it only runs for the requests matched by the router
+func MiddleWareMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(req); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ ResponseWriter: rw, status: http.StatusOK }
+		next.ServeHTTP(recorder, req)
+
+		metrics.HttpRequestDuration.WithLabelValues(route, req.Method, strconv.Itoa(recorder.status)).Observe(time.Since(start).Seconds())
+	})
+}
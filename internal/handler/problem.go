@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/http"
+	"encoding/json"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+// Seconds sent in Retry-After when a dependency is unavailable (503)
+const retryAfterSeconds = "5"
+
+// Problem is the RFC 7807 body of every error response (application/problem+json)
+type Problem struct {
+	Type			string		`json:"type"`
+	Title			string		`json:"title"`
+	Status			int			`json:"status"`
+	Detail			string		`json:"detail,omitempty"`
+	Instance		string		`json:"instance,omitempty"`
+	Code			string		`json:"code"`
+	Retryable		bool		`json:"retryable"`
+	CorrelationID	string		`json:"correlation_id,omitempty"`
+	Errors			interface{}	`json:"errors,omitempty"`
+}
+
+// writeError answers err as a problem, the status comes from its domain error (erro.FromError),
+// the errors that are not domain errors are a 500 without their internal message
+func writeError(rw http.ResponseWriter, req *http.Request, err error) {
+	domain := erro.FromError(err)
+	correlation_id := core.CorrelationID(req.Context())
+
+	problem := Problem{	Type:			"urn:balance-charges:" + domain.Code,
+						Title:			domain.Message,
+						Status:			domain.Status,
+						Detail:			err.Error(),
+						Instance:		req.URL.Path,
+						Code:			domain.Code,
+						Retryable:		domain.Retryable,
+						CorrelationID:	correlation_id,
+						Errors:			domain.Details,
+					}
+	if domain == erro.ErrInternal {
+		problem.Detail = ""
+	}
+
+	if domain.Status >= http.StatusInternalServerError {
+		childLogger.Error().Err(err).Str("code", domain.Code).Str("correlation_id", correlation_id).Msg("error response")
+	}
+
+	rw.Header().Set("Content-Type", "application/problem+json")
+	if domain.Status == http.StatusServiceUnavailable {
+		rw.Header().Set("Retry-After", retryAfterSeconds)
+	}
+	rw.WriteHeader(domain.Status)
+	json.NewEncoder(rw).Encode(problem)
+}
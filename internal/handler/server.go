@@ -14,7 +14,9 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/go-rest-balance-charges/internal/core"
-	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-rest-balance-charges/internal/auth"
+	"github.com/go-rest-balance-charges/internal/metrics"
+	"github.com/go-rest-balance-charges/internal/tracing"
 
 )
 
@@ -43,6 +45,7 @@ func (h HttpServer) StartHttpAppServer(ctx context.Context, httpWorkerAdapter *H
 	myRouter.HandleFunc("/info", func(rw http.ResponseWriter, req *http.Request) {
 		json.NewEncoder(rw).Encode(h.httpAppServer)
 	})
+	myRouter.Use(MiddleWareMetrics)
 	myRouter.Use(MiddleWareHandlerHeader)
 
 	health := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
@@ -51,6 +54,12 @@ func (h HttpServer) StartHttpAppServer(ctx context.Context, httpWorkerAdapter *H
 	live := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
     live.HandleFunc("/live", httpWorkerAdapter.Live)
 
+	ready := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
+	ready.HandleFunc("/ready", httpWorkerAdapter.Ready)
+
+	metricsRoute := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
+	metricsRoute.Handle("/metrics", metrics.Handler())
+
 	header := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
     header.HandleFunc("/header", httpWorkerAdapter.Header)
 	header.Use(MiddleWareHandlerHeader)
@@ -58,58 +67,163 @@ func (h HttpServer) StartHttpAppServer(ctx context.Context, httpWorkerAdapter *H
 	addBalance := myRouter.Methods(http.MethodPost, http.MethodOptions).Subrouter()
     //addBalance.HandleFunc("/add", httpWorkerAdapter.Add)
 	addBalance.Handle("/add",
-		xray.Handler(xray.NewFixedSegmentNamer(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".add")),
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".add"),
 		//xray.Handler(xray.NewFixedSegmentNamer("go-rest-balance-charges.add"), 
-		http.HandlerFunc(httpWorkerAdapter.Add),
+		httpWorkerAdapter.Idempotent("add", httpWorkerAdapter.Add),
 		),
 	)
 	addBalance.Use(MiddleWareHandlerHeader)
+	addBalance.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeWrite))
 
 	getBalance := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
     //getBalance.HandleFunc("/get/{id}", httpWorkerAdapter.Get)
 	getBalance.Handle("/get/{id}",
-		xray.Handler(xray.NewFixedSegmentNamer(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".getId")),
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".getId"),
 		//xray.Handler(xray.NewFixedSegmentNamer("go-rest-balance-charges.getId"), 
 		http.HandlerFunc(httpWorkerAdapter.Get),
 		),
 	)
 	getBalance.Use(MiddleWareHandlerHeader)
+	getBalance.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeRead))
 
 	getBalanceCb := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
     //getBalance.HandleFunc("/get/{id}", httpWorkerAdapter.Get)
 	getBalanceCb.Handle("/getCb/{id}",
-		xray.Handler(xray.NewFixedSegmentNamer(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".getId")),
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".getId"),
 		//xray.Handler(xray.NewFixedSegmentNamer("go-rest-balance-charges.getId"), 
 		http.HandlerFunc(httpWorkerAdapter.GetCb),
 		),
 	)
 	getBalanceCb.Use(MiddleWareHandlerHeader)
+	getBalanceCb.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeRead))
 
 	listBalance := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
     //listBalance.HandleFunc("/list/{id}", httpWorkerAdapter.List)
 	listBalance.Handle("/list/{id}",
-		xray.Handler(xray.NewFixedSegmentNamer(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".listId")),
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".listId"),
 		//xray.Handler(xray.NewFixedSegmentNamer("go-rest-balance-charges.listId"), 
 		http.HandlerFunc(httpWorkerAdapter.List),
 		),
 	)
 	listBalance.Use(MiddleWareHandlerHeader)
+	listBalance.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeRead))
+
+	statement := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
+	statement.Handle("/accounts/{id}/statement",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".statement"),
+		http.HandlerFunc(httpWorkerAdapter.Statement),
+		),
+	)
+	statement.Use(MiddleWareHandlerHeader)
+	statement.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeRead))
 
 	withdrawCbCtx := myRouter.Methods(http.MethodPost, http.MethodOptions).Subrouter()
 	withdrawCbCtx.Handle("/withdraw",
-		xray.Handler(xray.NewFixedSegmentNamer(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".withdraw")),
-		http.HandlerFunc(httpWorkerAdapter.WithdrawCbCtx),
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".withdraw"),
+		httpWorkerAdapter.Idempotent("withdraw", httpWorkerAdapter.WithdrawCbCtx),
 		),
 	)
 	withdrawCbCtx.Use(MiddleWareHandlerHeader)
+	withdrawCbCtx.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeWrite))
 
 	GetCache := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
 	GetCache.Handle("/getCache/{id}",
-		xray.Handler(xray.NewFixedSegmentNamer(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".GetCache")),
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".GetCache"),
 		http.HandlerFunc(httpWorkerAdapter.GetCache),
 		),
 	)
 	GetCache.Use(MiddleWareHandlerHeader)
+	GetCache.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeRead))
+
+	reverseCharge := myRouter.Methods(http.MethodPost, http.MethodOptions).Subrouter()
+	reverseCharge.Handle("/charges/{id}/reverse",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".reverse"),
+		httpWorkerAdapter.Idempotent("reverse", httpWorkerAdapter.Reverse),
+		),
+	)
+	reverseCharge.Use(MiddleWareHandlerHeader)
+	reverseCharge.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeWrite))
+
+	createHold := myRouter.Methods(http.MethodPost, http.MethodOptions).Subrouter()
+	createHold.Handle("/holds",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".createHold"),
+		httpWorkerAdapter.Idempotent("hold", httpWorkerAdapter.CreateHold),
+		),
+	)
+	createHold.Use(MiddleWareHandlerHeader)
+	createHold.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeWrite))
+
+	getHold := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
+	getHold.Handle("/holds/{id}",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".getHold"),
+		http.HandlerFunc(httpWorkerAdapter.GetHold),
+		),
+	)
+	getHold.Use(MiddleWareHandlerHeader)
+	getHold.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeRead))
+
+	captureHold := myRouter.Methods(http.MethodPost, http.MethodOptions).Subrouter()
+	captureHold.Handle("/holds/{id}/capture",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".captureHold"),
+		httpWorkerAdapter.Idempotent("capture", httpWorkerAdapter.CaptureHold),
+		),
+	)
+	captureHold.Use(MiddleWareHandlerHeader)
+	captureHold.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeWrite))
+
+	releaseHold := myRouter.Methods(http.MethodPost, http.MethodOptions).Subrouter()
+	releaseHold.Handle("/holds/{id}/release",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".releaseHold"),
+		http.HandlerFunc(httpWorkerAdapter.ReleaseHold),
+		),
+	)
+	releaseHold.Use(MiddleWareHandlerHeader)
+	releaseHold.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeWrite))
+
+	available := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
+	available.Handle("/available/{id}",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".available"),
+		http.HandlerFunc(httpWorkerAdapter.Available),
+		),
+	)
+	available.Use(MiddleWareHandlerHeader)
+	available.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeRead))
+
+	listBreakers := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
+	listBreakers.Handle("/admin/breakers",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".listBreakers"),
+		http.HandlerFunc(httpWorkerAdapter.ListBreakers),
+		),
+	)
+	listBreakers.Use(MiddleWareHandlerHeader)
+	listBreakers.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeAdmin))
+
+	forceBreaker := myRouter.Methods(http.MethodPost, http.MethodOptions).Subrouter()
+	forceBreaker.Handle("/admin/breakers/{name}/{action}",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".forceBreaker"),
+		http.HandlerFunc(httpWorkerAdapter.ForceBreaker),
+		),
+	)
+	forceBreaker.Use(MiddleWareHandlerHeader)
+	forceBreaker.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeAdmin))
+
+	listReconciliations := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
+	listReconciliations.Handle("/admin/reconciliation",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".listReconciliations"),
+		http.HandlerFunc(httpWorkerAdapter.ListReconciliations),
+		),
+	)
+	listReconciliations.Use(MiddleWareHandlerHeader)
+	listReconciliations.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeAdmin))
+
+	trialBalance := myRouter.Methods(http.MethodGet, http.MethodOptions).Subrouter()
+	trialBalance.Handle("/ledger/trial-balance",
+		tracing.Handler(fmt.Sprintf("%s%s%s", "balance-charges:", h.httpAppServer.InfoPod.AvailabilityZone, ".trialBalance"),
+		http.HandlerFunc(httpWorkerAdapter.TrialBalance),
+		),
+	)
+	trialBalance.Use(MiddleWareHandlerHeader)
+	trialBalance.Use(httpWorkerAdapter.MiddleWareAuth(auth.ScopeAdmin))
 
 	srv := http.Server{
 		Addr:         ":" +  strconv.Itoa(h.httpAppServer.Server.Port),      	
@@ -0,0 +1,141 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var childLogger = log.With().Str("health", "Checker").Logger()
+
+const (
+	StatusUp		= "up"
+	StatusDown		= "down"
+	StatusOk		= "ok"
+	StatusDegraded	= "degraded"
+)
+
+// Check is the probe of a dependency, the service is not ready while a critical one is
+// down, the others (e.g. Redis) only degrade it
+type Check struct {
+	Name		string
+	Critical	bool
+	Run			func(ctx context.Context) error
+}
+
+type ComponentStatus struct {
+	Name		string		`json:"name"`
+	Status		string		`json:"status"`
+	Critical	bool		`json:"critical"`
+	LatencyMs	float64		`json:"latency_ms"`
+	Error		string		`json:"error,omitempty"`
+}
+
+// Report is ok when every dependency is up, degraded when only non critical ones are
+// down and down otherwise
+type Report struct {
+	Status		string				`json:"status"`
+	CheckedAt	time.Time			`json:"checked_at"`
+	Components	[]ComponentStatus	`json:"components"`
+}
+
+func (r Report) Ready() bool {
+	return r.Status != StatusDown
+}
+
+// Checker runs the checks concurrently, each bounded by timeout. The report is kept for
+// ttl so frequent probes (several pods, liveness and readiness) do not load the dependencies
+type Checker struct {
+	checks		[]Check
+	timeout		time.Duration
+	ttl			time.Duration
+	mutex		sync.Mutex
+	report		*Report
+}
+
+func NewChecker(timeout time.Duration, ttl time.Duration, checks ...Check) *Checker {
+	childLogger.Debug().Dur("timeout", timeout).Dur("ttl", ttl).Msg("NewChecker")
+
+	if timeout <= 0 {
+		timeout = time.Second * 2
+	}
+	return &Checker{
+		checks:		checks,
+		timeout:	timeout,
+		ttl:		ttl,
+	}
+}
+
+// Check returns the cached report or runs the checks, the concurrent callers wait for
+// the run in progress. The checks do not end with the request of the probe, the report
+// is shared
+func (c *Checker) Check() Report {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.report != nil && time.Since(c.report.CheckedAt) < c.ttl {
+		return *c.report
+	}
+
+	report := Report{	Status: StatusOk,
+						CheckedAt: time.Now(),
+						Components: make([]ComponentStatus, len(c.checks)) }
+
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			report.Components[i] = c.run(check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, component := range report.Components {
+		if component.Status == StatusUp {
+			continue
+		}
+		if component.Critical {
+			report.Status = StatusDown
+		} else if report.Status == StatusOk {
+			report.Status = StatusDegraded
+		}
+	}
+	if report.Status != StatusOk {
+		childLogger.Warn().Str("status", report.Status).Interface("components", report.Components).Msg("Readiness")
+	}
+
+	c.report = &report
+	return report
+}
+
+// run bounds the check by the timeout, also when it does not honour the context
+func (c *Checker) run(check Check) ComponentStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	status := ComponentStatus{	Name: check.Name,
+								Status: StatusUp,
+								Critical: check.Critical,
+								LatencyMs: float64(time.Since(start).Microseconds()) / 1000 }
+	if err != nil {
+		status.Status = StatusDown
+		status.Error = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+)
+
+var childLogger = log.With().Str("metrics", "metrics").Logger()
+
+const namespace = "balance_charges"
+
+// Registry of the metrics of GET /metrics, with the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:	namespace,
+		Subsystem:	"http",
+		Name:		"request_duration_seconds",
+		Help:		"Duration of the HTTP requests by route template, method and status",
+		Buckets:	prometheus.DefBuckets,
+	}, []string{ "route", "method", "status" })
+
+	BalanceRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:	namespace,
+		Subsystem:	"balance",
+		Name:		"request_duration_seconds",
+		Help:		"Duration of each attempt of the requests to go-rest-balance by method and status (error when there was no response)",
+		Buckets:	prometheus.DefBuckets,
+	}, []string{ "method", "status" })
+
+	RedisCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:	namespace,
+		Subsystem:	"redis",
+		Name:		"command_duration_seconds",
+		Help:		"Duration of the Redis commands by command and status (ok, nil or error)",
+		Buckets:	[]float64{ .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1 },
+	}, []string{ "command", "status" })
+
+	ChargesCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:	namespace,
+		Name:		"charges_created_total",
+		Help:		"Charges created by type_charge and currency",
+	}, []string{ "type_charge", "currency" })
+
+	ChargeAmount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:	namespace,
+		Name:		"charge_amount_total",
+		Help:		"Sum of the absolute amounts of the charges created, in units of the currency",
+	}, []string{ "type_charge", "currency" })
+
+	WithdrawalsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:	namespace,
+		Name:		"withdrawals_rejected_total",
+		Help:		"Withdrawals rejected by reason (no_fund)",
+	}, []string{ "reason" })
+
+	OutboxEventsDead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:	namespace,
+		Name:		"outbox_events_dead_total",
+		Help:		"Outbox events given up (DEAD) after the max attempts, by event_type",
+	}, []string{ "event_type" })
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HttpRequestDuration,
+		BalanceRequestDuration,
+		RedisCommandDuration,
+		ChargesCreated,
+		ChargeAmount,
+		WithdrawalsRejected,
+		OutboxEventsDead,
+	)
+}
+
+// Handler answers the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RegisterDB exposes the connection pool stats of the database (sql.DB.Stats)
+func RegisterDB(db *sql.DB, name string) {
+	childLogger.Debug().Str("name", name).Msg("RegisterDB")
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterBreakers exposes the state of every breaker of the registry
+func RegisterBreakers(breakers *circuitbreaker.Registry) {
+	childLogger.Debug().Msg("RegisterBreakers")
+	Registry.MustRegister(breakerCollector{ breakers: breakers })
+}
+
+// ChargeCreated counts a charge and its amount
+func ChargeCreated(balanceCharge core.BalanceCharge) {
+	ChargesCreated.WithLabelValues(balanceCharge.Type, balanceCharge.Currency).Inc()
+
+	amount := balanceCharge.Amount
+	if amount.Sign() < 0 {
+		amount = amount.Neg()
+	}
+	value, err := strconv.ParseFloat(amount.String(), 64)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("error ParseFloat")
+		return
+	}
+	ChargeAmount.WithLabelValues(balanceCharge.Type, balanceCharge.Currency).Add(value)
+}
+
+// ------------------- breakers -------------------
+
+var breakerStateDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "circuit_breaker", "state"),
+	"State of the circuit breaker: 0 closed, 1 half-open, 2 open (forced states included)",
+	[]string{ "name" }, nil,
+)
+
+// breakerCollector reads the states when scraped, so no state change is missed
+type breakerCollector struct {
+	breakers	*circuitbreaker.Registry
+}
+
+func (c breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- breakerStateDesc
+}
+
+func (c breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range c.breakers.Status() {
+		state := status.State
+		if status.Forced != "" {
+			state = status.Forced
+		}
+		value := 0.0
+		switch state {
+		case "half-open":
+			value = 1
+		case "open":
+			value = 2
+		}
+		ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, value, status.Name)
+	}
+}
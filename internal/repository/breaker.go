@@ -0,0 +1,312 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+
+)
+
+// BreakerRepository runs every call of the wrapped repository through the breaker of the
+// database, the calls inside an open transaction included
+type BreakerRepository struct {
+	repository	ChargeRepository
+	breaker		*circuitbreaker.Breaker
+}
+
+func NewBreakerRepository(repository ChargeRepository, breaker *circuitbreaker.Breaker) BreakerRepository {
+	return BreakerRepository{
+		repository:	repository,
+		breaker:	breaker,
+	}
+}
+
+// BreakerSuccess tells the errors that are not a failure of the database
+func BreakerSuccess(err error) bool {
+	return err == nil || errors.Is(err, erro.ErrNotFound) || errors.Is(err, context.Canceled)
+}
+
+// Ping is not counted, the health check must see the real state of the database
+func (r BreakerRepository) Ping(ctx context.Context) (bool, error) {
+	return r.repository.Ping(ctx)
+}
+
+func (r BreakerRepository) StartTx(ctx context.Context) (res Tx, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.StartTx(ctx)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) Add(ctx context.Context, balanceCharge core.BalanceCharge) (res *core.BalanceCharge, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.Add(ctx, balanceCharge)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) Get(ctx context.Context, balanceCharge core.BalanceCharge) (res *core.BalanceCharge, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.Get(ctx, balanceCharge)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) List(ctx context.Context, filter core.ChargeFilter) (res *[]core.BalanceCharge, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.List(ctx, filter)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) AddCtx(ctx context.Context, tx Tx, balanceCharge core.BalanceCharge) (res *core.BalanceCharge, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.AddCtx(ctx, tx, balanceCharge)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) GetForUpdateCtx(ctx context.Context, tx Tx, balanceCharge core.BalanceCharge) (res *core.BalanceCharge, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.GetForUpdateCtx(ctx, tx, balanceCharge)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) SumReversalsCtx(ctx context.Context, tx Tx, balanceCharge core.BalanceCharge) (res *core.Money, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.SumReversalsCtx(ctx, tx, balanceCharge)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) SumChargesSince(ctx context.Context, balanceCharge core.BalanceCharge, since time.Time) (res *core.Money, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.SumChargesSince(ctx, balanceCharge, since)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) LockBalanceCtx(ctx context.Context, tx Tx, fkBalanceID int) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.LockBalanceCtx(ctx, tx, fkBalanceID)
+	})
+}
+
+func (r BreakerRepository) SumActiveHolds(ctx context.Context, balanceHold core.BalanceHold) (res *core.Money, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.SumActiveHolds(ctx, balanceHold)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) SumActiveHoldsCtx(ctx context.Context, tx Tx, balanceHold core.BalanceHold) (res *core.Money, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.SumActiveHoldsCtx(ctx, tx, balanceHold)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) AddHoldCtx(ctx context.Context, tx Tx, balanceHold core.BalanceHold) (res *core.BalanceHold, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.AddHoldCtx(ctx, tx, balanceHold)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) GetHold(ctx context.Context, balanceHold core.BalanceHold) (res *core.BalanceHold, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.GetHold(ctx, balanceHold)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) GetHoldForUpdateCtx(ctx context.Context, tx Tx, balanceHold core.BalanceHold) (res *core.BalanceHold, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.GetHoldForUpdateCtx(ctx, tx, balanceHold)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) UpdateHoldCtx(ctx context.Context, tx Tx, balanceHold core.BalanceHold) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.UpdateHoldCtx(ctx, tx, balanceHold)
+	})
+}
+
+func (r BreakerRepository) ExpireHolds(ctx context.Context) (res int64, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.ExpireHolds(ctx)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) ClaimIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey, lockTimeout time.Duration) (res *core.IdempotencyKey, ok bool, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, ok, err_call = r.repository.ClaimIdempotencyKey(ctx, idempotencyKey, lockTimeout)
+		return err_call
+	})
+	return res, ok, err
+}
+
+func (r BreakerRepository) CompleteIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.CompleteIdempotencyKey(ctx, idempotencyKey)
+	})
+}
+
+func (r BreakerRepository) ReleaseIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.ReleaseIdempotencyKey(ctx, idempotencyKey)
+	})
+}
+
+func (r BreakerRepository) DeleteIdempotencyKeys(ctx context.Context, before time.Time) (res int64, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.DeleteIdempotencyKeys(ctx, before)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) CreateSaga(ctx context.Context, saga core.Saga) (res *core.Saga, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.CreateSaga(ctx, saga)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) UpdateSaga(ctx context.Context, saga core.Saga) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.UpdateSaga(ctx, saga)
+	})
+}
+
+func (r BreakerRepository) UpdateSagaCtx(ctx context.Context, tx Tx, saga core.Saga) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.UpdateSagaCtx(ctx, tx, saga)
+	})
+}
+
+func (r BreakerRepository) SaveSagaStep(ctx context.Context, sagaStep core.SagaStep) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.SaveSagaStep(ctx, sagaStep)
+	})
+}
+
+func (r BreakerRepository) SaveSagaStepCtx(ctx context.Context, tx Tx, sagaStep core.SagaStep) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.SaveSagaStepCtx(ctx, tx, sagaStep)
+	})
+}
+
+func (r BreakerRepository) ListIncompleteSagas(ctx context.Context, updatedBefore time.Time) (res *[]core.Saga, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.ListIncompleteSagas(ctx, updatedBefore)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) ClaimSaga(ctx context.Context, saga core.Saga, status string) (res bool, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.ClaimSaga(ctx, saga, status)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) AddOutboxEventCtx(ctx context.Context, tx Tx, outboxEvent core.OutboxEvent) (res *core.OutboxEvent, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.AddOutboxEventCtx(ctx, tx, outboxEvent)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) ClaimOutboxEventsCtx(ctx context.Context, tx Tx, limit int, leaseUntil time.Time) (res *[]core.OutboxEvent, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.ClaimOutboxEventsCtx(ctx, tx, limit, leaseUntil)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) UpdateOutboxEventCtx(ctx context.Context, tx Tx, outboxEvent core.OutboxEvent) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.UpdateOutboxEventCtx(ctx, tx, outboxEvent)
+	})
+}
+
+func (r BreakerRepository) ListChargeAccounts(ctx context.Context) (res *[]core.BalanceCharge, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.ListChargeAccounts(ctx)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) GetOpenReconciliationCtx(ctx context.Context, tx Tx, fkBalanceID int) (res *core.Reconciliation, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.GetOpenReconciliationCtx(ctx, tx, fkBalanceID)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) AddReconciliationCtx(ctx context.Context, tx Tx, reconciliation core.Reconciliation) (res *core.Reconciliation, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.AddReconciliationCtx(ctx, tx, reconciliation)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) UpdateReconciliationCtx(ctx context.Context, tx Tx, reconciliation core.Reconciliation) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.UpdateReconciliationCtx(ctx, tx, reconciliation)
+	})
+}
+
+func (r BreakerRepository) ListReconciliations(ctx context.Context, status string) (res *[]core.Reconciliation, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.ListReconciliations(ctx, status)
+		return err_call
+	})
+	return res, err
+}
+
+func (r BreakerRepository) AddPostingsCtx(ctx context.Context, tx Tx, postings []core.LedgerPosting) (error){
+	return r.breaker.Run(func() error {
+		return r.repository.AddPostingsCtx(ctx, tx, postings)
+	})
+}
+
+func (r BreakerRepository) SumPostings(ctx context.Context, currency string) (res *[]core.LedgerAccountBalance, err error){
+	err = r.breaker.Run(func() (err_call error) {
+		res, err_call = r.repository.SumPostings(ctx, currency)
+		return err_call
+	})
+	return res, err
+}
+
+var _ ChargeRepository = BreakerRepository{}
@@ -0,0 +1,60 @@
+package cache_redis
+
+import (
+	"context"
+	"errors"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+)
+
+// BreakerCache runs every call of the wrapped cache through the breaker of Redis
+type BreakerCache struct {
+	cache	Cache
+	breaker	*circuitbreaker.Breaker
+}
+
+func NewBreakerCache(cache Cache, breaker *circuitbreaker.Breaker) *BreakerCache {
+	return &BreakerCache{
+		cache:		cache,
+		breaker:	breaker,
+	}
+}
+
+// BreakerSuccess tells the errors that are not a failure of Redis (a missing key)
+func BreakerSuccess(err error) bool {
+	return err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled)
+}
+
+func (b *BreakerCache) Sum(ctx context.Context, key string, value int64) (error) {
+	return b.breaker.Run(func() error {
+		return b.cache.Sum(ctx, key, value)
+	})
+}
+
+func (b *BreakerCache) Reserve(ctx context.Context, key string, value int64, limit int64) (res bool, err error) {
+	err = b.breaker.Run(func() (err_call error) {
+		res, err_call = b.cache.Reserve(ctx, key, value, limit)
+		return err_call
+	})
+	return res, err
+}
+
+func (b *BreakerCache) Get(ctx context.Context, key string) (res interface{}, err error) {
+	err = b.breaker.Run(func() (err_call error) {
+		res, err_call = b.cache.Get(ctx, key)
+		return err_call
+	})
+	return res, err
+}
+
+func (b *BreakerCache) Put(ctx context.Context, key string, value interface{}) error {
+	return b.breaker.Run(func() error {
+		return b.cache.Put(ctx, key, value)
+	})
+}
+
+// Ping is not counted, the health check must see the real state of Redis
+func (b *BreakerCache) Ping(ctx context.Context) (string, error) {
+	return b.cache.Ping(ctx)
+}
@@ -0,0 +1,118 @@
+package cache_redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+type memoryEntry struct {
+	amount		int64
+	value		string
+	isValue		bool
+	expiresAt	time.Time
+}
+
+// MemoryCache keeps the keys in the process with the same expiries as Redis, it is
+// only consistent for a single pod (local development and tests)
+type MemoryCache struct {
+	mutex	sync.Mutex
+	entries	map[string]memoryEntry
+}
+
+func NewMemoryCache(ctx context.Context) *MemoryCache {
+	childLogger.Debug().Msg("NewMemoryCache")
+
+	return &MemoryCache{
+		entries: map[string]memoryEntry{},
+	}
+}
+
+// entry returns the live entry of the key, the expired ones are dropped
+func (m *MemoryCache) entry(key string) (memoryEntry, bool) {
+	entry, ok := m.entries[key]
+	if ok && !entry.expiresAt.After(time.Now()) {
+		delete(m.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, ok
+}
+
+// Sum increments the pending amount of the key, the value is in minor units (core.Money.Units)
+func (m *MemoryCache) Sum(ctx context.Context, key string, value int64) (error) {
+	childLogger.Debug().Msg("Sum")
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, _ := m.entry("credit:" + key)
+	if entry.isValue {
+		return fmt.Errorf("WRONGTYPE credit:%s is not a hash", key)
+	}
+	entry.amount = entry.amount + value
+	entry.expiresAt = time.Now().Add(time.Minute * 1)
+	m.entries["credit:" + key] = entry
+
+	return nil
+}
+
+// Reserve adds the value (minor units) to the pending amount of the key when the result is
+// covered by the limit (the available funds). It returns false, without changing the pending
+// amount, when there is no fund
+func (m *MemoryCache) Reserve(ctx context.Context, key string, value int64, limit int64) (bool, error) {
+	childLogger.Debug().Msg("Reserve")
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, _ := m.entry("credit:" + key)
+	if entry.isValue {
+		return false, fmt.Errorf("WRONGTYPE credit:%s is not a hash", key)
+	}
+	if value < 0 && entry.amount + value + limit < 0 {
+		return false, nil
+	}
+	entry.amount = entry.amount + value
+	entry.expiresAt = time.Now().Add(time.Minute * 1)
+	m.entries["credit:" + key] = entry
+
+	return true, nil
+}
+
+func (m *MemoryCache) Get(ctx context.Context, key string) (interface{}, error) {
+	childLogger.Debug().Msg("Get")
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, ok := m.entry("credit:" + key)
+	if !ok {
+		return nil, redis.Nil
+	}
+	if entry.isValue {
+		return nil, fmt.Errorf("WRONGTYPE credit:%s is not a hash", key)
+	}
+
+	return strconv.FormatInt(entry.amount, 10), nil
+}
+
+func (m *MemoryCache) Put(ctx context.Context, key string, value interface{}) error {
+	childLogger.Debug().Msg("Put")
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries["credit:" + key] = memoryEntry{	value: fmt.Sprint(value),
+												isValue: true,
+												expiresAt: time.Now().Add(time.Minute * 10) }
+	return nil
+}
+
+func (m *MemoryCache) Ping(ctx context.Context) (string, error) {
+	childLogger.Debug().Msg("Ping")
+	return "PONG", nil
+}
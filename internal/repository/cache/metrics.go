@@ -0,0 +1,50 @@
+package cache_redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/go-rest-balance-charges/internal/metrics"
+)
+
+// metricsHook measures every Redis command (metrics.RedisCommandDuration)
+type metricsHook struct{}
+
+func (metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		metrics.RedisCommandDuration.WithLabelValues(strings.ToLower(cmd.Name()), commandStatus(err)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func (metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		metrics.RedisCommandDuration.WithLabelValues("pipeline", commandStatus(err)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func commandStatus(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, redis.Nil):
+		return "nil"
+	}
+	return "error"
+}
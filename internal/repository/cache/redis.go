@@ -6,14 +6,23 @@ import (
 	"github.com/rs/zerolog/log"
 
 	redis "github.com/redis/go-redis/v9"
-	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-rest-balance-charges/internal/tracing"
 )
 
 var childLogger = log.With().Str("repository/cache", "Redis").Logger()
 
+// Cache keeps the pending amount per account while the charges are processed,
+// implemented by CacheService (Redis cluster or single node) and MemoryCache
+type Cache interface {
+	Sum(ctx context.Context, key string, value int64) (error)
+	Reserve(ctx context.Context, key string, value int64, limit int64) (bool, error)
+	Get(ctx context.Context, key string) (interface{}, error)
+	Put(ctx context.Context, key string, value interface{}) error
+	Ping(ctx context.Context) (string, error)
+}
+
 type CacheService struct {
-	cache *redis.ClusterClient
-	cache_s *redis.Client
+	cache redis.UniversalClient
 }
 
 func NewCache(ctx context.Context, options *redis.Options) *CacheService {
@@ -21,8 +30,9 @@ func NewCache(ctx context.Context, options *redis.Options) *CacheService {
 	childLogger.Debug().Interface("option.Addr:", options.Addr).Msg("")
 
 	redisClient := redis.NewClient(options)
+	redisClient.AddHook(metricsHook{})
 	return &CacheService{
-		cache_s: redisClient,
+		cache: redisClient,
 	}
 }
 
@@ -31,20 +41,22 @@ func NewClusterCache(ctx context.Context, options *redis.ClusterOptions) *CacheS
 	childLogger.Debug().Interface("option.Addrs: ", options.Addrs).Msg("")
 
 	redisClient := redis.NewClusterClient(options)
+	redisClient.AddHook(metricsHook{})
 	return &CacheService{
 		cache: redisClient,
 	}
 }
 
-func (s *CacheService) Sum(ctx context.Context, key string, value interface{}) (error) {
+// Sum increments the pending amount of the key, the value is in minor units (core.Money.Units)
+func (s *CacheService) Sum(ctx context.Context, key string, value int64) (error) {
 	childLogger.Debug().Msg("Sum")
 
-	_, root := xray.BeginSubsegment(ctx, "REDIS.HIncrByFloat-Balance-Charges")
+	_, root := tracing.Start(ctx, "REDIS.HIncrBy-Balance-Charges")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
 
-	_, err := s.cache.HIncrByFloat(ctx, "credit:" + key, "amount", value.(float64)).Result()
+	_, err := s.cache.HIncrBy(ctx, "credit:" + key, "amount", value).Result()
 	if err != nil {
 		return err
 	}
@@ -54,12 +66,47 @@ func (s *CacheService) Sum(ctx context.Context, key string, value interface{}) (
 	return nil
 }
 
+// reserveScript increments the pending amount only if the new pending amount plus the
+// limit stays positive, the check and the increment are done atomically by Redis
+var reserveScript = redis.NewScript(`
+local pending = tonumber(redis.call("HGET", KEYS[1], "amount") or "0")
+local value = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+if value < 0 and pending + value + limit < 0 then
+	return {0, pending}
+end
+pending = redis.call("HINCRBY", KEYS[1], "amount", value)
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return {1, pending}
+`)
+
+// Reserve adds the value (minor units) to the pending amount of the key when the result is
+// covered by the limit (the available funds). It returns false, without changing the pending
+// amount, when there is no fund
+func (s *CacheService) Reserve(ctx context.Context, key string, value int64, limit int64) (bool, error) {
+	childLogger.Debug().Msg("Reserve")
+
+	_, root := tracing.Start(ctx, "REDIS.Reserve-Balance-Charges")
+	defer func() {
+		root.End(nil)
+	}()
+
+	res, err := reserveScript.Run(ctx, s.cache, []string{"credit:" + key}, value, limit, (time.Minute * 1).Milliseconds()).Slice()
+	if err != nil {
+		return false, err
+	}
+
+	childLogger.Debug().Interface("+++++ RES : ",res).Msg("Reserve")
+
+	return res[0].(int64) == 1, nil
+}
+
 func (s *CacheService) Get(ctx context.Context, key string) (interface{}, error) {
 	childLogger.Debug().Msg("Get")
 
-	_, root := xray.BeginSubsegment(ctx, "REDIS.HGet-Balance-Charges")
+	_, root := tracing.Start(ctx, "REDIS.HGet-Balance-Charges")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
 
 	res, err := s.cache.HGet(ctx, "credit:"+ key, "amount").Result()
@@ -75,9 +122,9 @@ func (s *CacheService) Get(ctx context.Context, key string) (interface{}, error)
 func (s *CacheService) Put(ctx context.Context, key string, value interface{}) error {
 	childLogger.Debug().Msg("Put")
 	
-	_, root := xray.BeginSubsegment(ctx, "REDIS.Set-Balance-Charges")
+	_, root := tracing.Start(ctx, "REDIS.Set-Balance-Charges")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
 
 	status := s.cache.Set(ctx, "credit:"+ key, value, time.Minute * 10)
@@ -0,0 +1,401 @@
+package db_memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+var childLogger = log.With().Str("repository/memory", "WorkerRepository").Logger()
+
+// store holds the tables, the values are copied in and out so callers never share them
+type store struct {
+	charges			map[int]core.BalanceCharge
+	holds			map[int]core.BalanceHold
+	idempotencyKeys	map[string]core.IdempotencyKey
+	sagas			map[int]core.Saga
+	outboxEvents	map[int64]core.OutboxEvent
+	reconciliations	map[int]core.Reconciliation
+	postings		map[int64]core.LedgerPosting
+}
+
+func newStore() *store {
+	return &store{
+		charges:			map[int]core.BalanceCharge{},
+		holds:				map[int]core.BalanceHold{},
+		idempotencyKeys:	map[string]core.IdempotencyKey{},
+		sagas:				map[int]core.Saga{},
+		outboxEvents:		map[int64]core.OutboxEvent{},
+		reconciliations:	map[int]core.Reconciliation{},
+		postings:			map[int64]core.LedgerPosting{},
+	}
+}
+
+func (s *store) clone() *store {
+	c := newStore()
+	for k, v := range s.charges {
+		c.charges[k] = v
+	}
+	for k, v := range s.holds {
+		c.holds[k] = v
+	}
+	for k, v := range s.idempotencyKeys {
+		c.idempotencyKeys[k] = v
+	}
+	for k, v := range s.sagas {
+		v.Steps = append([]core.SagaStep(nil), v.Steps...)
+		c.sagas[k] = v
+	}
+	for k, v := range s.outboxEvents {
+		c.outboxEvents[k] = v
+	}
+	for k, v := range s.reconciliations {
+		c.reconciliations[k] = v
+	}
+	for k, v := range s.postings {
+		c.postings[k] = v
+	}
+	return c
+}
+
+type sequences struct {
+	charge	int
+	hold	int
+	saga	int
+	outbox	int64
+	reconciliation	int
+	posting	int64
+}
+
+// WorkerRepository keeps everything in memory, for local development and tests.
+// The transactions are serialized (one open at a time) and work on a copy of the
+// data, their writes are replayed on the shared data at commit. The methods without
+// transaction are atomic and do not wait for the open transaction.
+type WorkerRepository struct {
+	mutex	*sync.RWMutex
+	txLock	chan struct{}
+	data	*store
+	seq		*sequences
+}
+
+func NewWorkerRepository() WorkerRepository {
+	childLogger.Debug().Msg("NewWorkerRepository")
+	return WorkerRepository{
+		mutex:	&sync.RWMutex{},
+		txLock:	make(chan struct{}, 1),
+		data:	newStore(),
+		seq:	&sequences{},
+	}
+}
+
+// memoryTx is the repository.Tx of the memory repository
+type memoryTx struct {
+	repo	WorkerRepository
+	view	*store
+	ops		[]func(*store)
+	done	bool
+}
+
+func (t *memoryTx) apply(op func(*store)) {
+	op(t.view)
+	t.ops = append(t.ops, op)
+}
+
+func (t *memoryTx) Commit() error {
+	if t.done {
+		return erro.ErrTransactionDone
+	}
+	t.repo.mutex.Lock()
+	for _, op := range t.ops {
+		op(t.repo.data)
+	}
+	t.repo.mutex.Unlock()
+	t.finish()
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+	if t.done {
+		return erro.ErrTransactionDone
+	}
+	t.finish()
+	return nil
+}
+
+func (t *memoryTx) finish() {
+	t.done = true
+	t.view = nil
+	t.ops = nil
+	<-t.repo.txLock
+}
+
+// memTx returns the open memoryTx behind a transaction opened by StartTx
+func memTx(tx repository.Tx) (*memoryTx, error) {
+	mem_tx, ok := tx.(*memoryTx)
+	if !ok {
+		return nil, erro.ErrTransaction
+	}
+	if mem_tx.done {
+		return nil, erro.ErrTransactionDone
+	}
+	return mem_tx, nil
+}
+
+func (w WorkerRepository) read(fn func(*store)) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	fn(w.data)
+}
+
+func (w WorkerRepository) write(fn func(*store)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	fn(w.data)
+}
+
+// next allocates an id, like a serial the ids of rolled back rows are not reused
+func (w WorkerRepository) next(fn func(*sequences)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	fn(w.seq)
+}
+
+func (w WorkerRepository) StartTx(ctx context.Context) (repository.Tx, error) {
+	childLogger.Debug().Msg("StartTx")
+
+	select {
+	case w.txLock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	mem_tx := &memoryTx{ repo: w }
+	w.read(func(data *store) {
+		mem_tx.view = data.clone()
+	})
+
+	return mem_tx, nil
+}
+
+func (w WorkerRepository) Ping(ctx context.Context) (bool, error) {
+	childLogger.Debug().Msg("Ping")
+	return true, nil
+}
+
+func (w WorkerRepository) Add(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
+	childLogger.Debug().Msg("Add")
+
+	w.next(func(seq *sequences) {
+		seq.charge++
+		balanceCharge.ID = seq.charge
+	})
+	balanceCharge.ChargeAt = time.Now()
+	w.write(func(data *store) {
+		data.charges[balanceCharge.ID] = balanceCharge
+	})
+
+	return &balanceCharge, nil
+}
+
+func (w WorkerRepository) Get(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
+	childLogger.Debug().Msg("Get")
+
+	var res *core.BalanceCharge
+	w.read(func(data *store) {
+		res = getCharge(data, balanceCharge.ID)
+	})
+	if res == nil || (balanceCharge.TenantID != "" && res.TenantID != balanceCharge.TenantID) {
+		return nil, erro.ErrNotFound
+	}
+
+	return res, nil
+}
+
+func (w WorkerRepository) List(ctx context.Context, filter core.ChargeFilter) (*[]core.BalanceCharge, error){
+	childLogger.Debug().Msg("List")
+
+	balance_list := []core.BalanceCharge{}
+	w.read(func(data *store) {
+		for _, charge := range data.charges {
+			if charge.FkBalanceID == filter.FkBalanceID && matchCharge(filter, charge) {
+				balance_list = append(balance_list, charge)
+			}
+		}
+	})
+	sort.Slice(balance_list, func(i, j int) bool {
+		return compareCharge(filter.Sort, balance_list[i], balance_list[j]) < 0
+	})
+
+	if filter.After != nil {
+		after := core.BalanceCharge{ ID: filter.After.ID, ChargeAt: filter.After.ChargeAt }
+		if filter.Sort == core.SortAmountDesc || filter.Sort == core.SortAmountAsc {
+			after.Amount, _ = filter.After.Money()
+		}
+		i := sort.Search(len(balance_list), func(i int) bool {
+			return compareCharge(filter.Sort, balance_list[i], after) > 0
+		})
+		balance_list = balance_list[i:]
+	}
+	if filter.Limit > 0 && len(balance_list) > filter.Limit {
+		balance_list = balance_list[:filter.Limit]
+	}
+
+	return &balance_list, nil
+}
+
+func matchCharge(filter core.ChargeFilter, charge core.BalanceCharge) bool {
+	if filter.TenantID != "" && charge.TenantID != filter.TenantID {
+		return false
+	}
+	if filter.Type != "" && charge.Type != filter.Type {
+		return false
+	}
+	if filter.Currency != "" && charge.Currency != filter.Currency {
+		return false
+	}
+	if filter.From != nil && charge.ChargeAt.Before(*filter.From) {
+		return false
+	}
+	if filter.To != nil && !charge.ChargeAt.Before(*filter.To) {
+		return false
+	}
+	if filter.MinAmount != nil && charge.Amount.Cmp(*filter.MinAmount) < 0 {
+		return false
+	}
+	if filter.MaxAmount != nil && charge.Amount.Cmp(*filter.MaxAmount) > 0 {
+		return false
+	}
+	return true
+}
+
+// compareCharge orders the charges as the listing sort, ties broken by id
+func compareCharge(sort string, a core.BalanceCharge, b core.BalanceCharge) int {
+	c := 0
+	switch sort {
+	case core.SortAmountDesc, core.SortAmountAsc:
+		c = a.Amount.Cmp(b.Amount)
+	default:
+		if a.ChargeAt.Before(b.ChargeAt) {
+			c = -1
+		} else if a.ChargeAt.After(b.ChargeAt) {
+			c = 1
+		}
+	}
+	if c == 0 {
+		c = a.ID - b.ID
+	}
+	if sort == core.SortChargedAtAsc || sort == core.SortAmountAsc {
+		return c
+	}
+	return -c
+}
+
+func (w WorkerRepository) AddCtx(ctx context.Context, tx repository.Tx, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
+	childLogger.Debug().Msg("AddCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	w.next(func(seq *sequences) {
+		seq.charge++
+		balanceCharge.ID = seq.charge
+	})
+	balanceCharge.ChargeAt = time.Now()
+	charge := balanceCharge
+	mem_tx.apply(func(data *store) {
+		data.charges[charge.ID] = charge
+	})
+
+	return &balanceCharge, nil
+}
+
+// GetForUpdateCtx reads the charge, the transactions being serialized nothing else can change it
+func (w WorkerRepository) GetForUpdateCtx(ctx context.Context, tx repository.Tx, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
+	childLogger.Debug().Msg("GetForUpdateCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := getCharge(mem_tx.view, balanceCharge.ID)
+	if res == nil {
+		return nil, erro.ErrNotFound
+	}
+
+	return res, nil
+}
+
+// SumReversalsCtx returns the total already reversed of the charge (opposite sign of the charge),
+// its VOID included, the reversals voided by a compensated saga excluded
+func (w WorkerRepository) SumReversalsCtx(ctx context.Context, tx repository.Tx, balanceCharge core.BalanceCharge) (*core.Money, error){
+	childLogger.Debug().Msg("SumReversalsCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	voided := map[int]bool{}
+	for _, charge := range mem_tx.view.charges {
+		if charge.Type == "VOID" {
+			voided[charge.ReversalOf] = true
+		}
+	}
+
+	res := core.NewMoney(0, balanceCharge.Currency)
+	for _, charge := range mem_tx.view.charges {
+		if charge.ReversalOf != balanceCharge.ID || voided[charge.ID] {
+			continue
+		}
+		res, err = res.Add(charge.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &res, nil
+}
+
+func (w WorkerRepository) SumChargesSince(ctx context.Context, balanceCharge core.BalanceCharge, since time.Time) (*core.Money, error){
+	childLogger.Debug().Msg("SumChargesSince")
+
+	res := core.NewMoney(0, balanceCharge.Currency)
+	var err error
+	w.read(func(data *store) {
+		for _, charge := range data.charges {
+			if charge.FkBalanceID != balanceCharge.FkBalanceID ||
+				charge.Currency != balanceCharge.Currency ||
+				charge.ChargeAt.Before(since) ||
+				(balanceCharge.TenantID != "" && charge.TenantID != balanceCharge.TenantID) {
+				continue
+			}
+			res, err = res.Add(charge.Amount)
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func getCharge(data *store, id int) *core.BalanceCharge {
+	charge, ok := data.charges[id]
+	if !ok {
+		return nil
+	}
+	return &charge
+}
+
+var _ repository.ChargeRepository = WorkerRepository{}
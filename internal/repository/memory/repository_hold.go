@@ -0,0 +1,157 @@
+package db_memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+// LockBalanceCtx has nothing to do, the transactions are already serialized
+func (w WorkerRepository) LockBalanceCtx(ctx context.Context, tx repository.Tx, fkBalanceID int) (error){
+	childLogger.Debug().Msg("LockBalanceCtx")
+
+	_, err := memTx(tx)
+	return err
+}
+
+func (w WorkerRepository) SumActiveHolds(ctx context.Context, balanceHold core.BalanceHold) (res *core.Money, err error){
+	childLogger.Debug().Msg("SumActiveHolds")
+
+	w.read(func(data *store) {
+		res, err = sumActiveHolds(data, balanceHold)
+	})
+	return res, err
+}
+
+func (w WorkerRepository) SumActiveHoldsCtx(ctx context.Context, tx repository.Tx, balanceHold core.BalanceHold) (*core.Money, error){
+	childLogger.Debug().Msg("SumActiveHoldsCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return sumActiveHolds(mem_tx.view, balanceHold)
+}
+
+// sumActiveHolds returns the amount reserved by the ACTIVE and not expired holds of the balance
+func sumActiveHolds(data *store, balanceHold core.BalanceHold) (*core.Money, error){
+	now := time.Now()
+	res := core.NewMoney(0, balanceHold.Currency)
+	for _, hold := range data.holds {
+		if hold.FkBalanceID != balanceHold.FkBalanceID || hold.Status != "ACTIVE" || !hold.ExpiresAt.After(now) {
+			continue
+		}
+		var err error
+		res, err = res.Add(hold.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &res, nil
+}
+
+func (w WorkerRepository) AddHoldCtx(ctx context.Context, tx repository.Tx, balanceHold core.BalanceHold) (*core.BalanceHold, error){
+	childLogger.Debug().Msg("AddHoldCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	w.next(func(seq *sequences) {
+		seq.hold++
+		balanceHold.ID = seq.hold
+	})
+	balanceHold.CreatedAt = time.Now()
+	balanceHold.UpdatedAt = balanceHold.CreatedAt
+	hold := balanceHold
+	mem_tx.apply(func(data *store) {
+		data.holds[hold.ID] = hold
+	})
+
+	return &balanceHold, nil
+}
+
+func (w WorkerRepository) GetHold(ctx context.Context, balanceHold core.BalanceHold) (*core.BalanceHold, error){
+	childLogger.Debug().Msg("GetHold")
+
+	var res *core.BalanceHold
+	w.read(func(data *store) {
+		res = getHold(data, balanceHold)
+	})
+	if res == nil {
+		return nil, erro.ErrNotFound
+	}
+
+	return res, nil
+}
+
+// GetHoldForUpdateCtx reads the hold, the transactions being serialized nothing else can change it
+func (w WorkerRepository) GetHoldForUpdateCtx(ctx context.Context, tx repository.Tx, balanceHold core.BalanceHold) (*core.BalanceHold, error){
+	childLogger.Debug().Msg("GetHoldForUpdateCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := getHold(mem_tx.view, balanceHold)
+	if res == nil {
+		return nil, erro.ErrNotFound
+	}
+
+	return res, nil
+}
+
+// getHold filters by the tenant when it is set, the hold of another tenant is not found
+func getHold(data *store, balanceHold core.BalanceHold) *core.BalanceHold {
+	hold, ok := data.holds[balanceHold.ID]
+	if !ok || (balanceHold.TenantID != "" && hold.TenantID != balanceHold.TenantID) {
+		return nil
+	}
+	return &hold
+}
+
+func (w WorkerRepository) UpdateHoldCtx(ctx context.Context, tx repository.Tx, balanceHold core.BalanceHold) (error){
+	childLogger.Debug().Msg("UpdateHoldCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	mem_tx.apply(func(data *store) {
+		hold, ok := data.holds[balanceHold.ID]
+		if !ok {
+			return
+		}
+		hold.Status = balanceHold.Status
+		hold.CapturedAmount = balanceHold.CapturedAmount
+		hold.ChargeID = balanceHold.ChargeID
+		hold.UpdatedAt = now
+		data.holds[hold.ID] = hold
+	})
+
+	return nil
+}
+
+// ExpireHolds moves the ACTIVE holds past their expiry to EXPIRED
+func (w WorkerRepository) ExpireHolds(ctx context.Context) (rows int64, err error){
+	childLogger.Debug().Msg("ExpireHolds")
+
+	now := time.Now()
+	w.write(func(data *store) {
+		for id, hold := range data.holds {
+			if hold.Status == "ACTIVE" && !hold.ExpiresAt.After(now) {
+				hold.Status = "EXPIRED"
+				hold.UpdatedAt = now
+				data.holds[id] = hold
+				rows++
+			}
+		}
+	})
+
+	return rows, nil
+}
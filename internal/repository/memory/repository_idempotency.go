@@ -0,0 +1,93 @@
+package db_memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+
+)
+
+func idempotencyID(idempotencyKey core.IdempotencyKey) string {
+	return idempotencyKey.Operation + "\n" + idempotencyKey.Key
+}
+
+// ClaimIdempotencyKey tries to register the key as PROCESSING. It returns true when the
+// caller owns the key, otherwise the stored record so the caller can replay or reject it.
+// A PROCESSING record older than lockTimeout is considered abandoned and taken over.
+func (w WorkerRepository) ClaimIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey, lockTimeout time.Duration) (res *core.IdempotencyKey, claimed bool, err error){
+	childLogger.Debug().Msg("ClaimIdempotencyKey")
+
+	now := time.Now()
+	w.write(func(data *store) {
+		stored, ok := data.idempotencyKeys[idempotencyID(idempotencyKey)]
+		if !ok {
+			idempotencyKey.Status = "PROCESSING"
+			idempotencyKey.CreatedAt = now
+			idempotencyKey.UpdatedAt = now
+			data.idempotencyKeys[idempotencyID(idempotencyKey)] = idempotencyKey
+			res, claimed = &idempotencyKey, true
+			return
+		}
+
+		if 	stored.Status == "PROCESSING" &&
+			stored.RequestHash == idempotencyKey.RequestHash &&
+			stored.UpdatedAt.Before(now.Add(-lockTimeout)) {
+			childLogger.Debug().Str("idempotency_key", stored.Key).Msg("Abandoned key taken over")
+			stored.UpdatedAt = now
+			data.idempotencyKeys[idempotencyID(stored)] = stored
+			claimed = true
+		}
+		res = &stored
+	})
+
+	return res, claimed, nil
+}
+
+func (w WorkerRepository) CompleteIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error){
+	childLogger.Debug().Msg("CompleteIdempotencyKey")
+
+	w.write(func(data *store) {
+		stored, ok := data.idempotencyKeys[idempotencyID(idempotencyKey)]
+		if !ok {
+			return
+		}
+		stored.Status = "COMPLETED"
+		stored.ResponseStatus = idempotencyKey.ResponseStatus
+		stored.ResponseBody = append([]byte(nil), idempotencyKey.ResponseBody...)
+		stored.UpdatedAt = time.Now()
+		data.idempotencyKeys[idempotencyID(stored)] = stored
+	})
+
+	return nil
+}
+
+func (w WorkerRepository) ReleaseIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error){
+	childLogger.Debug().Msg("ReleaseIdempotencyKey")
+
+	w.write(func(data *store) {
+		stored, ok := data.idempotencyKeys[idempotencyID(idempotencyKey)]
+		if ok && stored.Status == "PROCESSING" {
+			delete(data.idempotencyKeys, idempotencyID(stored))
+		}
+	})
+
+	return nil
+}
+
+// DeleteIdempotencyKeys deletes the keys not updated since before, the responses are
+// no longer replayed after that
+func (w WorkerRepository) DeleteIdempotencyKeys(ctx context.Context, before time.Time) (rows int64, err error){
+	childLogger.Debug().Msg("DeleteIdempotencyKeys")
+
+	w.write(func(data *store) {
+		for id, stored := range data.idempotencyKeys {
+			if stored.UpdatedAt.Before(before) {
+				delete(data.idempotencyKeys, id)
+				rows++
+			}
+		}
+	})
+
+	return rows, nil
+}
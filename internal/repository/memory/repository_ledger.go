@@ -0,0 +1,106 @@
+package db_memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+// AddPostingsCtx checks the postings balance per currency, as the constraint of postgres
+func (w WorkerRepository) AddPostingsCtx(ctx context.Context, tx repository.Tx, postings []core.LedgerPosting) (error){
+	childLogger.Debug().Msg("AddPostingsCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return err
+	}
+
+	net := map[string]int64{}
+	for _, posting := range postings {
+		if posting.Amount.Sign() <= 0 {
+			return erro.ErrLedgerUnbalanced
+		}
+		if posting.Direction == core.PostingDebit {
+			net[posting.Currency] += posting.Amount.Units
+		} else {
+			net[posting.Currency] -= posting.Amount.Units
+		}
+	}
+	for _, units := range net {
+		if units != 0 {
+			return erro.ErrLedgerUnbalanced
+		}
+	}
+
+	created_at := time.Now()
+	for _, posting := range postings {
+		w.next(func(seq *sequences) {
+			seq.posting++
+			posting.ID = seq.posting
+		})
+		posting.CreatedAt = created_at
+		row := posting
+		mem_tx.apply(func(data *store) {
+			data.postings[row.ID] = row
+		})
+	}
+
+	return nil
+}
+
+func (w WorkerRepository) SumPostings(ctx context.Context, currency string) (*[]core.LedgerAccountBalance, error){
+	childLogger.Debug().Msg("SumPostings")
+
+	accounts := map[string]*core.LedgerAccountBalance{}
+	var err error
+	w.read(func(data *store) {
+		for _, posting := range data.postings {
+			if currency != "" && posting.Currency != currency {
+				continue
+			}
+			key := posting.Currency + "|" + posting.Account
+			account, ok := accounts[key]
+			if !ok {
+				account = &core.LedgerAccountBalance{	Account:		posting.Account,
+														AccountType:	posting.AccountType,
+														Currency:		posting.Currency,
+														Debits:			core.NewMoney(0, posting.Currency),
+														Credits:		core.NewMoney(0, posting.Currency),
+													}
+				accounts[key] = account
+			}
+			if posting.Direction == core.PostingDebit {
+				account.Debits, err = account.Debits.Add(posting.Amount)
+			} else {
+				account.Credits, err = account.Credits.Add(posting.Amount)
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	balance_list := []core.LedgerAccountBalance{}
+	for _, account := range accounts {
+		balance_list = append(balance_list, *account)
+	}
+	sort.Slice(balance_list, func(i, j int) bool {
+		a, b := balance_list[i], balance_list[j]
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		if a.AccountType != b.AccountType {
+			return a.AccountType < b.AccountType
+		}
+		return a.Account < b.Account
+	})
+
+	return &balance_list, nil
+}
@@ -0,0 +1,87 @@
+package db_memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+
+)
+
+func (w WorkerRepository) AddOutboxEventCtx(ctx context.Context, tx repository.Tx, outboxEvent core.OutboxEvent) (*core.OutboxEvent, error){
+	childLogger.Debug().Msg("AddOutboxEventCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	w.next(func(seq *sequences) {
+		seq.outbox++
+		outboxEvent.ID = seq.outbox
+	})
+	outboxEvent.Status = "PENDING"
+	outboxEvent.CreatedAt = time.Now()
+	outboxEvent.NextAttemptAt = outboxEvent.CreatedAt
+	event := outboxEvent
+	mem_tx.apply(func(data *store) {
+		data.outboxEvents[event.ID] = event
+	})
+
+	return &outboxEvent, nil
+}
+
+// ClaimOutboxEventsCtx returns the pending events due for delivery, leased (not due)
+// until leaseUntil
+func (w WorkerRepository) ClaimOutboxEventsCtx(ctx context.Context, tx repository.Tx, limit int, leaseUntil time.Time) (*[]core.OutboxEvent, error){
+	childLogger.Debug().Msg("ClaimOutboxEventsCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	event_list := []core.OutboxEvent{}
+	for _, event := range mem_tx.view.outboxEvents {
+		if event.Status == "PENDING" && !event.NextAttemptAt.After(now) {
+			event_list = append(event_list, event)
+		}
+	}
+	sort.Slice(event_list, func(i, j int) bool { return event_list[i].ID < event_list[j].ID })
+	if len(event_list) > limit {
+		event_list = event_list[:limit]
+	}
+	for i := range event_list {
+		event_list[i].NextAttemptAt = leaseUntil
+		event := event_list[i]
+		mem_tx.apply(func(data *store) {
+			data.outboxEvents[event.ID] = event
+		})
+	}
+
+	return &event_list, nil
+}
+
+// UpdateOutboxEventCtx saves the delivery outcome (status, attempts, next attempt)
+func (w WorkerRepository) UpdateOutboxEventCtx(ctx context.Context, tx repository.Tx, outboxEvent core.OutboxEvent) (error){
+	childLogger.Debug().Msg("UpdateOutboxEventCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return err
+	}
+
+	mem_tx.apply(func(data *store) {
+		event, ok := data.outboxEvents[outboxEvent.ID]
+		if !ok {
+			return
+		}
+		event.Status = outboxEvent.Status
+		event.Attempts = outboxEvent.Attempts
+		event.NextAttemptAt = outboxEvent.NextAttemptAt
+		event.LastError = outboxEvent.LastError
+		data.outboxEvents[event.ID] = event
+	})
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package db_memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+func (w WorkerRepository) ListChargeAccounts(ctx context.Context) (*[]core.BalanceCharge, error){
+	childLogger.Debug().Msg("ListChargeAccounts")
+
+	account_list := []core.BalanceCharge{}
+	w.read(func(data *store) {
+		seen := map[core.BalanceCharge]bool{}
+		for _, charge := range data.charges {
+			if charge.AccountID == "" {
+				continue
+			}
+			account := core.BalanceCharge{ AccountID: charge.AccountID, FkBalanceID: charge.FkBalanceID, Currency: charge.Currency }
+			if !seen[account] {
+				seen[account] = true
+				account_list = append(account_list, account)
+			}
+		}
+	})
+	sort.Slice(account_list, func(i, j int) bool { return account_list[i].AccountID < account_list[j].AccountID })
+
+	return &account_list, nil
+}
+
+func (w WorkerRepository) GetOpenReconciliationCtx(ctx context.Context, tx repository.Tx, fkBalanceID int) (*core.Reconciliation, error){
+	childLogger.Debug().Msg("GetOpenReconciliationCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reconciliation := range mem_tx.view.reconciliations {
+		if reconciliation.FkBalanceID == fkBalanceID && reconciliation.Status == "OPEN" {
+			return &reconciliation, nil
+		}
+	}
+	return nil, erro.ErrNotFound
+}
+
+func (w WorkerRepository) AddReconciliationCtx(ctx context.Context, tx repository.Tx, reconciliation core.Reconciliation) (*core.Reconciliation, error){
+	childLogger.Debug().Msg("AddReconciliationCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	w.next(func(seq *sequences) {
+		seq.reconciliation++
+		reconciliation.ID = seq.reconciliation
+	})
+	reconciliation.CreatedAt = time.Now()
+	reconciliation.UpdatedAt = reconciliation.CreatedAt
+	row := reconciliation
+	mem_tx.apply(func(data *store) {
+		data.reconciliations[row.ID] = row
+	})
+
+	return &reconciliation, nil
+}
+
+func (w WorkerRepository) UpdateReconciliationCtx(ctx context.Context, tx repository.Tx, reconciliation core.Reconciliation) (error){
+	childLogger.Debug().Msg("UpdateReconciliationCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := mem_tx.view.reconciliations[reconciliation.ID]; !ok {
+		return nil
+	}
+	reconciliation.UpdatedAt = time.Now()
+	row := reconciliation
+	mem_tx.apply(func(data *store) {
+		if current, ok := data.reconciliations[row.ID]; ok {
+			row.CreatedAt = current.CreatedAt
+			data.reconciliations[row.ID] = row
+		}
+	})
+
+	return nil
+}
+
+func (w WorkerRepository) ListReconciliations(ctx context.Context, status string) (*[]core.Reconciliation, error){
+	childLogger.Debug().Msg("ListReconciliations")
+
+	reconciliation_list := []core.Reconciliation{}
+	w.read(func(data *store) {
+		for _, reconciliation := range data.reconciliations {
+			if status == "" || reconciliation.Status == status {
+				reconciliation_list = append(reconciliation_list, reconciliation)
+			}
+		}
+	})
+	sort.Slice(reconciliation_list, func(i, j int) bool {
+		if reconciliation_list[i].UpdatedAt.Equal(reconciliation_list[j].UpdatedAt) {
+			return reconciliation_list[i].ID > reconciliation_list[j].ID
+		}
+		return reconciliation_list[i].UpdatedAt.After(reconciliation_list[j].UpdatedAt)
+	})
+
+	return &reconciliation_list, nil
+}
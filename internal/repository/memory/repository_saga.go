@@ -0,0 +1,144 @@
+package db_memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+
+)
+
+func (w WorkerRepository) CreateSaga(ctx context.Context, saga core.Saga) (*core.Saga, error){
+	childLogger.Debug().Msg("CreateSaga")
+
+	w.next(func(seq *sequences) {
+		seq.saga++
+		saga.ID = seq.saga
+	})
+	saga.CreatedAt = time.Now()
+	saga.UpdatedAt = saga.CreatedAt
+	saga.Steps = nil
+	w.write(func(data *store) {
+		data.sagas[saga.ID] = saga
+	})
+
+	return &saga, nil
+}
+
+func (w WorkerRepository) UpdateSaga(ctx context.Context, saga core.Saga) (error){
+	childLogger.Debug().Msg("UpdateSaga")
+
+	w.write(updateSaga(saga, time.Now()))
+	return nil
+}
+
+func (w WorkerRepository) UpdateSagaCtx(ctx context.Context, tx repository.Tx, saga core.Saga) (error){
+	childLogger.Debug().Msg("UpdateSagaCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return err
+	}
+
+	mem_tx.apply(updateSaga(saga, time.Now()))
+	return nil
+}
+
+func updateSaga(saga core.Saga, now time.Time) func(*store) {
+	payload := append([]byte(nil), saga.Payload...)
+	return func(data *store) {
+		stored, ok := data.sagas[saga.ID]
+		if !ok {
+			return
+		}
+		stored.Status = saga.Status
+		stored.Payload = payload
+		stored.Error = saga.Error
+		stored.UpdatedAt = now
+		data.sagas[stored.ID] = stored
+	}
+}
+
+func (w WorkerRepository) SaveSagaStep(ctx context.Context, sagaStep core.SagaStep) (error){
+	childLogger.Debug().Msg("SaveSagaStep")
+
+	w.write(saveSagaStep(sagaStep, time.Now()))
+	return nil
+}
+
+func (w WorkerRepository) SaveSagaStepCtx(ctx context.Context, tx repository.Tx, sagaStep core.SagaStep) (error){
+	childLogger.Debug().Msg("SaveSagaStepCtx")
+	mem_tx, err := memTx(tx)
+	if err != nil {
+		return err
+	}
+
+	mem_tx.apply(saveSagaStep(sagaStep, time.Now()))
+	return nil
+}
+
+// saveSagaStep inserts the step or updates it when the seq is already there
+func saveSagaStep(sagaStep core.SagaStep, now time.Time) func(*store) {
+	sagaStep.UpdatedAt = now
+	return func(data *store) {
+		saga, ok := data.sagas[sagaStep.SagaID]
+		if !ok {
+			return
+		}
+		steps := append([]core.SagaStep(nil), saga.Steps...)
+		found := false
+		for i := range steps {
+			if steps[i].Seq == sagaStep.Seq {
+				steps[i].Status = sagaStep.Status
+				steps[i].Error = sagaStep.Error
+				steps[i].UpdatedAt = sagaStep.UpdatedAt
+				found = true
+			}
+		}
+		if !found {
+			steps = append(steps, sagaStep)
+			sort.Slice(steps, func(i, j int) bool { return steps[i].Seq < steps[j].Seq })
+		}
+		saga.Steps = steps
+		data.sagas[saga.ID] = saga
+	}
+}
+
+// ListIncompleteSagas returns the sagas still RUNNING or COMPENSATING that were not
+// touched since updatedBefore, with their steps in execution order
+func (w WorkerRepository) ListIncompleteSagas(ctx context.Context, updatedBefore time.Time) (*[]core.Saga, error){
+	childLogger.Debug().Msg("ListIncompleteSagas")
+
+	saga_list := []core.Saga{}
+	w.read(func(data *store) {
+		for _, saga := range data.sagas {
+			if (saga.Status == "RUNNING" || saga.Status == "COMPENSATING") && saga.UpdatedAt.Before(updatedBefore) {
+				saga.Steps = append([]core.SagaStep(nil), saga.Steps...)
+				saga_list = append(saga_list, saga)
+			}
+		}
+	})
+	sort.Slice(saga_list, func(i, j int) bool { return saga_list[i].ID < saga_list[j].ID })
+
+	return &saga_list, nil
+}
+
+// ClaimSaga moves the saga to status only if nobody touched it since it was read,
+// so that a single pod resumes it
+func (w WorkerRepository) ClaimSaga(ctx context.Context, saga core.Saga, status string) (claimed bool, err error){
+	childLogger.Debug().Msg("ClaimSaga")
+
+	w.write(func(data *store) {
+		stored, ok := data.sagas[saga.ID]
+		if !ok || stored.Status != saga.Status || !stored.UpdatedAt.Equal(saga.UpdatedAt) {
+			return
+		}
+		stored.Status = status
+		stored.UpdatedAt = time.Now()
+		data.sagas[stored.ID] = stored
+		claimed = true
+	})
+
+	return claimed, nil
+}
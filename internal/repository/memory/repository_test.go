@@ -0,0 +1,202 @@
+package db_memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/repository/repositorytest"
+
+)
+
+func newCharge(units int64) core.BalanceCharge {
+	return core.BalanceCharge{	AccountID:		"ACC-001",
+								FkBalanceID:	1,
+								Type:			"CRED",
+								Currency:		"BRL",
+								Amount:			core.NewMoney(units, "BRL"),
+								TenantID:		"TENANT-001",
+							}
+}
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, NewWorkerRepository(), 1)
+}
+
+func TestCommitReplaysOnSharedData(t *testing.T) {
+	ctx := context.Background()
+	repo := NewWorkerRepository()
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	in_tx, err := repo.AddCtx(ctx, tx, newCharge(100))
+	if err != nil {
+		t.Fatalf("AddCtx: %v", err)
+	}
+
+	// Written outside while the transaction is open, after its copy was taken
+	outside, err := repo.Add(ctx, newCharge(200))
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	_, err = repo.GetForUpdateCtx(ctx, tx, core.BalanceCharge{ ID: outside.ID })
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("GetForUpdateCtx of a charge added after StartTx err = %v, want ErrNotFound", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// The commit replays the writes of the transaction, it does not replace the data by its copy
+	for _, id := range []int{ in_tx.ID, outside.ID } {
+		if _, err := repo.Get(ctx, core.BalanceCharge{ ID: id }); err != nil {
+			t.Errorf("Get(%d) after commit: %v", id, err)
+		}
+	}
+}
+
+func TestRollbackDiscardsWrites(t *testing.T) {
+	ctx := context.Background()
+	repo := NewWorkerRepository()
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	added, err := repo.AddCtx(ctx, tx, newCharge(100))
+	if err != nil {
+		t.Fatalf("AddCtx: %v", err)
+	}
+	err = repo.AddPostingsCtx(ctx, tx, []core.LedgerPosting{
+		{ ChargeID: added.ID, Account: "customer:1", AccountType: core.AccountCustomer, Direction: core.PostingCredit, Amount: core.NewMoney(100, "BRL"), Currency: "BRL" },
+		{ ChargeID: added.ID, Account: "settlement:BRL", AccountType: core.AccountSettlement, Direction: core.PostingDebit, Amount: core.NewMoney(100, "BRL"), Currency: "BRL" },
+	})
+	if err != nil {
+		t.Fatalf("AddPostingsCtx: %v", err)
+	}
+
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	_, err = repo.Get(ctx, core.BalanceCharge{ ID: added.ID })
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("Get after rollback err = %v, want ErrNotFound", err)
+	}
+	accounts, err := repo.SumPostings(ctx, "")
+	if err != nil {
+		t.Fatalf("SumPostings: %v", err)
+	}
+	if len(*accounts) != 0 {
+		t.Errorf("SumPostings after rollback = %v, want none", *accounts)
+	}
+
+	// Like a serial, the id of the rolled back charge is not reused
+	next, err := repo.Add(ctx, newCharge(100))
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if next.ID == added.ID {
+		t.Errorf("Add id = %d, the id of the rolled back charge", next.ID)
+	}
+}
+
+func TestTransactionDone(t *testing.T) {
+	ctx := context.Background()
+	repo := NewWorkerRepository()
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	if err := tx.Commit(); !errors.Is(err, erro.ErrTransactionDone) {
+		t.Errorf("Commit after Rollback err = %v, want ErrTransactionDone", err)
+	}
+	if err := tx.Rollback(); !errors.Is(err, erro.ErrTransactionDone) {
+		t.Errorf("second Rollback err = %v, want ErrTransactionDone", err)
+	}
+	if _, err := repo.AddCtx(ctx, tx, newCharge(100)); !errors.Is(err, erro.ErrTransactionDone) {
+		t.Errorf("AddCtx on a finished transaction err = %v, want ErrTransactionDone", err)
+	}
+	if err := repo.LockBalanceCtx(ctx, tx, 1); !errors.Is(err, erro.ErrTransactionDone) {
+		t.Errorf("LockBalanceCtx on a finished transaction err = %v, want ErrTransactionDone", err)
+	}
+}
+
+func TestStartTxWaitsForTheOpenTransaction(t *testing.T) {
+	ctx := context.Background()
+	repo := NewWorkerRepository()
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+
+	ctx_timeout, cancel := context.WithTimeout(ctx, 50 * time.Millisecond)
+	defer cancel()
+	_, err = repo.StartTx(ctx_timeout)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("StartTx with a transaction open err = %v, want DeadlineExceeded", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	next, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx after Commit: %v", err)
+	}
+	next.Rollback()
+}
+
+func TestListAfter(t *testing.T) {
+	ctx := context.Background()
+	repo := NewWorkerRepository()
+
+	ids := []int{}
+	for _, units := range []int64{ 300, 100, 200, 100 } {
+		added, err := repo.Add(ctx, newCharge(units))
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		ids = append(ids, added.ID)
+	}
+
+	// amount_asc: 1.00 (ids[1]), 1.00 (ids[3]), 2.00, 3.00, the tie broken by id
+	after := core.NewChargeCursor(core.SortAmountAsc, core.BalanceCharge{ ID: ids[1], Currency: "BRL", Amount: core.NewMoney(100, "BRL") })
+	res, err := repo.List(ctx, core.ChargeFilter{ FkBalanceID: 1, Sort: core.SortAmountAsc, Limit: 2, After: &after })
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(*res) != 2 || (*res)[0].ID != ids[3] || (*res)[1].ID != ids[2] {
+		t.Errorf("List after ids[1] = %v, want ids %d, %d", *res, ids[3], ids[2])
+	}
+
+	// charged_at_desc: the last page is shorter than the limit
+	last, err := repo.Get(ctx, core.BalanceCharge{ ID: ids[1] })
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	after = core.NewChargeCursor(core.SortChargedAtDesc, *last)
+	res, err = repo.List(ctx, core.ChargeFilter{ FkBalanceID: 1, Sort: core.SortChargedAtDesc, Limit: 2, After: &after })
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(*res) != 1 || (*res)[0].ID != ids[0] {
+		t.Errorf("List after ids[1] = %v, want id %d", *res, ids[0])
+	}
+}
@@ -0,0 +1,300 @@
+package db_postgre
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"database/sql"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Namespace of the advisory lock taken while migrating (see lockBalance)
+const lockMigration = 2
+
+// Migration is a pair of files migrations/NNNN_name.up.sql and NNNN_name.down.sql
+type Migration struct {
+	Version		int
+	Name		string
+	Up			string
+	Down		string
+}
+
+type MigrationStatus struct {
+	Version		int			`json:"version"`
+	Name		string		`json:"name"`
+	Applied		bool		`json:"applied"`
+	AppliedAt	*time.Time	`json:"applied_at,omitempty"`
+}
+
+// Migrator applies the migrations embedded in the binary, the applied versions are kept
+// in schema_migrations. A session advisory lock keeps the pods from migrating together
+type Migrator struct {
+	client		*sql.DB
+	migrations	[]Migration
+}
+
+func NewMigrator(databaseHelper DatabaseHelper) (*Migrator, error) {
+	childLogger.Debug().Msg("NewMigrator")
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		client:		databaseHelper.GetConnection(),
+		migrations:	migrations,
+	}, nil
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		file := entry.Name()
+		direction := ""
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		name := strings.TrimSuffix(file, "." + direction + ".sql")
+		parts := strings.SplitN(name, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name %s", file)
+		}
+
+		content, err := migrationFiles.ReadFile("migrations/" + file)
+		if err != nil {
+			return nil, errors.New(err.Error())
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{ Version: version, Name: parts[1] }
+			byVersion[version] = migration
+		}
+		if migration.Name != parts[1] {
+			return nil, fmt.Errorf("migration %d has two names (%s, %s)", version, migration.Name, parts[1])
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Latest is the version the binary expects
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies the pending migrations, each one in its own transaction
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	childLogger.Debug().Msg("Up")
+
+	count := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			childLogger.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Applying migration")
+			err := m.apply(ctx, conn, migration.Up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+							migration.Version, migration.Name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %s", migration.Version, migration.Name, err.Error())
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Down reverts the last steps applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	childLogger.Debug().Msg("Down")
+
+	count := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+			}
+			childLogger.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Reverting migration")
+			err := m.apply(ctx, conn, migration.Down, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %s", migration.Version, migration.Name, err.Error())
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	childLogger.Debug().Msg("Status")
+
+	applied, err := m.applied(ctx, m.client)
+	if err != nil {
+		return nil, err
+	}
+
+	status_list := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		status := MigrationStatus{ Version: migration.Version, Name: migration.Name }
+		if applied_at, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &applied_at
+		}
+		status_list = append(status_list, status)
+	}
+
+	return status_list, nil
+}
+
+// Check fails with ErrSchemaVersion when a migration of the binary is not applied, the
+// service must not serve on an older schema. A newer schema (rolling deploy) is fine
+func (m *Migrator) Check(ctx context.Context) error {
+	childLogger.Debug().Msg("Check")
+
+	applied, err := m.applied(ctx, m.client)
+	if err != nil {
+		return err
+	}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			childLogger.Error().Int("version", migration.Version).Str("name", migration.Name).Msg("Migration not applied")
+			return erro.ErrSchemaVersion
+		}
+	}
+	return nil
+}
+
+// locked runs fn holding the migration lock on a dedicated connection
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, applied map[int]time.Time) error) error {
+	conn, err := m.client.Conn(ctx)
+	if err != nil {
+		return errors.New(err.Error())
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1, 0)`, lockMigration)
+	if err != nil {
+		return errors.New(err.Error())
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, 0)`, lockMigration)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+										version		integer PRIMARY KEY,
+										name		varchar(200) NOT NULL,
+										applied_at	timestamptz NOT NULL)`)
+	if err != nil {
+		return errors.New(err.Error())
+	}
+
+	// Read after the lock, another pod may have migrated while waiting
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// applied returns the applied versions, none when schema_migrations does not exist yet
+func (m *Migrator) applied(ctx context.Context, client querier) (map[int]time.Time, error) {
+	applied := map[int]time.Time{}
+
+	var exists bool
+	err := client.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') is not null`).Scan(&exists)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := client.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var applied_at time.Time
+		err := rows.Scan(&version, &applied_at)
+		if err != nil {
+			return nil, errors.New(err.Error())
+		}
+		applied[version] = applied_at
+	}
+
+	return applied, nil
+}
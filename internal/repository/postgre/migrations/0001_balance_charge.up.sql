@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS balance_charge (
+    id              SERIAL PRIMARY KEY,
+    fk_balance_id   integer REFERENCES balance(id),
+    type_charge     varchar(200) NULL,
+    charged_at      timestamptz NULL,
+    currency        varchar(10) NULL,
+    amount          float8 NULL,
+    tenant_id       varchar(200) NULL
+);
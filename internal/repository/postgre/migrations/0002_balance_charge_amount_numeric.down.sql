@@ -0,0 +1 @@
+ALTER TABLE balance_charge ALTER COLUMN amount TYPE float8 USING amount::float8;
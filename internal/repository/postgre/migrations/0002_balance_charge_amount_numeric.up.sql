@@ -0,0 +1 @@
+ALTER TABLE balance_charge ALTER COLUMN amount TYPE numeric USING amount::numeric;
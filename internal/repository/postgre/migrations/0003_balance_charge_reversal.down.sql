@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS balance_charge_reversal_of_idx;
+ALTER TABLE balance_charge DROP COLUMN IF EXISTS reversal_of;
+ALTER TABLE balance_charge DROP COLUMN IF EXISTS account_id;
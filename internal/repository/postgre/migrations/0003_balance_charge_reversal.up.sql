@@ -0,0 +1,3 @@
+ALTER TABLE balance_charge ADD COLUMN IF NOT EXISTS account_id varchar(200) NULL;
+ALTER TABLE balance_charge ADD COLUMN IF NOT EXISTS reversal_of integer NULL REFERENCES balance_charge(id);
+CREATE INDEX IF NOT EXISTS balance_charge_reversal_of_idx ON balance_charge (reversal_of);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    idempotency_key varchar(255) NOT NULL,
+    operation       varchar(50) NOT NULL,
+    request_hash    varchar(64) NOT NULL,
+    status          varchar(20) NOT NULL,
+    response_status integer NULL,
+    response_body   bytea NULL,
+    created_at      timestamptz NOT NULL,
+    updated_at      timestamptz NOT NULL,
+    PRIMARY KEY (idempotency_key, operation)
+);
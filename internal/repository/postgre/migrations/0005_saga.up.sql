@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS saga (
+    id              SERIAL PRIMARY KEY,
+    saga_type       varchar(50) NOT NULL,
+    status          varchar(20) NOT NULL,
+    payload         jsonb NOT NULL,
+    error           text NULL,
+    created_at      timestamptz NOT NULL,
+    updated_at      timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS saga_status_idx ON saga (status, updated_at);
+
+CREATE TABLE IF NOT EXISTS saga_step (
+    saga_id         integer REFERENCES saga(id),
+    step_seq        integer NOT NULL,
+    step_name       varchar(50) NOT NULL,
+    status          varchar(20) NOT NULL,
+    error           text NULL,
+    updated_at      timestamptz NOT NULL,
+    PRIMARY KEY (saga_id, step_seq)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS outbox_event (
+    id              BIGSERIAL PRIMARY KEY,
+    aggregate_id    varchar(200) NOT NULL,
+    event_type      varchar(50) NOT NULL,
+    payload         jsonb NOT NULL,
+    status          varchar(20) NOT NULL,
+    attempts        integer NOT NULL,
+    next_attempt_at timestamptz NOT NULL,
+    last_error      text NULL,
+    created_at      timestamptz NOT NULL,
+    sent_at         timestamptz NULL
+);
+
+CREATE INDEX IF NOT EXISTS outbox_event_pending_idx ON outbox_event (next_attempt_at) WHERE status = 'PENDING';
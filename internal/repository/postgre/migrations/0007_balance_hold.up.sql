@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS balance_hold (
+    id              SERIAL PRIMARY KEY,
+    fk_balance_id   integer REFERENCES balance(id),
+    account_id      varchar(200) NOT NULL,
+    currency        varchar(10) NOT NULL,
+    amount          numeric NOT NULL,
+    captured_amount numeric NOT NULL,
+    status          varchar(20) NOT NULL,
+    expires_at      timestamptz NOT NULL,
+    charge_id       integer NULL REFERENCES balance_charge(id),
+    tenant_id       varchar(200) NULL,
+    created_at      timestamptz NOT NULL,
+    updated_at      timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS balance_hold_active_idx ON balance_hold (fk_balance_id, expires_at) WHERE status = 'ACTIVE';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS balance_charge_list_amount_idx;
+DROP INDEX IF EXISTS balance_charge_list_idx;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS balance_charge_list_idx ON balance_charge (fk_balance_id, charged_at, id);
+CREATE INDEX IF NOT EXISTS balance_charge_list_amount_idx ON balance_charge (fk_balance_id, amount, id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS reconciliation (
+    id                      SERIAL PRIMARY KEY,
+    account_id              varchar(200) NOT NULL,
+    fk_balance_id           integer NOT NULL,
+    currency                varchar(10) NOT NULL,
+    ledger_amount           numeric NOT NULL,
+    remote_amount           numeric NOT NULL,
+    difference              numeric NOT NULL,
+    status                  varchar(20) NOT NULL,
+    checks                  integer NOT NULL,
+    adjustment_charge_id    integer NULL REFERENCES balance_charge(id),
+    created_at              timestamptz NOT NULL,
+    updated_at              timestamptz NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reconciliation_open_idx ON reconciliation (fk_balance_id) WHERE status = 'OPEN';
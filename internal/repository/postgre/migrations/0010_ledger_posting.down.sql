@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ledger_posting;
+DROP FUNCTION IF EXISTS ledger_posting_balanced();
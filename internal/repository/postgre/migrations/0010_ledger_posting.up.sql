@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS ledger_posting (
+    id              BIGSERIAL PRIMARY KEY,
+    charge_id       integer NOT NULL REFERENCES balance_charge(id),
+    account         varchar(200) NOT NULL,
+    account_type    varchar(20) NOT NULL,
+    direction       varchar(6) NOT NULL CHECK (direction IN ('DEBIT', 'CREDIT')),
+    amount          numeric NOT NULL CHECK (amount > 0),
+    currency        varchar(10) NOT NULL,
+    created_at      timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ledger_posting_charge_idx ON ledger_posting (charge_id);
+CREATE INDEX IF NOT EXISTS ledger_posting_account_idx ON ledger_posting (account, currency);
+
+-- The postings of a charge must balance (debits = credits per currency), checked at commit
+CREATE OR REPLACE FUNCTION ledger_posting_balanced() RETURNS trigger AS $$
+DECLARE
+    checked_charge integer;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        checked_charge := OLD.charge_id;
+    ELSE
+        checked_charge := NEW.charge_id;
+    END IF;
+    IF EXISTS (SELECT 1 FROM ledger_posting
+                WHERE charge_id = checked_charge
+                GROUP BY currency
+                HAVING sum(CASE WHEN direction = 'DEBIT' THEN amount ELSE -amount END) <> 0) THEN
+        RAISE EXCEPTION 'unbalanced ledger postings for charge %', checked_charge;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_posting_balanced ON ledger_posting;
+CREATE CONSTRAINT TRIGGER ledger_posting_balanced
+    AFTER INSERT OR UPDATE OR DELETE ON ledger_posting
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE ledger_posting_balanced();
+
+-- Postings of the charges made before the ledger, same rules as the service
+INSERT INTO ledger_posting (charge_id, account, account_type, direction, amount, currency, created_at)
+SELECT id,
+       'customer:' || fk_balance_id,
+       'CUSTOMER',
+       CASE WHEN amount > 0 THEN 'CREDIT' ELSE 'DEBIT' END,
+       abs(amount),
+       currency,
+       coalesce(charged_at, now())
+FROM balance_charge
+WHERE amount <> 0 AND NOT EXISTS (SELECT 1 FROM ledger_posting p WHERE p.charge_id = balance_charge.id)
+UNION ALL
+SELECT id,
+       CASE type_charge WHEN 'FEE' THEN 'fees' WHEN 'ADJUSTMENT' THEN 'suspense' ELSE 'settlement' END || ':' || currency,
+       CASE type_charge WHEN 'FEE' THEN 'FEES' WHEN 'ADJUSTMENT' THEN 'SUSPENSE' ELSE 'SETTLEMENT' END,
+       CASE WHEN amount > 0 THEN 'DEBIT' ELSE 'CREDIT' END,
+       abs(amount),
+       currency,
+       coalesce(charged_at, now())
+FROM balance_charge
+WHERE amount <> 0 AND NOT EXISTS (SELECT 1 FROM ledger_posting p WHERE p.charge_id = balance_charge.id);
@@ -0,0 +1,6 @@
+ALTER TABLE balance_charge DROP CONSTRAINT IF EXISTS balance_charge_fx_chk;
+ALTER TABLE balance_charge DROP COLUMN IF EXISTS fx_rate_at;
+ALTER TABLE balance_charge DROP COLUMN IF EXISTS fx_provider;
+ALTER TABLE balance_charge DROP COLUMN IF EXISTS fx_rate;
+ALTER TABLE balance_charge DROP COLUMN IF EXISTS original_currency;
+ALTER TABLE balance_charge DROP COLUMN IF EXISTS original_amount;
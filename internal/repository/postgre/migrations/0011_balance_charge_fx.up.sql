@@ -0,0 +1,9 @@
+-- Snapshot of the rate of the charges converted into the currency of the balance
+ALTER TABLE balance_charge ADD COLUMN IF NOT EXISTS original_amount numeric NULL;
+ALTER TABLE balance_charge ADD COLUMN IF NOT EXISTS original_currency varchar(10) NULL;
+ALTER TABLE balance_charge ADD COLUMN IF NOT EXISTS fx_rate numeric NULL;
+ALTER TABLE balance_charge ADD COLUMN IF NOT EXISTS fx_provider varchar(50) NULL;
+ALTER TABLE balance_charge ADD COLUMN IF NOT EXISTS fx_rate_at timestamptz NULL;
+ALTER TABLE balance_charge DROP CONSTRAINT IF EXISTS balance_charge_fx_chk;
+ALTER TABLE balance_charge ADD CONSTRAINT balance_charge_fx_chk
+    CHECK ((fx_rate IS NULL) = (original_amount IS NULL) AND (fx_rate IS NULL) = (original_currency IS NULL) AND (fx_rate IS NULL OR fx_rate > 0));
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idempotency_key_updated_at_idx;
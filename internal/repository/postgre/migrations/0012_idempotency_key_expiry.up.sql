@@ -0,0 +1,2 @@
+-- The expired keys are deleted by updated_at
+CREATE INDEX IF NOT EXISTS idempotency_key_updated_at_idx ON idempotency_key (updated_at);
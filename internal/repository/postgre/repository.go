@@ -4,14 +4,17 @@ import (
 	"context"
 	"time"
 	"errors"
+	"fmt"
+	"strings"
 	"database/sql"
 
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 
 	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
 	"github.com/go-rest-balance-charges/internal/erro"
-	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-rest-balance-charges/internal/tracing"
 
 )
 
@@ -28,7 +31,7 @@ func NewWorkerRepository(databaseHelper DatabaseHelper) WorkerRepository {
 	}
 }
 
-func (w WorkerRepository) StartTx(ctx context.Context) (*sql.Tx, error) {
+func (w WorkerRepository) StartTx(ctx context.Context) (repository.Tx, error) {
 	childLogger.Debug().Msg("StartTx")
 
 	client := w.databaseHelper.GetConnection()
@@ -41,13 +44,13 @@ func (w WorkerRepository) StartTx(ctx context.Context) (*sql.Tx, error) {
 	return tx, nil
 }
 
-func (w WorkerRepository) Ping() (bool, error) {
+func (w WorkerRepository) Ping(ctx context.Context) (bool, error) {
 	childLogger.Debug().Msg("++++++++++++++++++++++++++++++++")
 	childLogger.Debug().Msg("Ping")
 	childLogger.Debug().Msg("++++++++++++++++++++++++++++++++")
 
 	client := w.databaseHelper.GetConnection()
-	err := client.Ping()
+	err := client.PingContext(ctx)
 	if err != nil {
 		return false, erro.ErrConnectionDatabase
 	}
@@ -58,9 +61,9 @@ func (w WorkerRepository) Ping() (bool, error) {
 func (w WorkerRepository) Add(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
 	childLogger.Debug().Msg("Add")
 
-	_, root := xray.BeginSubsegment(ctx, "SQL.ADD-Balance-Charges")
+	_, root := tracing.Start(ctx, "SQL.ADD-Balance-Charges")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
 
 	client := w.databaseHelper.GetConnection()
@@ -70,19 +73,35 @@ func (w WorkerRepository) Add(ctx context.Context, balanceCharge core.BalanceCha
 																charged_at, 
 																currency,
 																amount,
-																tenant_id) 
-									VALUES($1, $2, $3, $4, $5, $6) `)
+																tenant_id,
+																account_id,
+																reversal_of,
+																original_amount,
+																original_currency,
+																fx_rate,
+																fx_provider,
+																fx_rate_at) 
+									VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id`)
 	if err != nil {
 		childLogger.Error().Err(err).Msg("INSERT statement")
 		return nil, errors.New(err.Error())
 	}
-	_, err = stmt.ExecContext( ctx,
+	balanceCharge.ChargeAt = time.Now()
+	fx_original_amount, fx_original_currency, fx_rate, fx_provider, fx_rate_at := fxColumns(balanceCharge)
+	err = stmt.QueryRowContext(ctx,
 								balanceCharge.FkBalanceID, 
 								balanceCharge.Type,
-								time.Now(),
+								balanceCharge.ChargeAt,
 								balanceCharge.Currency,
-								balanceCharge.Amount,
-								balanceCharge.TenantID)
+								balanceCharge.Amount.String(),
+								balanceCharge.TenantID,
+								balanceCharge.AccountID,
+								nullInt(balanceCharge.ReversalOf),
+								fx_original_amount,
+								fx_original_currency,
+								fx_rate,
+								fx_provider,
+								fx_rate_at).Scan(&balanceCharge.ID)
 	if err != nil {
 		childLogger.Error().Err(err).Msg("Exec statement")
 		return nil, errors.New(err.Error())
@@ -94,104 +113,172 @@ func (w WorkerRepository) Add(ctx context.Context, balanceCharge core.BalanceCha
 func (w WorkerRepository) Get(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
 	childLogger.Debug().Msg("Get")
 
-	_, root := xray.BeginSubsegment(ctx, "SQL.GET-Balance-Charges")
+	_, root := tracing.Start(ctx, "SQL.GET-Balance-Charges")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
 
 	client := w.databaseHelper.GetConnection()
 
-	result_query := core.BalanceCharge{}
-	rows, err := client.QueryContext(ctx, `SELECT id, fk_balance_id, type_charge, charged_at, currency, amount, tenant_id FROM balance_charge WHERE id =$1`, balanceCharge.ID)
+	// Filtered by tenant when it is set, the charge of another tenant is not found
+	rows, err := client.QueryContext(ctx, selectCharge + ` WHERE id =$1 AND ($2 = '' OR tenant_id = $2)`, balanceCharge.ID, balanceCharge.TenantID)
 	if err != nil {
 		childLogger.Error().Err(err).Msg("Query statement")
 		return nil, errors.New(err.Error())
 	}
 
 	for rows.Next() {
-		err := rows.Scan( 	&result_query.ID, 
-							&result_query.FkBalanceID, 
-							&result_query.Type, 
-							&result_query.ChargeAt,
-							&result_query.Currency,
-							&result_query.Amount,
-							&result_query.TenantID,
-						)
+		result_query, err := scanCharge(rows)
 		if err != nil {
-			childLogger.Error().Err(err).Msg("Scan statement")
-			return nil, errors.New(err.Error())
-        }
-		return &result_query, nil
+			return nil, err
+		}
+		return result_query, nil
 	}
 	defer rows.Close()
 	return nil, erro.ErrNotFound
 }
 
-func (w WorkerRepository) List(ctx context.Context, balanceCharge core.BalanceCharge) (*[]core.BalanceCharge, error){
+// List returns a page of the charges of the balance, the keyset condition on the sort key
+// and id of the cursor keeps the cost of a page independent of how deep it is
+func (w WorkerRepository) List(ctx context.Context, filter core.ChargeFilter) (*[]core.BalanceCharge, error){
 	childLogger.Debug().Msg("List")
 	
-	_, root := xray.BeginSubsegment(ctx, "SQL.List-Balance-Charges")
+	_, root := tracing.Start(ctx, "SQL.List-Balance-Charges")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
 
 	client := w.databaseHelper.GetConnection()
 
-	result_query := core.BalanceCharge{}
+	query, args := listQuery(filter)
+
 	balance_list := []core.BalanceCharge{}
 
-	rows, err := client.QueryContext(ctx, `SELECT id, fk_balance_id, type_charge, charged_at, currency, amount, tenant_id FROM balance_charge WHERE fk_balance_id =$1 order by charged_at desc`, balanceCharge.FkBalanceID)
+	rows, err := client.QueryContext(ctx, query, args...)
 	if err != nil {
 		childLogger.Error().Err(err).Msg("SELECT statement")
 		return nil, errors.New(err.Error())
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		err := rows.Scan( 	&result_query.ID, 
-							&result_query.FkBalanceID, 
-							&result_query.Type, 
-							&result_query.ChargeAt,
-							&result_query.Currency,
-							&result_query.Amount,
-							&result_query.TenantID,
-						)
+		result_query, err := scanCharge(rows)
 		if err != nil {
-			childLogger.Error().Err(err).Msg("Scan statement")
-			return nil, errors.New(err.Error())
-        }
-		balance_list = append(balance_list, result_query)
+			return nil, err
+		}
+		balance_list = append(balance_list, *result_query)
 	}
-	defer rows.Close()
 	return &balance_list , nil
 }
 
-func (w WorkerRepository) AddCtx(ctx context.Context, tx *sql.Tx, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
+func listQuery(filter core.ChargeFilter) (string, []interface{}) {
+	args := []interface{}{ filter.FkBalanceID }
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := []string{ "fk_balance_id = $1" }
+	if filter.TenantID != "" {
+		where = append(where, "tenant_id = " + arg(filter.TenantID))
+	}
+	if filter.Type != "" {
+		where = append(where, "type_charge = " + arg(filter.Type))
+	}
+	if filter.Currency != "" {
+		where = append(where, "currency = " + arg(filter.Currency))
+	}
+	if filter.From != nil {
+		where = append(where, "charged_at >= " + arg(*filter.From))
+	}
+	if filter.To != nil {
+		where = append(where, "charged_at < " + arg(*filter.To))
+	}
+	if filter.MinAmount != nil {
+		where = append(where, "amount >= " + arg(filter.MinAmount.String()) + "::numeric")
+	}
+	if filter.MaxAmount != nil {
+		where = append(where, "amount <= " + arg(filter.MaxAmount.String()) + "::numeric")
+	}
+
+	column, direction, operator := "charged_at", "desc", "<"
+	switch filter.Sort {
+	case core.SortChargedAtAsc:
+		direction, operator = "asc", ">"
+	case core.SortAmountDesc:
+		column = "amount"
+	case core.SortAmountAsc:
+		column, direction, operator = "amount", "asc", ">"
+	}
+
+	if filter.After != nil {
+		var key string
+		if column == "amount" {
+			money, _ := filter.After.Money()
+			key = arg(money.String()) + "::numeric"
+		} else {
+			key = arg(filter.After.ChargeAt)
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", column, operator, key, arg(filter.After.ID)))
+	}
+
+	query := selectCharge + ` WHERE ` +
+				strings.Join(where, " AND ") +
+				fmt.Sprintf(" order by %s %s, id %s", column, direction, direction)
+	if filter.Limit > 0 {
+		query = query + " limit " + arg(filter.Limit)
+	}
+
+	return query, args
+}
+
+func (w WorkerRepository) AddCtx(ctx context.Context, tx repository.Tx, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
 	childLogger.Debug().Msg("AddCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
 
-	_, root := xray.BeginSubsegment(ctx, "AddCtx.List-Balance-Charges")
+	_, root := tracing.Start(ctx, "AddCtx.List-Balance-Charges")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
 
-	stmt, err := tx.Prepare(`INSERT INTO balance_charge ( 	fk_balance_id, 
+	stmt, err := sql_tx.Prepare(`INSERT INTO balance_charge ( 	fk_balance_id, 
 																type_charge,
 																charged_at, 
 																currency,
 																amount,
-																tenant_id) 
-									VALUES($1, $2, $3, $4, $5, $6) `)
+																tenant_id,
+																account_id,
+																reversal_of,
+																original_amount,
+																original_currency,
+																fx_rate,
+																fx_provider,
+																fx_rate_at) 
+									VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id`)
 	if err != nil {
 		childLogger.Error().Err(err).Msg("INSERT statement")
 		return nil, errors.New(err.Error())
 	}
 
-	_, err = stmt.ExecContext(	ctx,
+	balanceCharge.ChargeAt = time.Now()
+	fx_original_amount, fx_original_currency, fx_rate, fx_provider, fx_rate_at := fxColumns(balanceCharge)
+	err = stmt.QueryRowContext(	ctx,
 								balanceCharge.FkBalanceID, 
 								balanceCharge.Type,
-								time.Now(),
+								balanceCharge.ChargeAt,
 								balanceCharge.Currency,
-								balanceCharge.Amount,
-								balanceCharge.TenantID)
+								balanceCharge.Amount.String(),
+								balanceCharge.TenantID,
+								balanceCharge.AccountID,
+								nullInt(balanceCharge.ReversalOf),
+								fx_original_amount,
+								fx_original_currency,
+								fx_rate,
+								fx_provider,
+								fx_rate_at).Scan(&balanceCharge.ID)
 	if err != nil {
 		childLogger.Error().Err(err).Msg("Exec statement")
 		return nil, errors.New(err.Error())
@@ -200,3 +287,169 @@ func (w WorkerRepository) AddCtx(ctx context.Context, tx *sql.Tx, balanceCharge
 	defer stmt.Close()
 	return &balanceCharge , nil
 }
+
+// GetForUpdateCtx reads the charge locking the row until the end of the transaction
+func (w WorkerRepository) GetForUpdateCtx(ctx context.Context, tx repository.Tx, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
+	childLogger.Debug().Msg("GetForUpdateCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.GET-FOR-UPDATE-Balance-Charges")
+	defer func() {
+		root.End(nil)
+	}()
+
+	result_query, err := scanCharge(sql_tx.QueryRowContext(ctx, selectCharge + ` WHERE id =$1 FOR UPDATE`, balanceCharge.ID))
+	if err == sql.ErrNoRows {
+		return nil, erro.ErrNotFound
+	}
+	return result_query, err
+}
+
+// SumReversalsCtx returns the total already reversed of the charge (opposite sign of the charge),
+// its VOID included, the reversals voided by a compensated saga excluded
+func (w WorkerRepository) SumReversalsCtx(ctx context.Context, tx repository.Tx, balanceCharge core.BalanceCharge) (*core.Money, error){
+	childLogger.Debug().Msg("SumReversalsCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.SUM-Reversals-Balance-Charges")
+	defer func() {
+		root.End(nil)
+	}()
+
+	var amount string
+	err = sql_tx.QueryRowContext(ctx, `SELECT coalesce(sum(c.amount), 0)::text
+										FROM balance_charge c
+										WHERE c.reversal_of =$1
+										AND NOT EXISTS (SELECT 1 FROM balance_charge v WHERE v.reversal_of = c.id and v.type_charge = 'VOID')`, balanceCharge.ID).Scan(&amount)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Query statement")
+		return nil, errors.New(err.Error())
+	}
+
+	res, err := core.ParseMoney(amount, balanceCharge.Currency)
+	if err != nil {
+		childLogger.Error().Err(err).Str("amount", amount).Msg("Parse amount")
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// SumChargesSince returns the total of the charges of the balance (in its currency) made at or after since,
+// only the charges of the tenant when it is set
+func (w WorkerRepository) SumChargesSince(ctx context.Context, balanceCharge core.BalanceCharge, since time.Time) (*core.Money, error){
+	childLogger.Debug().Msg("SumChargesSince")
+
+	_, root := tracing.Start(ctx, "SQL.SUM-Since-Balance-Charges")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	var amount string
+	err := client.QueryRowContext(ctx, `SELECT coalesce(sum(amount), 0)::text
+										FROM balance_charge
+										WHERE fk_balance_id =$1 and currency =$2 and charged_at >= $3 AND ($4 = '' OR tenant_id = $4)`,
+										balanceCharge.FkBalanceID,
+										balanceCharge.Currency,
+										since,
+										balanceCharge.TenantID).Scan(&amount)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Query statement")
+		return nil, errors.New(err.Error())
+	}
+
+	res, err := core.ParseMoney(amount, balanceCharge.Currency)
+	if err != nil {
+		childLogger.Error().Err(err).Str("amount", amount).Msg("Parse amount")
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// sqlTx returns the *sql.Tx behind a transaction opened by StartTx
+func sqlTx(tx repository.Tx) (*sql.Tx, error) {
+	sql_tx, ok := tx.(*sql.Tx)
+	if !ok {
+		return nil, erro.ErrTransaction
+	}
+	return sql_tx, nil
+}
+
+func nullInt(value int) sql.NullInt64 {
+	return sql.NullInt64{ Int64: int64(value), Valid: value != 0 }
+}
+
+const selectCharge = `SELECT id, fk_balance_id, coalesce(account_id, ''), type_charge, charged_at, currency, amount, tenant_id, coalesce(reversal_of, 0),
+								original_amount::text, original_currency, fx_rate::text, fx_provider, fx_rate_at
+							FROM balance_charge`
+
+func scanCharge(row rowScanner) (*core.BalanceCharge, error){
+	result_query := core.BalanceCharge{}
+	var amount string
+	var original_amount, original_currency, fx_rate, fx_provider sql.NullString
+	var fx_rate_at sql.NullTime
+	err := row.Scan(&result_query.ID, 
+					&result_query.FkBalanceID, 
+					&result_query.AccountID, 
+					&result_query.Type, 
+					&result_query.ChargeAt,
+					&result_query.Currency,
+					&amount,
+					&result_query.TenantID,
+					&result_query.ReversalOf,
+					&original_amount,
+					&original_currency,
+					&fx_rate,
+					&fx_provider,
+					&fx_rate_at)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Scan statement")
+		return nil, errors.New(err.Error())
+	}
+	result_query.Amount, err = core.ParseMoney(amount, result_query.Currency)
+	if err != nil {
+		childLogger.Error().Err(err).Str("amount", amount).Msg("Parse amount")
+		return nil, err
+	}
+
+	if fx_rate.Valid {
+		original, err := core.ParseMoney(original_amount.String, original_currency.String)
+		if err != nil {
+			childLogger.Error().Err(err).Str("original_amount", original_amount.String).Msg("Parse amount")
+			return nil, err
+		}
+		result_query.OriginalAmount = &original
+		result_query.FxRate = &core.FxRate{	Base:		original_currency.String,
+											Quote:		result_query.Currency,
+											Rate:		fx_rate.String,
+											Provider:	fx_provider.String,
+											AsOf:		fx_rate_at.Time,
+										}
+	}
+
+	return &result_query, nil
+}
+
+// fxColumns returns the rate snapshot of a converted charge, all null otherwise
+func fxColumns(balanceCharge core.BalanceCharge) (sql.NullString, sql.NullString, sql.NullString, sql.NullString, sql.NullTime) {
+	if balanceCharge.FxRate == nil || balanceCharge.OriginalAmount == nil {
+		return sql.NullString{}, sql.NullString{}, sql.NullString{}, sql.NullString{}, sql.NullTime{}
+	}
+	return	sql.NullString{ String: balanceCharge.OriginalAmount.String(), Valid: true },
+			sql.NullString{ String: balanceCharge.FxRate.Base, Valid: true },
+			sql.NullString{ String: balanceCharge.FxRate.Rate, Valid: true },
+			sql.NullString{ String: balanceCharge.FxRate.Provider, Valid: true },
+			sql.NullTime{ Time: balanceCharge.FxRate.AsOf, Valid: true }
+}
@@ -0,0 +1,250 @@
+package db_postgre
+
+import (
+	"context"
+	"time"
+	"errors"
+	"database/sql"
+
+	_ "github.com/lib/pq"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+// Namespace of the advisory locks taken per balance
+const lockBalance = 1
+
+// LockBalanceCtx serializes the transactions on the balance until commit/rollback
+func (w WorkerRepository) LockBalanceCtx(ctx context.Context, tx repository.Tx, fkBalanceID int) (error){
+	childLogger.Debug().Msg("LockBalanceCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.LOCK-Balance")
+	defer func() {
+		root.End(nil)
+	}()
+
+	_, err = sql_tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, lockBalance, fkBalanceID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("LOCK statement")
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
+
+func (w WorkerRepository) SumActiveHolds(ctx context.Context, balanceHold core.BalanceHold) (*core.Money, error){
+	childLogger.Debug().Msg("SumActiveHolds")
+
+	return sumActiveHolds(ctx, w.databaseHelper.GetConnection(), balanceHold)
+}
+
+func (w WorkerRepository) SumActiveHoldsCtx(ctx context.Context, tx repository.Tx, balanceHold core.BalanceHold) (*core.Money, error){
+	childLogger.Debug().Msg("SumActiveHoldsCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return sumActiveHolds(ctx, sql_tx, balanceHold)
+}
+
+// sumActiveHolds returns the amount reserved by the ACTIVE and not expired holds of the balance
+func sumActiveHolds(ctx context.Context, client execer, balanceHold core.BalanceHold) (*core.Money, error){
+	_, root := tracing.Start(ctx, "SQL.SUM-Active-Holds")
+	defer func() {
+		root.End(nil)
+	}()
+
+	var amount string
+	err := client.QueryRowContext(ctx, `SELECT coalesce(sum(amount), 0)::text
+										FROM balance_hold
+										WHERE fk_balance_id =$1 and status = 'ACTIVE' and expires_at > $2`,
+										balanceHold.FkBalanceID,
+										time.Now()).Scan(&amount)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Query statement")
+		return nil, errors.New(err.Error())
+	}
+
+	res, err := core.ParseMoney(amount, balanceHold.Currency)
+	if err != nil {
+		childLogger.Error().Err(err).Str("amount", amount).Msg("Parse amount")
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (w WorkerRepository) AddHoldCtx(ctx context.Context, tx repository.Tx, balanceHold core.BalanceHold) (*core.BalanceHold, error){
+	childLogger.Debug().Msg("AddHoldCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.ADD-Balance-Hold")
+	defer func() {
+		root.End(nil)
+	}()
+
+	balanceHold.CreatedAt = time.Now()
+	balanceHold.UpdatedAt = balanceHold.CreatedAt
+	err = sql_tx.QueryRowContext(ctx, `INSERT INTO balance_hold (	fk_balance_id,
+																account_id,
+																currency,
+																amount,
+																captured_amount,
+																status,
+																expires_at,
+																tenant_id,
+																created_at,
+																updated_at)
+									VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $9) RETURNING id`,
+									balanceHold.FkBalanceID,
+									balanceHold.AccountID,
+									balanceHold.Currency,
+									balanceHold.Amount.String(),
+									balanceHold.CapturedAmount.String(),
+									balanceHold.Status,
+									balanceHold.ExpiresAt,
+									balanceHold.TenantID,
+									balanceHold.CreatedAt).Scan(&balanceHold.ID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("INSERT statement")
+		return nil, errors.New(err.Error())
+	}
+
+	return &balanceHold, nil
+}
+
+const selectHold = `SELECT id, fk_balance_id, account_id, currency, amount, captured_amount, status, expires_at, coalesce(charge_id, 0), tenant_id, created_at, updated_at
+					FROM balance_hold
+					WHERE id =$1 AND ($2 = '' OR tenant_id = $2)`
+
+func (w WorkerRepository) GetHold(ctx context.Context, balanceHold core.BalanceHold) (*core.BalanceHold, error){
+	childLogger.Debug().Msg("GetHold")
+
+	_, root := tracing.Start(ctx, "SQL.GET-Balance-Hold")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	return scanHold(client.QueryRowContext(ctx, selectHold, balanceHold.ID, balanceHold.TenantID))
+}
+
+// GetHoldForUpdateCtx reads the hold locking the row until the end of the transaction
+func (w WorkerRepository) GetHoldForUpdateCtx(ctx context.Context, tx repository.Tx, balanceHold core.BalanceHold) (*core.BalanceHold, error){
+	childLogger.Debug().Msg("GetHoldForUpdateCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.GET-FOR-UPDATE-Balance-Hold")
+	defer func() {
+		root.End(nil)
+	}()
+
+	return scanHold(sql_tx.QueryRowContext(ctx, selectHold + ` FOR UPDATE`, balanceHold.ID, balanceHold.TenantID))
+}
+
+func scanHold(row *sql.Row) (*core.BalanceHold, error){
+	result_query := core.BalanceHold{}
+	var amount, captured_amount string
+	err := row.Scan(&result_query.ID,
+					&result_query.FkBalanceID,
+					&result_query.AccountID,
+					&result_query.Currency,
+					&amount,
+					&captured_amount,
+					&result_query.Status,
+					&result_query.ExpiresAt,
+					&result_query.ChargeID,
+					&result_query.TenantID,
+					&result_query.CreatedAt,
+					&result_query.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, erro.ErrNotFound
+	}
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Scan statement")
+		return nil, errors.New(err.Error())
+	}
+
+	result_query.Amount, err = core.ParseMoney(amount, result_query.Currency)
+	if err != nil {
+		return nil, err
+	}
+	result_query.CapturedAmount, err = core.ParseMoney(captured_amount, result_query.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result_query, nil
+}
+
+func (w WorkerRepository) UpdateHoldCtx(ctx context.Context, tx repository.Tx, balanceHold core.BalanceHold) (error){
+	childLogger.Debug().Msg("UpdateHoldCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.UPDATE-Balance-Hold")
+	defer func() {
+		root.End(nil)
+	}()
+
+	_, err = sql_tx.ExecContext(ctx, `UPDATE balance_hold
+									SET status = $1, captured_amount = $2, charge_id = $3, updated_at = $4
+									WHERE id =$5`,
+									balanceHold.Status,
+									balanceHold.CapturedAmount.String(),
+									nullInt(balanceHold.ChargeID),
+									time.Now(),
+									balanceHold.ID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("UPDATE statement")
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
+
+// ExpireHolds moves the ACTIVE holds past their expiry to EXPIRED
+func (w WorkerRepository) ExpireHolds(ctx context.Context) (int64, error){
+	childLogger.Debug().Msg("ExpireHolds")
+
+	_, root := tracing.Start(ctx, "SQL.EXPIRE-Balance-Hold")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	now := time.Now()
+	result, err := client.ExecContext(ctx, `UPDATE balance_hold
+											SET status = 'EXPIRED', updated_at = $1
+											WHERE status = 'ACTIVE' and expires_at <= $1`, now)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("UPDATE statement")
+		return 0, errors.New(err.Error())
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.New(err.Error())
+	}
+
+	return rows, nil
+}
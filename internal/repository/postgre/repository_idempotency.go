@@ -0,0 +1,181 @@
+package db_postgre
+
+import (
+	"context"
+	"time"
+	"errors"
+	"database/sql"
+
+	_ "github.com/lib/pq"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+// ClaimIdempotencyKey tries to register the key as PROCESSING. It returns true when the
+// caller owns the key, otherwise the stored record so the caller can replay or reject it.
+// A PROCESSING record older than lockTimeout is considered abandoned and taken over.
+func (w WorkerRepository) ClaimIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey, lockTimeout time.Duration) (*core.IdempotencyKey, bool, error){
+	childLogger.Debug().Msg("ClaimIdempotencyKey")
+
+	_, root := tracing.Start(ctx, "SQL.CLAIM-Idempotency-Key")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	now := time.Now()
+	result, err := client.ExecContext(ctx, `INSERT INTO idempotency_key ( idempotency_key,
+																		operation,
+																		request_hash,
+																		status,
+																		created_at,
+																		updated_at)
+											VALUES($1, $2, $3, 'PROCESSING', $4, $4)
+											ON CONFLICT (idempotency_key, operation) DO NOTHING`,
+											idempotencyKey.Key,
+											idempotencyKey.Operation,
+											idempotencyKey.RequestHash,
+											now)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("INSERT statement")
+		return nil, false, errors.New(err.Error())
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, errors.New(err.Error())
+	}
+	if rows == 1 {
+		idempotencyKey.Status = "PROCESSING"
+		idempotencyKey.CreatedAt = now
+		idempotencyKey.UpdatedAt = now
+		return &idempotencyKey, true, nil
+	}
+
+	result_query := core.IdempotencyKey{}
+	var response_status sql.NullInt64
+	err = client.QueryRowContext(ctx, `SELECT idempotency_key, operation, request_hash, status, response_status, response_body, created_at, updated_at
+										FROM idempotency_key
+										WHERE idempotency_key =$1 and operation =$2`,
+										idempotencyKey.Key,
+										idempotencyKey.Operation).Scan(	&result_query.Key,
+																		&result_query.Operation,
+																		&result_query.RequestHash,
+																		&result_query.Status,
+																		&response_status,
+																		&result_query.ResponseBody,
+																		&result_query.CreatedAt,
+																		&result_query.UpdatedAt)
+	if err == sql.ErrNoRows {
+		// Released between the INSERT and the SELECT, let the client retry
+		return nil, false, erro.ErrIdempotencyInProgress
+	}
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Query statement")
+		return nil, false, errors.New(err.Error())
+	}
+	result_query.ResponseStatus = int(response_status.Int64)
+
+	if 	result_query.Status == "PROCESSING" &&
+		result_query.RequestHash == idempotencyKey.RequestHash &&
+		result_query.UpdatedAt.Before(now.Add(-lockTimeout)) {
+		result, err := client.ExecContext(ctx, `UPDATE idempotency_key
+												SET updated_at = $1
+												WHERE idempotency_key =$2 and operation =$3 and status = 'PROCESSING' and updated_at =$4`,
+												now,
+												result_query.Key,
+												result_query.Operation,
+												result_query.UpdatedAt)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("UPDATE statement")
+			return nil, false, errors.New(err.Error())
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return nil, false, errors.New(err.Error())
+		}
+		if rows == 1 {
+			childLogger.Debug().Str("idempotency_key", result_query.Key).Msg("Abandoned key taken over")
+			result_query.UpdatedAt = now
+			return &result_query, true, nil
+		}
+	}
+
+	return &result_query, false, nil
+}
+
+func (w WorkerRepository) CompleteIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error){
+	childLogger.Debug().Msg("CompleteIdempotencyKey")
+
+	_, root := tracing.Start(ctx, "SQL.COMPLETE-Idempotency-Key")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	_, err := client.ExecContext(ctx, `UPDATE idempotency_key
+										SET status = 'COMPLETED', response_status = $1, response_body = $2, updated_at = $3
+										WHERE idempotency_key =$4 and operation =$5`,
+										idempotencyKey.ResponseStatus,
+										idempotencyKey.ResponseBody,
+										time.Now(),
+										idempotencyKey.Key,
+										idempotencyKey.Operation)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("UPDATE statement")
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
+
+func (w WorkerRepository) ReleaseIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error){
+	childLogger.Debug().Msg("ReleaseIdempotencyKey")
+
+	_, root := tracing.Start(ctx, "SQL.RELEASE-Idempotency-Key")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	_, err := client.ExecContext(ctx, `DELETE FROM idempotency_key
+										WHERE idempotency_key =$1 and operation =$2 and status = 'PROCESSING'`,
+										idempotencyKey.Key,
+										idempotencyKey.Operation)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("DELETE statement")
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
+
+// DeleteIdempotencyKeys deletes the keys not updated since before, the responses are
+// no longer replayed after that
+func (w WorkerRepository) DeleteIdempotencyKeys(ctx context.Context, before time.Time) (int64, error){
+	childLogger.Debug().Msg("DeleteIdempotencyKeys")
+
+	_, root := tracing.Start(ctx, "SQL.DELETE-Idempotency-Keys")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	result, err := client.ExecContext(ctx, `DELETE FROM idempotency_key WHERE updated_at < $1`, before)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("DELETE statement")
+		return 0, errors.New(err.Error())
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.New(err.Error())
+	}
+
+	return rows, nil
+}
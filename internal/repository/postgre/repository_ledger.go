@@ -0,0 +1,109 @@
+package db_postgre
+
+import (
+	"context"
+	"time"
+	"errors"
+
+	_ "github.com/lib/pq"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+// AddPostingsCtx inserts the postings of a charge, the deferred constraint trigger
+// ledger_posting_balanced rejects the commit when they do not balance
+func (w WorkerRepository) AddPostingsCtx(ctx context.Context, tx repository.Tx, postings []core.LedgerPosting) (error){
+	childLogger.Debug().Msg("AddPostingsCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.ADD-Ledger-Posting")
+	defer func() {
+		root.End(nil)
+	}()
+
+	stmt, err := sql_tx.PrepareContext(ctx, `INSERT INTO ledger_posting (	charge_id,
+																			account,
+																			account_type,
+																			direction,
+																			amount,
+																			currency,
+																			created_at)
+											VALUES($1, $2, $3, $4, $5, $6, $7)`)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("INSERT statement")
+		return errors.New(err.Error())
+	}
+	defer stmt.Close()
+
+	created_at := time.Now()
+	for _, posting := range postings {
+		_, err = stmt.ExecContext(ctx,
+								posting.ChargeID,
+								posting.Account,
+								posting.AccountType,
+								posting.Direction,
+								posting.Amount.String(),
+								posting.Currency,
+								created_at)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("Exec statement")
+			return errors.New(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// SumPostings returns the debits and credits of every account (of the currency, all when empty)
+func (w WorkerRepository) SumPostings(ctx context.Context, currency string) (*[]core.LedgerAccountBalance, error){
+	childLogger.Debug().Msg("SumPostings")
+
+	_, root := tracing.Start(ctx, "SQL.SUM-Ledger-Posting")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	balance_list := []core.LedgerAccountBalance{}
+
+	rows, err := client.QueryContext(ctx, `SELECT account, account_type, currency,
+												coalesce(sum(amount) FILTER (WHERE direction = 'DEBIT'), 0)::text,
+												coalesce(sum(amount) FILTER (WHERE direction = 'CREDIT'), 0)::text
+											FROM ledger_posting
+											WHERE ($1 = '' or currency = $1)
+											GROUP BY account, account_type, currency
+											order by currency, account_type, account`, currency)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("SELECT statement")
+		return nil, errors.New(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result_query := core.LedgerAccountBalance{}
+		var debits, credits string
+		err := rows.Scan(&result_query.Account, &result_query.AccountType, &result_query.Currency, &debits, &credits)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("Scan statement")
+			return nil, errors.New(err.Error())
+		}
+		result_query.Debits, err = core.ParseMoney(debits, result_query.Currency)
+		if err != nil {
+			return nil, err
+		}
+		result_query.Credits, err = core.ParseMoney(credits, result_query.Currency)
+		if err != nil {
+			return nil, err
+		}
+		balance_list = append(balance_list, result_query)
+	}
+
+	return &balance_list, nil
+}
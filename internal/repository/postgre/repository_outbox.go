@@ -0,0 +1,147 @@
+package db_postgre
+
+import (
+	"context"
+	"sort"
+	"time"
+	"errors"
+
+	_ "github.com/lib/pq"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+func (w WorkerRepository) AddOutboxEventCtx(ctx context.Context, tx repository.Tx, outboxEvent core.OutboxEvent) (*core.OutboxEvent, error){
+	childLogger.Debug().Msg("AddOutboxEventCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.ADD-Outbox-Event")
+	defer func() {
+		root.End(nil)
+	}()
+
+	outboxEvent.Status = "PENDING"
+	outboxEvent.CreatedAt = time.Now()
+	outboxEvent.NextAttemptAt = outboxEvent.CreatedAt
+	err = sql_tx.QueryRowContext(ctx, `INSERT INTO outbox_event (	aggregate_id,
+																event_type,
+																payload,
+																status,
+																attempts,
+																next_attempt_at,
+																created_at)
+									VALUES($1, $2, $3, $4, 0, $5, $5) RETURNING id`,
+									outboxEvent.AggregateID,
+									outboxEvent.EventType,
+									string(outboxEvent.Payload),
+									outboxEvent.Status,
+									outboxEvent.CreatedAt).Scan(&outboxEvent.ID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("INSERT statement")
+		return nil, errors.New(err.Error())
+	}
+
+	return &outboxEvent, nil
+}
+
+// ClaimOutboxEventsCtx leases the pending events due for delivery until leaseUntil (they
+// are not due again before), the rows locked by other pods are skipped. The lease is
+// taken when the transaction commits
+func (w WorkerRepository) ClaimOutboxEventsCtx(ctx context.Context, tx repository.Tx, limit int, leaseUntil time.Time) (*[]core.OutboxEvent, error){
+	childLogger.Debug().Msg("ClaimOutboxEventsCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.CLAIM-Outbox-Event")
+	defer func() {
+		root.End(nil)
+	}()
+
+	event_list := []core.OutboxEvent{}
+
+	rows, err := sql_tx.QueryContext(ctx, `UPDATE outbox_event
+										SET next_attempt_at = $3
+										WHERE id IN (	SELECT id
+														FROM outbox_event
+														WHERE status = 'PENDING' and next_attempt_at <= $1
+														order by id
+														limit $2
+														FOR UPDATE SKIP LOCKED)
+										RETURNING id, aggregate_id, event_type, payload, status, attempts, next_attempt_at, coalesce(last_error, ''), created_at`,
+										time.Now(), limit, leaseUntil)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("SELECT statement")
+		return nil, errors.New(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result_query := core.OutboxEvent{}
+		err := rows.Scan( 	&result_query.ID,
+							&result_query.AggregateID,
+							&result_query.EventType,
+							&result_query.Payload,
+							&result_query.Status,
+							&result_query.Attempts,
+							&result_query.NextAttemptAt,
+							&result_query.LastError,
+							&result_query.CreatedAt,
+						)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("Scan statement")
+			return nil, errors.New(err.Error())
+		}
+		event_list = append(event_list, result_query)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.New(err.Error())
+	}
+	// RETURNING has no order
+	sort.Slice(event_list, func(i, j int) bool { return event_list[i].ID < event_list[j].ID })
+
+	return &event_list, nil
+}
+
+// UpdateOutboxEventCtx saves the delivery outcome (status, attempts, next attempt)
+func (w WorkerRepository) UpdateOutboxEventCtx(ctx context.Context, tx repository.Tx, outboxEvent core.OutboxEvent) (error){
+	childLogger.Debug().Msg("UpdateOutboxEventCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.UPDATE-Outbox-Event")
+	defer func() {
+		root.End(nil)
+	}()
+
+	var sent_at *time.Time
+	if outboxEvent.Status == "SENT" {
+		now := time.Now()
+		sent_at = &now
+	}
+
+	_, err = sql_tx.ExecContext(ctx, `UPDATE outbox_event
+									SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, sent_at = $5
+									WHERE id =$6`,
+									outboxEvent.Status,
+									outboxEvent.Attempts,
+									outboxEvent.NextAttemptAt,
+									outboxEvent.LastError,
+									sent_at,
+									outboxEvent.ID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("UPDATE statement")
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
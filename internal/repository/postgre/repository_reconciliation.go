@@ -0,0 +1,225 @@
+package db_postgre
+
+import (
+	"context"
+	"time"
+	"errors"
+	"database/sql"
+
+	_ "github.com/lib/pq"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// ListChargeAccounts returns the balances (account, balance id and currency) that have charges
+func (w WorkerRepository) ListChargeAccounts(ctx context.Context) (*[]core.BalanceCharge, error){
+	childLogger.Debug().Msg("ListChargeAccounts")
+
+	_, root := tracing.Start(ctx, "SQL.LIST-Accounts-Balance-Charges")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	account_list := []core.BalanceCharge{}
+
+	rows, err := client.QueryContext(ctx, `SELECT DISTINCT account_id, fk_balance_id, currency
+											FROM balance_charge
+											WHERE account_id is not null and account_id <> ''
+											order by account_id`)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("SELECT statement")
+		return nil, errors.New(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result_query := core.BalanceCharge{}
+		err := rows.Scan(&result_query.AccountID, &result_query.FkBalanceID, &result_query.Currency)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("Scan statement")
+			return nil, errors.New(err.Error())
+		}
+		account_list = append(account_list, result_query)
+	}
+
+	return &account_list, nil
+}
+
+const selectReconciliation = `SELECT id, account_id, fk_balance_id, currency, ledger_amount, remote_amount, difference, status, checks, coalesce(adjustment_charge_id, 0), created_at, updated_at
+								FROM reconciliation`
+
+// GetOpenReconciliationCtx reads the OPEN discrepancy of the balance locking the row
+func (w WorkerRepository) GetOpenReconciliationCtx(ctx context.Context, tx repository.Tx, fkBalanceID int) (*core.Reconciliation, error){
+	childLogger.Debug().Msg("GetOpenReconciliationCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.GET-Open-Reconciliation")
+	defer func() {
+		root.End(nil)
+	}()
+
+	res, err := scanReconciliation(sql_tx.QueryRowContext(ctx, selectReconciliation + ` WHERE fk_balance_id =$1 and status = 'OPEN' FOR UPDATE`, fkBalanceID))
+	if err == sql.ErrNoRows {
+		return nil, erro.ErrNotFound
+	}
+	return res, err
+}
+
+func (w WorkerRepository) AddReconciliationCtx(ctx context.Context, tx repository.Tx, reconciliation core.Reconciliation) (*core.Reconciliation, error){
+	childLogger.Debug().Msg("AddReconciliationCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.ADD-Reconciliation")
+	defer func() {
+		root.End(nil)
+	}()
+
+	reconciliation.CreatedAt = time.Now()
+	reconciliation.UpdatedAt = reconciliation.CreatedAt
+	err = sql_tx.QueryRowContext(ctx, `INSERT INTO reconciliation (	account_id,
+																	fk_balance_id,
+																	currency,
+																	ledger_amount,
+																	remote_amount,
+																	difference,
+																	status,
+																	checks,
+																	adjustment_charge_id,
+																	created_at,
+																	updated_at)
+									VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10) RETURNING id`,
+									reconciliation.AccountID,
+									reconciliation.FkBalanceID,
+									reconciliation.Currency,
+									reconciliation.LedgerAmount.String(),
+									reconciliation.RemoteAmount.String(),
+									reconciliation.Difference.String(),
+									reconciliation.Status,
+									reconciliation.Checks,
+									nullInt(reconciliation.AdjustmentID),
+									reconciliation.CreatedAt).Scan(&reconciliation.ID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("INSERT statement")
+		return nil, errors.New(err.Error())
+	}
+
+	return &reconciliation, nil
+}
+
+func (w WorkerRepository) UpdateReconciliationCtx(ctx context.Context, tx repository.Tx, reconciliation core.Reconciliation) (error){
+	childLogger.Debug().Msg("UpdateReconciliationCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return err
+	}
+
+	_, root := tracing.Start(ctx, "SQL.UPDATE-Reconciliation")
+	defer func() {
+		root.End(nil)
+	}()
+
+	_, err = sql_tx.ExecContext(ctx, `UPDATE reconciliation
+									SET ledger_amount = $1, remote_amount = $2, difference = $3, status = $4, checks = $5, adjustment_charge_id = $6, updated_at = $7
+									WHERE id =$8`,
+									reconciliation.LedgerAmount.String(),
+									reconciliation.RemoteAmount.String(),
+									reconciliation.Difference.String(),
+									reconciliation.Status,
+									reconciliation.Checks,
+									nullInt(reconciliation.AdjustmentID),
+									time.Now(),
+									reconciliation.ID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("UPDATE statement")
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
+
+// ListReconciliations returns the discrepancies with the status (all of them when empty), newest first
+func (w WorkerRepository) ListReconciliations(ctx context.Context, status string) (*[]core.Reconciliation, error){
+	childLogger.Debug().Msg("ListReconciliations")
+
+	_, root := tracing.Start(ctx, "SQL.LIST-Reconciliation")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	reconciliation_list := []core.Reconciliation{}
+
+	rows, err := client.QueryContext(ctx, selectReconciliation + ` WHERE ($1 = '' or status = $1) order by updated_at desc, id desc`, status)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("SELECT statement")
+		return nil, errors.New(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		res, err := scanReconciliation(rows)
+		if err != nil {
+			return nil, err
+		}
+		reconciliation_list = append(reconciliation_list, *res)
+	}
+
+	return &reconciliation_list, nil
+}
+
+func scanReconciliation(row rowScanner) (*core.Reconciliation, error){
+	result_query := core.Reconciliation{}
+	var ledger_amount, remote_amount, difference string
+	err := row.Scan(&result_query.ID,
+					&result_query.AccountID,
+					&result_query.FkBalanceID,
+					&result_query.Currency,
+					&ledger_amount,
+					&remote_amount,
+					&difference,
+					&result_query.Status,
+					&result_query.Checks,
+					&result_query.AdjustmentID,
+					&result_query.CreatedAt,
+					&result_query.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Scan statement")
+		return nil, errors.New(err.Error())
+	}
+
+	result_query.LedgerAmount, err = core.ParseMoney(ledger_amount, result_query.Currency)
+	if err != nil {
+		return nil, err
+	}
+	result_query.RemoteAmount, err = core.ParseMoney(remote_amount, result_query.Currency)
+	if err != nil {
+		return nil, err
+	}
+	result_query.Difference, err = core.ParseMoney(difference, result_query.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result_query, nil
+}
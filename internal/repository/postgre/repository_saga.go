@@ -0,0 +1,239 @@
+package db_postgre
+
+import (
+	"context"
+	"time"
+	"errors"
+	"database/sql"
+
+	_ "github.com/lib/pq"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (w WorkerRepository) CreateSaga(ctx context.Context, saga core.Saga) (*core.Saga, error){
+	childLogger.Debug().Msg("CreateSaga")
+
+	_, root := tracing.Start(ctx, "SQL.CREATE-Saga")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	saga.CreatedAt = time.Now()
+	saga.UpdatedAt = saga.CreatedAt
+	err := client.QueryRowContext(ctx, `INSERT INTO saga (	saga_type,
+															status,
+															payload,
+															created_at,
+															updated_at)
+										VALUES($1, $2, $3, $4, $4) RETURNING id`,
+										saga.Type,
+										saga.Status,
+										string(saga.Payload),
+										saga.CreatedAt).Scan(&saga.ID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("INSERT statement")
+		return nil, errors.New(err.Error())
+	}
+
+	return &saga, nil
+}
+
+func (w WorkerRepository) UpdateSaga(ctx context.Context, saga core.Saga) (error){
+	childLogger.Debug().Msg("UpdateSaga")
+
+	return updateSaga(ctx, w.databaseHelper.GetConnection(), saga)
+}
+
+func (w WorkerRepository) UpdateSagaCtx(ctx context.Context, tx repository.Tx, saga core.Saga) (error){
+	childLogger.Debug().Msg("UpdateSagaCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return err
+	}
+
+	return updateSaga(ctx, sql_tx, saga)
+}
+
+func updateSaga(ctx context.Context, client execer, saga core.Saga) (error){
+	_, root := tracing.Start(ctx, "SQL.UPDATE-Saga")
+	defer func() {
+		root.End(nil)
+	}()
+
+	_, err := client.ExecContext(ctx, `UPDATE saga
+										SET status = $1, payload = $2, error = $3, updated_at = $4
+										WHERE id =$5`,
+										saga.Status,
+										string(saga.Payload),
+										saga.Error,
+										time.Now(),
+										saga.ID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("UPDATE statement")
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
+
+func (w WorkerRepository) SaveSagaStep(ctx context.Context, sagaStep core.SagaStep) (error){
+	childLogger.Debug().Msg("SaveSagaStep")
+
+	return saveSagaStep(ctx, w.databaseHelper.GetConnection(), sagaStep)
+}
+
+func (w WorkerRepository) SaveSagaStepCtx(ctx context.Context, tx repository.Tx, sagaStep core.SagaStep) (error){
+	childLogger.Debug().Msg("SaveSagaStepCtx")
+	sql_tx, err := sqlTx(tx)
+	if err != nil {
+		return err
+	}
+
+	return saveSagaStep(ctx, sql_tx, sagaStep)
+}
+
+func saveSagaStep(ctx context.Context, client execer, sagaStep core.SagaStep) (error){
+	_, root := tracing.Start(ctx, "SQL.SAVE-Saga-Step")
+	defer func() {
+		root.End(nil)
+	}()
+
+	_, err := client.ExecContext(ctx, `INSERT INTO saga_step (	saga_id,
+																step_seq,
+																step_name,
+																status,
+																error,
+																updated_at)
+										VALUES($1, $2, $3, $4, $5, $6)
+										ON CONFLICT (saga_id, step_seq) DO UPDATE
+										SET status = EXCLUDED.status, error = EXCLUDED.error, updated_at = EXCLUDED.updated_at`,
+										sagaStep.SagaID,
+										sagaStep.Seq,
+										sagaStep.Name,
+										sagaStep.Status,
+										sagaStep.Error,
+										time.Now())
+	if err != nil {
+		childLogger.Error().Err(err).Msg("INSERT statement")
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
+
+// ListIncompleteSagas returns the sagas still RUNNING or COMPENSATING that were not
+// touched since updatedBefore, with their steps in execution order
+func (w WorkerRepository) ListIncompleteSagas(ctx context.Context, updatedBefore time.Time) (*[]core.Saga, error){
+	childLogger.Debug().Msg("ListIncompleteSagas")
+
+	_, root := tracing.Start(ctx, "SQL.LIST-Incomplete-Saga")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	saga_list := []core.Saga{}
+
+	rows, err := client.QueryContext(ctx, `SELECT id, saga_type, status, payload, coalesce(error, ''), created_at, updated_at
+											FROM saga
+											WHERE status in ('RUNNING', 'COMPENSATING') and updated_at < $1
+											order by id`, updatedBefore)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("SELECT statement")
+		return nil, errors.New(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result_query := core.Saga{}
+		err := rows.Scan( 	&result_query.ID,
+							&result_query.Type,
+							&result_query.Status,
+							&result_query.Payload,
+							&result_query.Error,
+							&result_query.CreatedAt,
+							&result_query.UpdatedAt,
+						)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("Scan statement")
+			return nil, errors.New(err.Error())
+		}
+		saga_list = append(saga_list, result_query)
+	}
+	rows.Close()
+
+	for i := range saga_list {
+		rows, err := client.QueryContext(ctx, `SELECT saga_id, step_seq, step_name, status, coalesce(error, ''), updated_at
+												FROM saga_step
+												WHERE saga_id = $1
+												order by step_seq`, saga_list[i].ID)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("SELECT statement")
+			return nil, errors.New(err.Error())
+		}
+		for rows.Next() {
+			step := core.SagaStep{}
+			err := rows.Scan(	&step.SagaID,
+								&step.Seq,
+								&step.Name,
+								&step.Status,
+								&step.Error,
+								&step.UpdatedAt,
+							)
+			if err != nil {
+				rows.Close()
+				childLogger.Error().Err(err).Msg("Scan statement")
+				return nil, errors.New(err.Error())
+			}
+			saga_list[i].Steps = append(saga_list[i].Steps, step)
+		}
+		rows.Close()
+	}
+
+	return &saga_list, nil
+}
+
+// ClaimSaga moves the saga to status only if nobody touched it since it was read,
+// so that a single pod resumes it
+func (w WorkerRepository) ClaimSaga(ctx context.Context, saga core.Saga, status string) (bool, error){
+	childLogger.Debug().Msg("ClaimSaga")
+
+	_, root := tracing.Start(ctx, "SQL.CLAIM-Saga")
+	defer func() {
+		root.End(nil)
+	}()
+
+	client := w.databaseHelper.GetConnection()
+
+	result, err := client.ExecContext(ctx, `UPDATE saga
+											SET status = $1, updated_at = $2
+											WHERE id =$3 and status =$4 and updated_at =$5`,
+											status,
+											time.Now(),
+											saga.ID,
+											saga.Status,
+											saga.UpdatedAt)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("UPDATE statement")
+		return false, errors.New(err.Error())
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.New(err.Error())
+	}
+
+	return rows == 1, nil
+}
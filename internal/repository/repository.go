@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+
+)
+
+// Tx is a unit of work opened by StartTx, the ...Ctx methods run inside it and
+// nothing is visible to the others until Commit
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+type TxStarter interface {
+	StartTx(ctx context.Context) (Tx, error)
+	Ping(ctx context.Context) (bool, error)
+}
+
+type ChargeStore interface {
+	Add(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error)
+	Get(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error)
+	List(ctx context.Context, filter core.ChargeFilter) (*[]core.BalanceCharge, error)
+	AddCtx(ctx context.Context, tx Tx, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error)
+	GetForUpdateCtx(ctx context.Context, tx Tx, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error)
+	SumReversalsCtx(ctx context.Context, tx Tx, balanceCharge core.BalanceCharge) (*core.Money, error)
+	SumChargesSince(ctx context.Context, balanceCharge core.BalanceCharge, since time.Time) (*core.Money, error)
+}
+
+type HoldStore interface {
+	LockBalanceCtx(ctx context.Context, tx Tx, fkBalanceID int) (error)
+	SumActiveHolds(ctx context.Context, balanceHold core.BalanceHold) (*core.Money, error)
+	SumActiveHoldsCtx(ctx context.Context, tx Tx, balanceHold core.BalanceHold) (*core.Money, error)
+	AddHoldCtx(ctx context.Context, tx Tx, balanceHold core.BalanceHold) (*core.BalanceHold, error)
+	GetHold(ctx context.Context, balanceHold core.BalanceHold) (*core.BalanceHold, error)
+	GetHoldForUpdateCtx(ctx context.Context, tx Tx, balanceHold core.BalanceHold) (*core.BalanceHold, error)
+	UpdateHoldCtx(ctx context.Context, tx Tx, balanceHold core.BalanceHold) (error)
+	ExpireHolds(ctx context.Context) (int64, error)
+}
+
+type ReconciliationStore interface {
+	ListChargeAccounts(ctx context.Context) (*[]core.BalanceCharge, error)
+	GetOpenReconciliationCtx(ctx context.Context, tx Tx, fkBalanceID int) (*core.Reconciliation, error)
+	AddReconciliationCtx(ctx context.Context, tx Tx, reconciliation core.Reconciliation) (*core.Reconciliation, error)
+	UpdateReconciliationCtx(ctx context.Context, tx Tx, reconciliation core.Reconciliation) (error)
+	ListReconciliations(ctx context.Context, status string) (*[]core.Reconciliation, error)
+}
+
+type LedgerStore interface {
+	AddPostingsCtx(ctx context.Context, tx Tx, postings []core.LedgerPosting) (error)
+	SumPostings(ctx context.Context, currency string) (*[]core.LedgerAccountBalance, error)
+}
+
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey, lockTimeout time.Duration) (*core.IdempotencyKey, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error)
+	ReleaseIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error)
+	DeleteIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+type SagaStore interface {
+	CreateSaga(ctx context.Context, saga core.Saga) (*core.Saga, error)
+	UpdateSaga(ctx context.Context, saga core.Saga) (error)
+	UpdateSagaCtx(ctx context.Context, tx Tx, saga core.Saga) (error)
+	SaveSagaStep(ctx context.Context, sagaStep core.SagaStep) (error)
+	SaveSagaStepCtx(ctx context.Context, tx Tx, sagaStep core.SagaStep) (error)
+	ListIncompleteSagas(ctx context.Context, updatedBefore time.Time) (*[]core.Saga, error)
+	ClaimSaga(ctx context.Context, saga core.Saga, status string) (bool, error)
+}
+
+type OutboxStore interface {
+	AddOutboxEventCtx(ctx context.Context, tx Tx, outboxEvent core.OutboxEvent) (*core.OutboxEvent, error)
+	ClaimOutboxEventsCtx(ctx context.Context, tx Tx, limit int, leaseUntil time.Time) (*[]core.OutboxEvent, error)
+	UpdateOutboxEventCtx(ctx context.Context, tx Tx, outboxEvent core.OutboxEvent) (error)
+}
+
+// ChargeRepository is the storage used by the service, implemented by
+// db_postgre (production) and db_memory (local development and tests)
+type ChargeRepository interface {
+	TxStarter
+	ChargeStore
+	HoldStore
+	IdempotencyStore
+	SagaStore
+	OutboxStore
+	ReconciliationStore
+	LedgerStore
+}
@@ -0,0 +1,328 @@
+// Package repositorytest is the conformance suite of repository.ChargeRepository, run by the
+// tests of every implementation so they behave the same for the service
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/repository"
+
+)
+
+// Run checks the repository on the balance fkBalanceID, which must exist and have no charges
+func Run(t *testing.T, repo repository.ChargeRepository, fkBalanceID int) {
+	tenantID := "TENANT-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	t.Run("Commit", func(t *testing.T) { testCommit(t, repo, fkBalanceID, tenantID) })
+	t.Run("Rollback", func(t *testing.T) { testRollback(t, repo, fkBalanceID, tenantID) })
+	t.Run("TransactionDone", func(t *testing.T) { testTransactionDone(t, repo, fkBalanceID, tenantID) })
+	t.Run("Reversals", func(t *testing.T) { testReversals(t, repo, fkBalanceID, tenantID) })
+	t.Run("Holds", func(t *testing.T) { testHolds(t, repo, fkBalanceID, tenantID) })
+	t.Run("IdempotencyKeys", func(t *testing.T) { testIdempotencyKeys(t, repo, tenantID) })
+	// Last, it needs the only charges of the balance to be its own
+	t.Run("ListPaging", func(t *testing.T) { testListPaging(t, repo, fkBalanceID, tenantID) })
+}
+
+func newCharge(fkBalanceID int, tenantID string, units int64) core.BalanceCharge {
+	return core.BalanceCharge{	AccountID:		"ACC-TEST",
+								FkBalanceID:	fkBalanceID,
+								Type:			"CRED",
+								Currency:		"BRL",
+								Amount:			core.NewMoney(units, "BRL"),
+								TenantID:		tenantID,
+							}
+}
+
+// addCharges inserts and commits the charges in one transaction
+func addCharges(t *testing.T, repo repository.ChargeRepository, charges ...core.BalanceCharge) []core.BalanceCharge {
+	t.Helper()
+	ctx := context.Background()
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	res := []core.BalanceCharge{}
+	for _, charge := range charges {
+		added, err := repo.AddCtx(ctx, tx, charge)
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf("AddCtx: %v", err)
+		}
+		res = append(res, *added)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	return res
+}
+
+func testCommit(t *testing.T, repo repository.ChargeRepository, fkBalanceID int, tenantID string) {
+	ctx := context.Background()
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	added, err := repo.AddCtx(ctx, tx, newCharge(fkBalanceID, tenantID, 1050))
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf("AddCtx: %v", err)
+	}
+	if added.ID == 0 {
+		t.Errorf("AddCtx id = 0")
+	}
+
+	// Visible in the transaction, not outside before the commit
+	inside, err := repo.GetForUpdateCtx(ctx, tx, core.BalanceCharge{ ID: added.ID })
+	if err != nil || inside.ID != added.ID {
+		t.Errorf("GetForUpdateCtx in the transaction = %v, %v", inside, err)
+	}
+	_, err = repo.Get(ctx, core.BalanceCharge{ ID: added.ID })
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("Get before commit err = %v, want ErrNotFound", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	got, err := repo.Get(ctx, core.BalanceCharge{ ID: added.ID })
+	if err != nil {
+		t.Fatalf("Get after commit: %v", err)
+	}
+	if got.Amount.Units != 1050 || got.Currency != "BRL" || got.Type != "CRED" || got.TenantID != tenantID || got.AccountID != "ACC-TEST" || got.FkBalanceID != fkBalanceID {
+		t.Errorf("Get after commit = %+v", got)
+	}
+
+	// The charge of another tenant is not found
+	_, err = repo.Get(ctx, core.BalanceCharge{ ID: added.ID, TenantID: tenantID + "-OTHER" })
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("Get of another tenant err = %v, want ErrNotFound", err)
+	}
+}
+
+func testRollback(t *testing.T, repo repository.ChargeRepository, fkBalanceID int, tenantID string) {
+	ctx := context.Background()
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	added, err := repo.AddCtx(ctx, tx, newCharge(fkBalanceID, tenantID, 2000))
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf("AddCtx: %v", err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	_, err = repo.Get(ctx, core.BalanceCharge{ ID: added.ID })
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("Get after rollback err = %v, want ErrNotFound", err)
+	}
+}
+
+func testTransactionDone(t *testing.T, repo repository.ChargeRepository, fkBalanceID int, tenantID string) {
+	ctx := context.Background()
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	if err := tx.Commit(); err == nil {
+		t.Errorf("second Commit err = nil")
+	}
+	if err := tx.Rollback(); err == nil {
+		t.Errorf("Rollback after Commit err = nil")
+	}
+	if _, err := repo.AddCtx(ctx, tx, newCharge(fkBalanceID, tenantID, 100)); err == nil {
+		t.Errorf("AddCtx on a committed transaction err = nil")
+	}
+
+	// The finished transaction does not keep the next one waiting
+	ctx_timeout, cancel := context.WithTimeout(ctx, 2 * time.Second)
+	defer cancel()
+	next, err := repo.StartTx(ctx_timeout)
+	if err != nil {
+		t.Fatalf("StartTx after Commit: %v", err)
+	}
+	next.Rollback()
+}
+
+func testReversals(t *testing.T, repo repository.ChargeRepository, fkBalanceID int, tenantID string) {
+	ctx := context.Background()
+
+	original := addCharges(t, repo, newCharge(fkBalanceID, tenantID, 1000))[0]
+
+	reversal := newCharge(fkBalanceID, tenantID, -400)
+	reversal.Type = "REVERSAL"
+	reversal.ReversalOf = original.ID
+	voided := newCharge(fkBalanceID, tenantID, -100)
+	voided.Type = "REVERSAL"
+	voided.ReversalOf = original.ID
+	added := addCharges(t, repo, reversal, voided)
+
+	void := newCharge(fkBalanceID, tenantID, 100)
+	void.Type = "VOID"
+	void.ReversalOf = added[1].ID
+	addCharges(t, repo, void)
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	defer tx.Rollback()
+
+	// The voided reversal does not count
+	reversed, err := repo.SumReversalsCtx(ctx, tx, original)
+	if err != nil {
+		t.Fatalf("SumReversalsCtx: %v", err)
+	}
+	if reversed.Units != -400 {
+		t.Errorf("SumReversalsCtx = %s, want -4.00", reversed.String())
+	}
+}
+
+func testHolds(t *testing.T, repo repository.ChargeRepository, fkBalanceID int, tenantID string) {
+	ctx := context.Background()
+
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	err = repo.LockBalanceCtx(ctx, tx, fkBalanceID)
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf("LockBalanceCtx: %v", err)
+	}
+	hold, err := repo.AddHoldCtx(ctx, tx, core.BalanceHold{	FkBalanceID:	fkBalanceID,
+															AccountID:		"ACC-TEST",
+															Currency:		"BRL",
+															Amount:			core.NewMoney(300, "BRL"),
+															CapturedAmount:	core.NewMoney(0, "BRL"),
+															Status:			"ACTIVE",
+															ExpiresAt:		time.Now().Add(time.Hour),
+															TenantID:		tenantID,
+														})
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf("AddHoldCtx: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	got, err := repo.GetHold(ctx, core.BalanceHold{ ID: hold.ID, TenantID: tenantID })
+	if err != nil || got.Amount.Units != 300 || got.Status != "ACTIVE" {
+		t.Errorf("GetHold = %+v, %v", got, err)
+	}
+	_, err = repo.GetHold(ctx, core.BalanceHold{ ID: hold.ID, TenantID: tenantID + "-OTHER" })
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("GetHold of another tenant err = %v, want ErrNotFound", err)
+	}
+
+	held, err := repo.SumActiveHolds(ctx, core.BalanceHold{ FkBalanceID: fkBalanceID, Currency: "BRL" })
+	if err != nil || held.Units != 300 {
+		t.Errorf("SumActiveHolds = %v, %v, want 3.00", held, err)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, repo repository.ChargeRepository, tenantID string) {
+	ctx := context.Background()
+
+	idempotencyKey := core.IdempotencyKey{ Key: tenantID, Operation: "add", RequestHash: "HASH" }
+	_, claimed, err := repo.ClaimIdempotencyKey(ctx, idempotencyKey, time.Minute)
+	if err != nil || !claimed {
+		t.Fatalf("ClaimIdempotencyKey = %v, %v, want claimed", claimed, err)
+	}
+	idempotencyKey.ResponseStatus = 200
+	idempotencyKey.ResponseBody = []byte(`{}`)
+	err = repo.CompleteIdempotencyKey(ctx, idempotencyKey)
+	if err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+
+	// Not expired yet, the response is replayed
+	_, err = repo.DeleteIdempotencyKeys(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteIdempotencyKeys: %v", err)
+	}
+	stored, claimed, err := repo.ClaimIdempotencyKey(ctx, idempotencyKey, time.Minute)
+	if err != nil || claimed || stored.Status != "COMPLETED" || stored.ResponseStatus != 200 {
+		t.Errorf("ClaimIdempotencyKey of a completed key = %+v, %v, %v", stored, claimed, err)
+	}
+
+	count, err := repo.DeleteIdempotencyKeys(ctx, time.Now().Add(time.Second))
+	if err != nil || count < 1 {
+		t.Errorf("DeleteIdempotencyKeys = %d, %v, want at least 1", count, err)
+	}
+	_, claimed, err = repo.ClaimIdempotencyKey(ctx, idempotencyKey, time.Minute)
+	if err != nil || !claimed {
+		t.Errorf("ClaimIdempotencyKey of an expired key = %v, %v, want claimed", claimed, err)
+	}
+}
+
+func testListPaging(t *testing.T, repo repository.ChargeRepository, fkBalanceID int, tenantID string) {
+	ctx := context.Background()
+
+	// Same amounts on purpose, the ties are broken by id
+	charges := []core.BalanceCharge{}
+	for _, units := range []int64{ 500, 100, 300, 100, 500, 200, 300 } {
+		charges = append(charges, newCharge(fkBalanceID, tenantID + "-LIST", units))
+	}
+	addCharges(t, repo, charges...)
+
+	for _, sort := range []string{ core.SortChargedAtDesc, core.SortChargedAtAsc, core.SortAmountDesc, core.SortAmountAsc } {
+		t.Run(sort, func(t *testing.T) {
+			filter := core.ChargeFilter{ FkBalanceID: fkBalanceID, TenantID: tenantID + "-LIST", Sort: sort }
+			all, err := repo.List(ctx, filter)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if len(*all) != len(charges) {
+				t.Fatalf("List = %d charges, want %d", len(*all), len(charges))
+			}
+
+			paged := []core.BalanceCharge{}
+			filter.Limit = 3
+			for page := 0; page < len(charges); page++ {
+				res, err := repo.List(ctx, filter)
+				if err != nil {
+					t.Fatalf("List page %d: %v", page, err)
+				}
+				paged = append(paged, *res...)
+				if len(*res) < filter.Limit {
+					break
+				}
+				cursor := core.NewChargeCursor(sort, (*res)[len(*res)-1])
+				filter.After = &cursor
+			}
+
+			if len(paged) != len(*all) {
+				t.Fatalf("paged = %d charges, want %d", len(paged), len(*all))
+			}
+			for i := range paged {
+				if paged[i].ID != (*all)[i].ID {
+					t.Errorf("paged[%d] = id %d, want id %d", i, paged[i].ID, (*all)[i].ID)
+				}
+			}
+		})
+	}
+}
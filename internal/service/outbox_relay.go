@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/adapter/event"
+	"github.com/go-rest-balance-charges/internal/metrics"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+const (
+	EventChargeCreated		= "ChargeCreated"
+	EventChargeVoided		= "ChargeVoided"
+	EventChargeReversed		= "ChargeReversed"
+	EventWithdrawalCreated	= "WithdrawalCreated"
+	EventChargeAdjusted		= "ChargeAdjusted"
+)
+
+// OutboxRelay publishes the events written in outbox_event with at-least-once
+// delivery: a row is only marked SENT after the publisher acknowledged it.
+// A claimed batch is leased for lease, the other pods skip it meanwhile. An event
+// that failed maxAttempts times is marked DEAD and no longer retried
+type OutboxRelay struct {
+	workerRepository	repository.ChargeRepository
+	publisher			event.Publisher
+	interval			time.Duration
+	batchSize			int
+	lease				time.Duration
+	baseBackoff			time.Duration
+	maxBackoff			time.Duration
+	maxAttempts			int
+}
+
+func NewOutboxRelay(workerRepository repository.ChargeRepository,
+					publisher event.Publisher,
+					interval time.Duration,
+					maxAttempts int) *OutboxRelay {
+	childLogger.Debug().Msg("NewOutboxRelay")
+
+	return &OutboxRelay{
+		workerRepository:	workerRepository,
+		publisher:			publisher,
+		interval:			interval,
+		batchSize:			100,
+		lease:				time.Minute * 2,
+		baseBackoff:		time.Second * 2,
+		maxBackoff:			time.Minute * 10,
+		maxAttempts:		maxAttempts,
+	}
+}
+
+func newChargeEvent(eventType string, balanceCharge core.BalanceCharge) (core.OutboxEvent, error) {
+	payload, err := json.Marshal(balanceCharge)
+	if err != nil {
+		return core.OutboxEvent{}, err
+	}
+	return core.OutboxEvent{
+		AggregateID:	strconv.Itoa(balanceCharge.ID),
+		EventType:		eventType,
+		Payload:		payload,
+	}, nil
+}
+
+// addChargeEventCtx writes the event in the same transaction as the charge
+func (s WorkerService) addChargeEventCtx(ctx context.Context, tx repository.Tx, eventType string, balanceCharge core.BalanceCharge) error {
+	outboxEvent, err := newChargeEvent(eventType, balanceCharge)
+	if err != nil {
+		return err
+	}
+	_, err = s.workerRepository.AddOutboxEventCtx(ctx, tx, outboxEvent)
+	return err
+}
+
+// Start polls the outbox until the context is cancelled
+func (r *OutboxRelay) Start(ctx context.Context) {
+	childLogger.Info().Msg("Start OutboxRelay")
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			childLogger.Info().Msg("Stop OutboxRelay")
+			return
+		case <-ticker.C:
+			// Keep going while full batches are found
+			for {
+				count, err := r.Relay(ctx)
+				if err != nil {
+					childLogger.Error().Err(err).Msg("Error relaying outbox events")
+				}
+				if err != nil || count < r.batchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// Relay leases one batch of due events and publishes it, returning how many were leased.
+// The lease is committed before publishing, so no connection nor row lock is held during
+// the calls, and each outcome is saved in its own transaction
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	ctx, root := tracing.Start(ctx, "Service.OutboxRelay")
+	defer func() {
+		root.End(nil)
+	}()
+
+	events, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Past half of the lease the rest of the batch waits for the end of the lease, so
+	// another pod does not claim an event still being published
+	deadline := time.Now().Add(r.lease / 2)
+	for _, outboxEvent := range *events {
+		if time.Now().After(deadline) {
+			childLogger.Warn().Int64("event_id", outboxEvent.ID).Msg("Outbox lease ending, rest of the batch left to the next claim")
+			break
+		}
+
+		err_publish := r.publisher.Publish(ctx, outboxEvent)
+		outboxEvent.Attempts = outboxEvent.Attempts + 1
+		if err_publish != nil {
+			childLogger.Error().Err(err_publish).Int64("event_id", outboxEvent.ID).Int("attempts", outboxEvent.Attempts).Msg("Error publishing event")
+			outboxEvent.LastError = err_publish.Error()
+			outboxEvent.NextAttemptAt = time.Now().Add(r.backoff(outboxEvent.Attempts))
+			if outboxEvent.Attempts >= r.maxAttempts {
+				outboxEvent.Status = "DEAD"
+			}
+		} else {
+			outboxEvent.Status = "SENT"
+			outboxEvent.LastError = ""
+		}
+
+		err = r.record(ctx, outboxEvent)
+		if err != nil {
+			return 0, err
+		}
+		if outboxEvent.Status == "DEAD" {
+			childLogger.Error().Int64("event_id", outboxEvent.ID).Str("event_type", outboxEvent.EventType).Str("aggregate_id", outboxEvent.AggregateID).Int("attempts", outboxEvent.Attempts).Str("last_error", outboxEvent.LastError).Msg("Outbox event dead-lettered")
+			metrics.OutboxEventsDead.WithLabelValues(outboxEvent.EventType).Inc()
+		}
+	}
+
+	return len(*events), nil
+}
+
+// claim leases the batch in a short transaction
+func (r *OutboxRelay) claim(ctx context.Context) (_ *[]core.OutboxEvent, err error) {
+	tx, err := r.workerRepository.StartTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	events, err := r.workerRepository.ClaimOutboxEventsCtx(ctx, tx, r.batchSize, time.Now().Add(r.lease))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+
+	return events, nil
+}
+
+// record saves the outcome of one publish
+func (r *OutboxRelay) record(ctx context.Context, outboxEvent core.OutboxEvent) (err error) {
+	tx, err := r.workerRepository.StartTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = r.workerRepository.UpdateOutboxEventCtx(ctx, tx, outboxEvent)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
+
+// backoff doubles the wait for every failed attempt, up to maxBackoff
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	wait := r.baseBackoff
+	for i := 1; i < attempts && wait < r.maxBackoff; i++ {
+		wait = wait * 2
+	}
+	if wait > r.maxBackoff {
+		wait = r.maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/metrics"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/repository/memory"
+
+)
+
+// outboxRepository keeps the last status saved of each event
+type outboxRepository struct {
+	db_memory.WorkerRepository
+	mutex		sync.Mutex
+	status		map[int64]string
+}
+
+func (r *outboxRepository) UpdateOutboxEventCtx(ctx context.Context, tx repository.Tx, outboxEvent core.OutboxEvent) error {
+	err := r.WorkerRepository.UpdateOutboxEventCtx(ctx, tx, outboxEvent)
+	if err == nil {
+		r.mutex.Lock()
+		r.status[outboxEvent.ID] = outboxEvent.Status
+		r.mutex.Unlock()
+	}
+	return err
+}
+
+func (r *outboxRepository) eventStatus(id int64) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.status[id]
+}
+
+// failingPublisher fails the events of failures, the next failures[id] times (-1 always)
+type failingPublisher struct {
+	mutex		sync.Mutex
+	failures	map[int64]int
+	published	int
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, outboxEvent core.OutboxEvent) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.failures[outboxEvent.ID] != 0 {
+		p.failures[outboxEvent.ID]--
+		return errors.New("consumer unavailable")
+	}
+	p.published++
+	return nil
+}
+
+func addOutboxEvent(t *testing.T, repo *outboxRepository, id int) int64 {
+	ctx := context.Background()
+	tx, err := repo.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("StartTx: %v", err)
+	}
+	outboxEvent, err := newChargeEvent(EventChargeCreated, core.BalanceCharge{ ID: id, Type: "CRED", Currency: "BRL", Amount: core.NewMoney(100, "BRL") })
+	if err != nil {
+		t.Fatalf("newChargeEvent: %v", err)
+	}
+	res, err := repo.AddOutboxEventCtx(ctx, tx, outboxEvent)
+	if err != nil {
+		t.Fatalf("AddOutboxEventCtx: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	return res.ID
+}
+
+func TestOutboxRelayDeadLetter(t *testing.T) {
+	ctx := testContext(t)
+	repo := &outboxRepository{ WorkerRepository: db_memory.NewWorkerRepository(), status: map[int64]string{} }
+	dead_id := addOutboxEvent(t, repo, 1)
+	sent_id := addOutboxEvent(t, repo, 2)
+
+	publisher := &failingPublisher{ failures: map[int64]int{ dead_id: -1, sent_id: 2 } }
+	relay := NewOutboxRelay(repo, publisher, time.Second, 3)
+	// The failed events are due again right away
+	relay.baseBackoff = 0
+	dead := testutil.ToFloat64(metrics.OutboxEventsDead.WithLabelValues(EventChargeCreated))
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		count, err := relay.Relay(ctx)
+		if err != nil {
+			t.Fatalf("Relay: %v", err)
+		}
+		if attempt == 1 && count != 2 {
+			t.Errorf("attempt %d: relayed %d events, want 2", attempt, count)
+		}
+	}
+	if status := repo.eventStatus(sent_id); status != "SENT" {
+		t.Errorf("event published on the 3rd attempt status = %s, want SENT", status)
+	}
+	if status := repo.eventStatus(dead_id); status != "DEAD" {
+		t.Errorf("event failed 3 times status = %s, want DEAD", status)
+	}
+	if got := testutil.ToFloat64(metrics.OutboxEventsDead.WithLabelValues(EventChargeCreated)) - dead; got != 1 {
+		t.Errorf("dead events counted = %v, want 1", got)
+	}
+
+	// The dead event is not claimed again
+	count, err := relay.Relay(ctx)
+	if err != nil || count != 0 {
+		t.Errorf("Relay after the dead letter = %d, %v, want nothing", count, err)
+	}
+	if publisher.published != 1 {
+		t.Errorf("published = %d, want 1", publisher.published)
+	}
+}
@@ -0,0 +1,482 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/adapter/restapi"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+const (
+	SagaRunning			= "RUNNING"
+	SagaCompleted		= "COMPLETED"
+	SagaCompensating	= "COMPENSATING"
+	SagaCompensated		= "COMPENSATED"
+	SagaFailed			= "FAILED"
+
+	StepStarted			= "STARTED"
+	StepDone			= "DONE"
+	StepFailed			= "FAILED"
+	StepCompensated		= "COMPENSATED"
+)
+
+// Sagas not updated for this period are considered abandoned by a dead pod.
+// It must stay above the server write timeout.
+var sagaResumeAfter = 5 * time.Minute
+
+// sagaState is the data shared by the steps, persisted as the saga payload
+type sagaState struct {
+	BalanceCharge	core.BalanceCharge	`json:"balance_charge"`
+	BalanceBefore	*core.Balance		`json:"balance_before,omitempty"`
+	BalanceAfter	*core.Balance		`json:"balance_after,omitempty"`
+	HoldID			int					`json:"hold_id,omitempty"`
+}
+
+// sagaRun is the execution of a saga, saga.Steps holds the steps already started.
+// A step may checkpoint the state itself (e.g. inside its own database transaction)
+type sagaRun struct {
+	saga		core.Saga
+	current		int
+	state		*sagaState
+}
+
+type sagaStep struct {
+	name		string
+	action		func(ctx context.Context, run *sagaRun) error
+	compensate	func(ctx context.Context, run *sagaRun) error
+}
+
+type sagaDefinition struct {
+	name		string
+	steps		[]sagaStep
+}
+
+func (s WorkerService) sagaDefinitions() map[string]sagaDefinition {
+	definitions := map[string]sagaDefinition{}
+	for _, definition := range []sagaDefinition{ s.addChargeSaga(), s.reverseChargeSaga(), s.captureHoldSaga(), s.withdrawSaga() } {
+		definitions[definition.name] = definition
+	}
+	return definitions
+}
+
+func (r *sagaRun) step() *core.SagaStep {
+	return &r.saga.Steps[r.current]
+}
+
+func (r *sagaRun) payload() ([]byte, error) {
+	return json.Marshal(r.state)
+}
+
+// runSaga executes the steps in order. When a step fails the already executed
+// steps (the failed one included, its outcome may be unknown) are compensated
+// in reverse order and the error of the step is returned
+func (s WorkerService) runSaga(ctx context.Context, definition sagaDefinition, state *sagaState) error {
+	childLogger.Debug().Str("saga", definition.name).Msg("runSaga")
+
+	ctx, root := tracing.Start(ctx, "Service.Saga." + definition.name)
+	defer func() {
+		root.End(nil)
+	}()
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	saga, err := s.workerRepository.CreateSaga(ctx, core.Saga{	Type: definition.name,
+																Status: SagaRunning,
+																Payload: payload })
+	if err != nil {
+		return err
+	}
+
+	run := &sagaRun{ saga: *saga, state: state }
+	for i, step := range definition.steps {
+		sagaStep := core.SagaStep{ SagaID: saga.ID, Seq: i, Name: step.name, Status: StepStarted }
+		err = s.workerRepository.SaveSagaStep(ctx, sagaStep)
+		if err != nil {
+			return s.compensateSaga(ctx, definition, run, err)
+		}
+		run.saga.Steps = append(run.saga.Steps, sagaStep)
+		run.current = i
+
+		err = step.action(ctx, run)
+		if err == nil && run.step().Status != StepDone {
+			err = s.checkpoint(ctx, run)
+		}
+		if err != nil {
+			childLogger.Error().Err(err).Int("saga_id", saga.ID).Str("step", step.name).Msg("Saga step failed")
+			if run.step().Status != StepDone {
+				run.step().Status = StepFailed
+				run.step().Error = err.Error()
+				if err_step := s.workerRepository.SaveSagaStep(ctx, *run.step()); err_step != nil {
+					childLogger.Error().Err(err_step).Int("saga_id", saga.ID).Msg("Error saving saga step")
+				}
+			}
+			run.saga.Error = err.Error()
+			return s.compensateSaga(ctx, definition, run, err)
+		}
+	}
+
+	run.saga.Status = SagaCompleted
+	err = s.workerRepository.UpdateSaga(ctx, run.saga)
+	if err != nil {
+		// All the steps are done, the resume will only mark it as COMPLETED
+		childLogger.Error().Err(err).Int("saga_id", saga.ID).Msg("Error completing saga")
+	}
+
+	return nil
+}
+
+// checkpoint persists the state and marks the current step DONE
+func (s WorkerService) checkpoint(ctx context.Context, run *sagaRun) error {
+	payload, err := run.payload()
+	if err != nil {
+		return err
+	}
+	run.saga.Payload = payload
+	err = s.workerRepository.UpdateSaga(ctx, run.saga)
+	if err != nil {
+		return err
+	}
+
+	step := *run.step()
+	step.Status = StepDone
+	err = s.workerRepository.SaveSagaStep(ctx, step)
+	if err != nil {
+		return err
+	}
+	*run.step() = step
+
+	return nil
+}
+
+// compensateSaga runs the compensations of the started steps in reverse order and returns
+// cause, the error of the failed step. A failed compensation leaves the saga FAILED for a
+// manual fix and returns ErrSagaCompensation: the changes may be applied, so the request
+// is marked committed and not retried
+func (s WorkerService) compensateSaga(ctx context.Context, definition sagaDefinition, run *sagaRun, cause error) error {
+	childLogger.Debug().Int("saga_id", run.saga.ID).Msg("compensateSaga")
+
+	run.saga.Status = SagaCompensating
+	if err := s.workerRepository.UpdateSaga(ctx, run.saga); err != nil {
+		childLogger.Error().Err(err).Int("saga_id", run.saga.ID).Msg("Error updating saga")
+	}
+
+	run.saga.Status = SagaCompensated
+	for i := len(run.saga.Steps) - 1; i >= 0; i-- {
+		run.current = i
+		if run.step().Status == StepCompensated {
+			continue
+		}
+
+		err := definition.steps[i].compensate(ctx, run)
+		if err != nil {
+			childLogger.Error().Err(err).Int("saga_id", run.saga.ID).Str("step", run.step().Name).Msg("Saga compensation failed")
+			run.saga.Status = SagaFailed
+			run.saga.Error = err.Error()
+			break
+		}
+
+		run.step().Status = StepCompensated
+		if err := s.workerRepository.SaveSagaStep(ctx, *run.step()); err != nil {
+			childLogger.Error().Err(err).Int("saga_id", run.saga.ID).Msg("Error saving saga step")
+		}
+	}
+
+	if payload, err := run.payload(); err == nil {
+		run.saga.Payload = payload
+	}
+	if err := s.workerRepository.UpdateSaga(ctx, run.saga); err != nil {
+		childLogger.Error().Err(err).Int("saga_id", run.saga.ID).Msg("Error updating saga")
+	}
+
+	if run.saga.Status == SagaFailed {
+		core.MarkCommitted(ctx)
+		return fmt.Errorf("%w: saga %d: %s", erro.ErrSagaCompensation, run.saga.ID, run.saga.Error)
+	}
+	return cause
+}
+
+// ResumeSagas finishes the sagas left incomplete by a crash: when every step is
+// DONE the saga is only marked COMPLETED, otherwise it is compensated
+func (s WorkerService) ResumeSagas(ctx context.Context) error {
+	childLogger.Debug().Msg("ResumeSagas")
+
+	sagas, err := s.workerRepository.ListIncompleteSagas(ctx, time.Now().Add(-sagaResumeAfter))
+	if err != nil {
+		return err
+	}
+
+	definitions := s.sagaDefinitions()
+	for _, saga := range *sagas {
+		definition, ok := definitions[saga.Type]
+		if !ok || len(saga.Steps) > len(definition.steps) {
+			childLogger.Error().Int("saga_id", saga.ID).Str("saga_type", saga.Type).Msg("Unknown saga type")
+			continue
+		}
+
+		state := &sagaState{}
+		err := json.Unmarshal(saga.Payload, state)
+		if err != nil {
+			childLogger.Error().Err(err).Int("saga_id", saga.ID).Msg("Invalid saga payload")
+			continue
+		}
+
+		completed := saga.Status == SagaRunning && len(saga.Steps) == len(definition.steps)
+		for _, step := range saga.Steps {
+			if step.Status != StepDone {
+				completed = false
+			}
+		}
+		status := SagaCompensating
+		if completed {
+			status = SagaCompleted
+		}
+
+		claimed, err := s.workerRepository.ClaimSaga(ctx, saga, status)
+		if err != nil {
+			return err
+		}
+		if !claimed || completed {
+			continue
+		}
+
+		childLogger.Info().Int("saga_id", saga.ID).Str("saga_type", saga.Type).Msg("Compensating incomplete saga")
+		saga.Status = status
+		s.compensateSaga(ctx, definition, &sagaRun{ saga: saga, state: state }, nil)
+	}
+
+	return nil
+}
+
+// StartSagaResumer resumes the incomplete sagas on startup and then every interval until
+// the context is cancelled, so a pod restarted soon after a crash still finishes them
+func (s WorkerService) StartSagaResumer(ctx context.Context, interval time.Duration) {
+	childLogger.Info().Msg("Start SagaResumer")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.ResumeSagas(ctx)
+		if err != nil {
+			childLogger.Error().Err(err).Msg("Error resuming sagas")
+		}
+
+		select {
+		case <-ctx.Done():
+			childLogger.Info().Msg("Stop SagaResumer")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ------------------- ADD_CHARGE -------------------
+
+func (s WorkerService) addChargeSaga() sagaDefinition {
+	return sagaDefinition{
+		name: "ADD_CHARGE",
+		steps: []sagaStep{
+			{ name: "insert_charge", action: s.insertChargeStep, compensate: s.voidChargeStep },
+			{ name: "update_balance", action: s.updateBalanceStep, compensate: s.revertBalanceStep },
+		},
+	}
+}
+
+// insertChargeStep commits the charge together with the checkpoint, so the charge
+// id is never lost. A reversal is checked against the locked original first, a
+// capture marks its hold CAPTURED, a withdrawal is sent as WithdrawalCreated
+func (s WorkerService) insertChargeStep(ctx context.Context, run *sagaRun) (err error) {
+	tx, err := s.workerRepository.StartTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	balanceCharge := run.state.BalanceCharge
+	eventType := EventChargeCreated
+	switch {
+	case balanceCharge.ReversalOf != 0:
+		eventType = EventChargeReversed
+		err = s.checkReversalCtx(ctx, tx, &balanceCharge)
+		if err != nil {
+			return err
+		}
+	case run.saga.Type == "WITHDRAW":
+		eventType = EventWithdrawalCreated
+	}
+
+	res, err := s.addChargeCtx(ctx, tx, balanceCharge)
+	if err != nil {
+		return err
+	}
+	if run.state.HoldID != 0 {
+		err = s.captureHoldCtx(ctx, tx, run.state.HoldID, *res)
+		if err != nil {
+			return err
+		}
+	}
+	err = s.addChargeEventCtx(ctx, tx, eventType, *res)
+	if err != nil {
+		return err
+	}
+
+	state := *run.state
+	state.BalanceCharge = *res
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	saga := run.saga
+	saga.Payload = payload
+	err = s.workerRepository.UpdateSagaCtx(ctx, tx, saga)
+	if err != nil {
+		return err
+	}
+	step := *run.step()
+	step.Status = StepDone
+	err = s.workerRepository.SaveSagaStepCtx(ctx, tx, step)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.New(err.Error())
+	}
+
+	*run.state = state
+	run.saga = saga
+	*run.step() = step
+
+	return nil
+}
+
+// voidChargeStep cancels the charge with a VOID one (reactivating the captured hold), the
+// ledger is append-only. The event already sent is cancelled by a ChargeVoided one
+func (s WorkerService) voidChargeStep(ctx context.Context, run *sagaRun) (err error) {
+	if run.state.BalanceCharge.ID == 0 {
+		return nil
+	}
+
+	tx, err := s.workerRepository.StartTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if run.state.HoldID != 0 {
+		err = s.reactivateHoldCtx(ctx, tx, run.state.HoldID)
+		if err != nil {
+			return err
+		}
+	}
+	voided, err := s.voidChargeCtx(ctx, tx, run.state.BalanceCharge)
+	if err != nil {
+		return err
+	}
+	// Nothing voided when a previous run of the compensation already did it
+	if voided {
+		err = s.addChargeEventCtx(ctx, tx, EventChargeVoided, run.state.BalanceCharge)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.New(err.Error())
+	}
+
+	return nil
+}
+
+// updateBalanceStep saves the expected balances before posting, so the compensation
+// can find out whether a POST with an unknown outcome was applied
+func (s WorkerService) updateBalanceStep(ctx context.Context, run *sagaRun) error {
+	balance_before, err := s.balanceClient.GetBalance(ctx, run.state.BalanceCharge.AccountID)
+	if err != nil {
+		return err
+	}
+
+	balance_after := balance_before
+	balance_after.Amount, err = balance_before.Amount.Add(run.state.BalanceCharge.Amount)
+	if err != nil {
+		return err
+	}
+
+	run.state.BalanceBefore = &balance_before
+	run.state.BalanceAfter = &balance_after
+	payload, err := run.payload()
+	if err != nil {
+		return err
+	}
+	run.saga.Payload = payload
+	err = s.workerRepository.UpdateSaga(ctx, run.saga)
+	if err != nil {
+		return err
+	}
+
+	childLogger.Debug().Interface("balance_parsed:",balance_after).Msg("")
+
+	// The key is stable for the step, so the POST can be retried and resumed safely
+	ctx = restapi.WithIdempotencyKey(ctx, fmt.Sprintf("balance-charges:saga:%d:%s", run.saga.ID, run.step().Name))
+	_, err = s.balanceClient.UpdateBalance(ctx, run.state.BalanceCharge.AccountID, balance_after)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s WorkerService) revertBalanceStep(ctx context.Context, run *sagaRun) error {
+	if run.state.BalanceAfter == nil {
+		return nil
+	}
+
+	balance_current, err := s.balanceClient.GetBalance(ctx, run.state.BalanceCharge.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if run.step().Status != StepDone {
+		switch balance_current.Amount.Units {
+		case run.state.BalanceBefore.Amount.Units:
+			// The POST was not applied
+			return nil
+		case run.state.BalanceAfter.Amount.Units:
+		default:
+			// The balance moved since, it is not possible to tell if the POST was applied
+			return erro.ErrSagaCompensation
+		}
+	}
+
+	balance_current.Amount, err = balance_current.Amount.Add(run.state.BalanceCharge.Amount.Neg())
+	if err != nil {
+		return err
+	}
+
+	ctx = restapi.WithIdempotencyKey(ctx, fmt.Sprintf("balance-charges:saga:%d:%s:compensate", run.saga.ID, run.step().Name))
+	_, err = s.balanceClient.UpdateBalance(ctx, run.state.BalanceCharge.AccountID, balance_current)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
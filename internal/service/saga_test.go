@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/repository/cache"
+	"github.com/go-rest-balance-charges/internal/repository/memory"
+
+)
+
+// sagaRepository keeps the last status written of each saga. crashOn panics when the
+// saga is updated to that status, as the pod dying before the write
+type sagaRepository struct {
+	db_memory.WorkerRepository
+	mutex		sync.Mutex
+	status		map[int]string
+	crashOn		string
+}
+
+func (r *sagaRepository) record(saga core.Saga) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status[saga.ID] = saga.Status
+}
+
+func (r *sagaRepository) sagaStatus(id int) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.status[id]
+}
+
+func (r *sagaRepository) CreateSaga(ctx context.Context, saga core.Saga) (*core.Saga, error) {
+	res, err := r.WorkerRepository.CreateSaga(ctx, saga)
+	if err == nil {
+		r.record(*res)
+	}
+	return res, err
+}
+
+func (r *sagaRepository) UpdateSaga(ctx context.Context, saga core.Saga) error {
+	r.mutex.Lock()
+	crash := r.crashOn != "" && saga.Status == r.crashOn
+	if crash {
+		r.crashOn = ""
+	}
+	r.mutex.Unlock()
+	if crash {
+		panic(errCrash)
+	}
+
+	err := r.WorkerRepository.UpdateSaga(ctx, saga)
+	if err == nil {
+		r.record(saga)
+	}
+	return err
+}
+
+func (r *sagaRepository) ClaimSaga(ctx context.Context, saga core.Saga, status string) (bool, error) {
+	claimed, err := r.WorkerRepository.ClaimSaga(ctx, saga, status)
+	if claimed {
+		saga.Status = status
+		r.record(saga)
+	}
+	return claimed, err
+}
+
+func newSagaService(balanceClient *fakeBalanceClient) (*WorkerService, *sagaRepository) {
+	repo := &sagaRepository{ WorkerRepository: db_memory.NewWorkerRepository(), status: map[int]string{} }
+	return NewWorkerService(repo, balanceClient, circuitbreaker.NewRegistry(), cache_redis.NewMemoryCache(context.Background()), nil, false), repo
+}
+
+// crashed runs fn and tells whether it stopped on a crash
+func crashed(fn func()) (res bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errCrash {
+				panic(r)
+			}
+			res = true
+		}
+	}()
+	fn()
+	return false
+}
+
+// resumeNow resumes the sagas without waiting for sagaResumeAfter
+func resumeNow(t *testing.T, s *WorkerService) {
+	resume_after := sagaResumeAfter
+	sagaResumeAfter = 0
+	defer func() { sagaResumeAfter = resume_after }()
+
+	err := s.ResumeSagas(testContext(t))
+	if err != nil {
+		t.Fatalf("ResumeSagas: %v", err)
+	}
+}
+
+// checkCharges checks the types of the charges of the balance 1 and, when the charge was
+// voided, that the VOID reverses it and its postings cancel the ones of the charge
+func checkCharges(t *testing.T, repo *sagaRepository, units int64, types ...string) {
+	t.Helper()
+	ctx := testContext(t)
+
+	charges, err := repo.List(ctx, core.ChargeFilter{ FkBalanceID: 1, Sort: core.SortChargedAtAsc })
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	got := []string{}
+	for _, charge := range *charges {
+		got = append(got, charge.Type)
+	}
+	if len(got) != len(types) {
+		t.Fatalf("charges = %v, want %v", got, types)
+	}
+	for i := range types {
+		if got[i] != types[i] {
+			t.Fatalf("charges = %v, want %v", got, types)
+		}
+	}
+	if len(types) < 2 {
+		return
+	}
+
+	charge, void := (*charges)[0], (*charges)[1]
+	if void.ReversalOf != charge.ID || void.Amount.Units != -units {
+		t.Errorf("void = %+v, want %d reversing charge %d", void, -units, charge.ID)
+	}
+	accounts, err := repo.SumPostings(ctx, "BRL")
+	if err != nil {
+		t.Fatalf("SumPostings: %v", err)
+	}
+	if len(*accounts) == 0 {
+		t.Errorf("no postings")
+	}
+	for _, account := range *accounts {
+		if account.Debits.Units != units || account.Credits.Units != units {
+			t.Errorf("postings of %s = %d debits %d credits, want %d of each", account.Account, account.Debits.Units, account.Credits.Units, units)
+		}
+	}
+}
+
+func TestSagaFailedUpdateVoidsCharge(t *testing.T) {
+	balanceClient := newFakeBalanceClient(newBalance(1, "ACC-001", "TENANT-001", 10000))
+	s, repo := newSagaService(balanceClient)
+	charge := core.BalanceCharge{ AccountID: "ACC-001", Type: "CRED", Currency: "BRL", Amount: core.NewMoney(100, "BRL"), TenantID: "TENANT-001" }
+
+	balanceClient.failUpdates = 1
+	_, err := s.AddCtx(testContext(t), charge)
+	if !errors.Is(err, erro.ErrRemoteUnavailable) {
+		t.Errorf("AddCtx err = %v, want ErrRemoteUnavailable", err)
+	}
+	if status := repo.sagaStatus(1); status != SagaCompensated {
+		t.Errorf("saga status = %s, want %s", status, SagaCompensated)
+	}
+	if balanceClient.amount("ACC-001") != 10000 || balanceClient.updateCount() != 0 {
+		t.Errorf("balance = %d after %d updates, want 10000 untouched", balanceClient.amount("ACC-001"), balanceClient.updateCount())
+	}
+	checkCharges(t, repo, 100, "CRED", TypeVoid)
+}
+
+func TestSagaFailedCompensation(t *testing.T) {
+	balanceClient := newFakeBalanceClient(newBalance(1, "ACC-001", "TENANT-001", 10000))
+	s, repo := newSagaService(balanceClient)
+	charge := core.BalanceCharge{ AccountID: "ACC-001", Type: "CRED", Currency: "BRL", Amount: core.NewMoney(100, "BRL"), TenantID: "TENANT-001" }
+
+	// The update is applied but its response lost, then the revert fails
+	balanceClient.lostUpdates = 1
+	balanceClient.failUpdates = 1
+	_, err := s.AddCtx(testContext(t), charge)
+	if !errors.Is(err, erro.ErrSagaCompensation) {
+		t.Errorf("AddCtx err = %v, want ErrSagaCompensation", err)
+	}
+	if status := repo.sagaStatus(1); status != SagaFailed {
+		t.Errorf("saga status = %s, want %s", status, SagaFailed)
+	}
+	// Left for the manual fix, the compensation stopped at the balance
+	if balanceClient.amount("ACC-001") != 10100 {
+		t.Errorf("balance = %d, want 10100", balanceClient.amount("ACC-001"))
+	}
+	checkCharges(t, repo, 100, "CRED")
+
+	// A FAILED saga is not resumed
+	resumeNow(t, s)
+	if status := repo.sagaStatus(1); status != SagaFailed || balanceClient.updateCount() != 1 {
+		t.Errorf("after resume saga status = %s, updates = %d, want %s and 1", status, balanceClient.updateCount(), SagaFailed)
+	}
+}
+
+func TestResumeSagaAfterCrash(t *testing.T) {
+	tests := []struct {
+		name		string
+		crash		string
+		crashOn		string
+		wantStatus	string
+		wantUpdates	int
+		wantAmount	int64
+		wantTypes	[]string
+	}{
+		{ "crash before the update", crashBefore, "", SagaCompensated, 0, 10000, []string{ "CRED", TypeVoid } },
+		{ "crash after the update", crashAfter, "", SagaCompensated, 2, 10000, []string{ "CRED", TypeVoid } },
+		{ "crash before completing", "", SagaCompleted, SagaCompleted, 1, 10100, []string{ "CRED" } },
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balanceClient := newFakeBalanceClient(newBalance(1, "ACC-001", "TENANT-001", 10000))
+			s, repo := newSagaService(balanceClient)
+			charge := core.BalanceCharge{ AccountID: "ACC-001", Type: "CRED", Currency: "BRL", Amount: core.NewMoney(100, "BRL"), TenantID: "TENANT-001" }
+
+			balanceClient.crash = tt.crash
+			repo.crashOn = tt.crashOn
+			if !crashed(func() { s.AddCtx(testContext(t), charge) }) {
+				t.Fatalf("AddCtx did not crash")
+			}
+			updates := balanceClient.updateCount()
+
+			// Not abandoned yet, the pod may still be running it
+			err := s.ResumeSagas(testContext(t))
+			if err != nil {
+				t.Fatalf("ResumeSagas: %v", err)
+			}
+			if status := repo.sagaStatus(1); status != SagaRunning || balanceClient.updateCount() != updates {
+				t.Fatalf("saga resumed too soon, status = %s", status)
+			}
+
+			// The second resume finds nothing to do
+			for i := 0; i < 2; i++ {
+				resumeNow(t, s)
+				if status := repo.sagaStatus(1); status != tt.wantStatus {
+					t.Errorf("resume %d: saga status = %s, want %s", i, status, tt.wantStatus)
+				}
+				if balanceClient.updateCount() != tt.wantUpdates || balanceClient.amount("ACC-001") != tt.wantAmount {
+					t.Errorf("resume %d: balance = %d after %d updates, want %d after %d", i, balanceClient.amount("ACC-001"), balanceClient.updateCount(), tt.wantAmount, tt.wantUpdates)
+				}
+			}
+			checkCharges(t, repo, 100, tt.wantTypes...)
+		})
+	}
+}
@@ -2,91 +2,103 @@ package service
 
 import (
 	"errors"
-	"strconv"
 	"context"
-	"math"
 	"github.com/rs/zerolog/log"
-	"github.com/mitchellh/mapstructure"
 
 	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/metrics"
 	"github.com/go-rest-balance-charges/internal/core"
-	"github.com/go-rest-balance-charges/internal/repository/postgre"
+	"github.com/go-rest-balance-charges/internal/repository"
 	"github.com/go-rest-balance-charges/internal/repository/cache"
 	"github.com/go-rest-balance-charges/internal/adapter/restapi"
-	"github.com/aws/aws-xray-sdk-go/xray"
-	"github.com/sony/gobreaker"
+	"github.com/go-rest-balance-charges/internal/adapter/fx"
+	"github.com/go-rest-balance-charges/internal/tracing"
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
 
 )
 
 var childLogger = log.With().Str("service", "service").Logger()
 
 type WorkerService struct {
-	workerRepository 		*db_postgre.WorkerRepository
-	restapi					*restapi.RestApiSConfig
-	circuitBreaker			*gobreaker.CircuitBreaker
-	cache					*cache_redis.CacheService
+	workerRepository 		repository.ChargeRepository
+	balanceClient			restapi.BalanceClient
+	breakers				*circuitbreaker.Registry
+	cache					cache_redis.Cache
+	rateProvider			fx.RateProvider
+	fxConvert				bool
 }
 
-func NewWorkerService(workerRepository 	*db_postgre.WorkerRepository, 
-						restapi 		*restapi.RestApiSConfig,
-						circuitBreaker	*gobreaker.CircuitBreaker,
-						cache_redis		*cache_redis.CacheService) *WorkerService{
+func NewWorkerService(workerRepository 	repository.ChargeRepository, 
+						balanceClient 	restapi.BalanceClient,
+						breakers		*circuitbreaker.Registry,
+						cache_redis		cache_redis.Cache,
+						rateProvider	fx.RateProvider,
+						fxConvert		bool) *WorkerService{
 	childLogger.Debug().Msg("NewWorkerService")
 
 	return &WorkerService{
 		workerRepository:	workerRepository,
-		restapi:			restapi,
-		circuitBreaker: 	circuitBreaker,
-		cache:				cache_redis,						
+		balanceClient:		balanceClient,
+		breakers: 			breakers,
+		cache:				cache_redis,
+		rateProvider:		rateProvider,
+		fxConvert:			fxConvert,						
 	}
 }
 
 func (s WorkerService) Add(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
 	childLogger.Debug().Msg("Add")
 
-	_, root := xray.BeginSubsegment(ctx, "Service.Add")
+	ctx, root := tracing.Start(ctx, "Service.Add")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
+	tracing.SetChargeAttributes(ctx, root, balanceCharge)
 
-	rest_interface_data, err := s.restapi.GetData(ctx, balanceCharge.AccountID)
+	return s.addCharge(ctx, balanceCharge)
+}
+
+// addCharge checks the account and runs the ADD_CHARGE saga (insert the charge, then
+// update go-rest-balance), compensated if the balance update fails
+func (s WorkerService) addCharge(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
+	balance_parsed, err := s.balanceClient.GetBalance(ctx, balanceCharge.AccountID)
 	if err != nil {
 		return nil, err
 	}
-
-	var balance_parsed core.Balance
-	err = mapstructure.Decode(rest_interface_data, &balance_parsed)
-    if err != nil {
-		childLogger.Error().Err(err).Msg("error parse interface")
-		return nil, errors.New(err.Error())
-    }
+	if !balanceOfTenant(balance_parsed, balanceCharge.TenantID) {
+		return nil, erro.ErrNotFound
+	}
 
 	childLogger.Debug().Interface("balance_parsed:",balance_parsed).Msg("")
-	
-	balanceCharge.FkBalanceID = balance_parsed.ID
-	res, err := s.workerRepository.Add(ctx, balanceCharge)
+
+	err = s.chargeCurrency(ctx, &balanceCharge, balance_parsed)
+	if err != nil {
+		return nil, err
+	}
+	_, err = balance_parsed.Amount.Add(balanceCharge.Amount)
 	if err != nil {
 		return nil, err
 	}
 
-	balance_parsed.Amount = balance_parsed.Amount + balanceCharge.Amount
-	childLogger.Debug().Interface("balance_parsed:",balance_parsed).Msg("")
-
-	_, err = s.restapi.PostData(ctx, balanceCharge.AccountID, balance_parsed)
+	balanceCharge.FkBalanceID = balance_parsed.ID
+	state := &sagaState{ BalanceCharge: balanceCharge }
+	err = s.runSaga(ctx, s.addChargeSaga(), state)
 	if err != nil {
 		return nil, err
 	}
 
-	return res, nil
+	metrics.ChargeCreated(state.BalanceCharge)
+	return &state.BalanceCharge, nil
 }
 
 func (s WorkerService) Get(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
 	childLogger.Debug().Msg("Get")
 
-	_, root := xray.BeginSubsegment(ctx, "Service.Get")
+	_, root := tracing.Start(ctx, "Service.Get")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
+	tracing.SetChargeAttributes(ctx, root, balanceCharge)
 
 	res, err := s.workerRepository.Get(ctx,balanceCharge)
 	if err != nil {
@@ -100,24 +112,16 @@ func (s WorkerService) GetCb(ctx context.Context, balanceCharge core.BalanceChar
 	childLogger.Debug().Msg("GetCb")
 
 	// tracer
-	_, root := xray.BeginSubsegment(ctx, "Service.GetCb")
+	_, root := tracing.Start(ctx, "Service.GetCb")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
+	tracing.SetChargeAttributes(ctx, root, balanceCharge)
 
-	// Business rule with CB
-	res_cb, err := s.circuitBreaker.Execute(func() (interface{}, error) {
-		
-		res, err := s.workerRepository.Get(ctx,balanceCharge)
-		if err != nil {
-			return nil, err
-		}
-	
-		return res, nil
-	})
-
+	// The repository is behind the postgres breaker
+	res, err := s.workerRepository.Get(ctx,balanceCharge)
 	if (err != nil) {
-		if (err != erro.ErrNotFound) {
+		if !errors.Is(err, erro.ErrNotFound) {
 			childLogger.Debug().Msg("Circuit Breaker OPEN !!!")
 			return nil, erro.ErrPending
 		} else {
@@ -125,171 +129,119 @@ func (s WorkerService) GetCb(ctx context.Context, balanceCharge core.BalanceChar
 		}
 	}
 	
-	// Assertion for Cb
-	var balance_charge_assertion core.BalanceCharge
-	err = mapstructure.Decode(res_cb, &balance_charge_assertion)
-    if err != nil {
-		childLogger.Error().Err(err).Msg("error parse interface")
-		return nil, errors.New(err.Error())
-    }
-
-	return &balance_charge_assertion, nil
+	return res, nil
 }
 
-func (s WorkerService) List(ctx context.Context, balanceCharge core.BalanceCharge) (*[]core.BalanceCharge, error){
+// List returns a page of the charges of the account, next_cursor is set when there are more
+func (s WorkerService) List(ctx context.Context, accountID string, filter core.ChargeFilter) (*core.ChargePage, error){
 	childLogger.Debug().Msg("List")
 
-	_, root := xray.BeginSubsegment(ctx, "Service.List")
+	_, root := tracing.Start(ctx, "Service.List")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
+	tracing.SetAccountAttributes(ctx, root, accountID)
 
-	rest_interface_data, err := s.restapi.GetData(ctx, balanceCharge.AccountID)
+	balance_parsed, err := s.balanceClient.GetBalance(ctx, accountID)
 	if err != nil {
 		return nil, err
 	}
 
-	var balance_parsed core.Balance
-	err = mapstructure.Decode(rest_interface_data, &balance_parsed)
-    if err != nil {
-		childLogger.Error().Err(err).Msg("error parse interface")
-		return nil, errors.New(err.Error())
-    }
-
-	balanceCharge.FkBalanceID = balance_parsed.ID
-	res, err := s.workerRepository.List(ctx,balanceCharge)
+	// One more row than the page tells whether there is a next one
+	limit := filter.Limit
+	filter.FkBalanceID = balance_parsed.ID
+	filter.Limit = limit + 1
+	res, err := s.workerRepository.List(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
 
-	return res, nil
+	page := core.ChargePage{ Data: *res, Limit: limit }
+	if len(page.Data) > limit {
+		page.Data = page.Data[:limit]
+		page.NextCursor = core.NewChargeCursor(filter.Sort, page.Data[limit-1]).Encode()
+	}
+
+	return &page, nil
 }
 
 func (s WorkerService) AddCtx(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
 	childLogger.Debug().Msg("AddCtx")
 
-	_, root := xray.BeginSubsegment(ctx, "Service.AddCtx")
-
-	tx, err := s.workerRepository.StartTx(ctx)
-	if err != nil {
-		return nil, err
-	}
-
+	ctx, root := tracing.Start(ctx, "Service.AddCtx")
 	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
-		root.Close(nil)
+		root.End(nil)
 	}()
+	tracing.SetChargeAttributes(ctx, root, balanceCharge)
 
-	rest_interface_data, err := s.restapi.GetData(ctx, balanceCharge.AccountID)
-	if err != nil {
-		return nil, err
-	}
-
-	var balance_parsed core.Balance
-	err = mapstructure.Decode(rest_interface_data, &balance_parsed)
-    if err != nil {
-		childLogger.Error().Err(err).Msg("error parse interface")
-		return nil, errors.New(err.Error())
-    }
-
-	childLogger.Debug().Interface("balance_parsed:",balance_parsed).Msg("")
-
-	balanceCharge.FkBalanceID = balance_parsed.ID
-	res, err := s.workerRepository.AddCtx(ctx, tx, balanceCharge)
-	if err != nil {
-		return nil, err
-	}
-
-	balance_parsed.Amount = balance_parsed.Amount + balanceCharge.Amount
-	childLogger.Debug().Interface("balance_parsed:",balance_parsed).Msg("")
+	return s.addCharge(ctx, balanceCharge)
+}
 
-	_, err = s.restapi.PostData(ctx, balanceCharge.AccountID, balance_parsed)
-	if err != nil {
-		return nil, err
+func (s WorkerService) withdrawSaga() sagaDefinition {
+	return sagaDefinition{
+		name: "WITHDRAW",
+		steps: []sagaStep{
+			{ name: "insert_withdrawal", action: s.insertChargeStep, compensate: s.voidChargeStep },
+			{ name: "update_balance", action: s.updateBalanceStep, compensate: s.revertBalanceStep },
+		},
 	}
-
-	return res, nil
 }
 
-func (s WorkerService) WithdrawCbCtx(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
+// WithdrawCbCtx reserves the amount in Redis and runs the WITHDRAW saga (insert the charge,
+// then update go-rest-balance), compensated if the balance update fails
+func (s WorkerService) WithdrawCbCtx(ctx context.Context, balanceCharge core.BalanceCharge) (_ *core.BalanceCharge, err error){
 	childLogger.Debug().Msg("WithdrawCbCtx")
 
-	_, root := xray.BeginSubsegment(ctx, "Service.WithdrawCbCtx")
-
-	tx, err := s.workerRepository.StartTx(ctx)
-	if err != nil {
-		return nil, err
-	}
+	ctx, root := tracing.Start(ctx, "Service.WithdrawCbCtx")
+	tracing.SetChargeAttributes(ctx, root, balanceCharge)
 
+	reserved := false
+	var balance_parsed core.Balance
 	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
-		// Decrease the amount to Redis
-		err = s.cache.Sum(ctx, balanceCharge.AccountID, balanceCharge.Amount * -1)
-		if err != nil{
-			childLogger.Error().Err(err).Msg("Redis error decrease")
+		// Decrease the amount to Redis, only when the reservation was made
+		if reserved {
+			s.releaseFunds(ctx, balance_parsed, balanceCharge.Amount.Units)
 		}
-		root.Close(nil)
+		root.End(nil)
 	}()
 
-	// Put request to the cache
-	err = s.cache.Sum(ctx, balanceCharge.AccountID, balanceCharge.Amount)
-	if err != nil {
-		return nil, err
-	}
-
-	// Get the current amount in Redis
-	res_redis_amount, err := s.cache.Get(ctx, balanceCharge.AccountID)
+	// Get the current amount in RDS
+	balance_parsed, err = s.balanceClient.GetBalance(ctx, balanceCharge.AccountID)
 	if err != nil {
 		return nil, err
 	}
-
-	// Get the current amount in RDS
-	rest_interface_data, err := s.restapi.GetData(ctx, balanceCharge.AccountID)
-	if err != nil {
+	if !balanceOfTenant(balance_parsed, balanceCharge.TenantID) {
+		err = erro.ErrNotFound
 		return nil, err
 	}
-	res_redis_amount_f64, _ := strconv.ParseFloat(res_redis_amount.(string), 64)
-
-	//Assertion
-	var balance_parsed core.Balance
-	err = mapstructure.Decode(rest_interface_data, &balance_parsed)
-    if err != nil {
-		childLogger.Error().Err(err).Msg("error parse interface")
-		return nil, errors.New(err.Error())
-    }
 	
 	childLogger.Debug().Interface(" >>>>>> balance_parsed:",balance_parsed.Amount).Msg("")
-	childLogger.Debug().Interface(" >>>>>> res_redis_amount_f64:",res_redis_amount_f64).Msg("")
 
-	// Check if has fund
-	if math.Abs(res_redis_amount_f64) > math.Abs(balance_parsed.Amount) {
-		return nil, erro.ErrNoFund
+	err = s.chargeCurrency(ctx, &balanceCharge, balance_parsed)
+	if err != nil {
+		return nil, err
 	}
 
-	// Register a transaction
-	balanceCharge.FkBalanceID = balance_parsed.ID
-	res, err := s.workerRepository.AddCtx(ctx, tx, balanceCharge)
+	// Check if has fund (minus the active holds) and put the request to the cache under
+	// the balance lock, so concurrent withdrawals, holds and captures can not pass the check
+	// on the same funds
+	reserved, err = s.reserveFunds(ctx, balance_parsed, balanceCharge.Amount.Units, true)
 	if err != nil {
 		return nil, err
 	}
+	if !reserved {
+		metrics.WithdrawalsRejected.WithLabelValues("no_fund").Inc()
+		err = erro.ErrNoFund
+		return nil, err
+	}
 
-	// Sum the values
-	balance_parsed.Amount = balance_parsed.Amount + balanceCharge.Amount
-	childLogger.Debug().Interface("balance_parsed:",balance_parsed).Msg("")
-
-	//  Adjust the Account Balance (go-rest-balance)
-	_, err = s.restapi.PostData(ctx, balanceCharge.AccountID, balance_parsed)
+	balanceCharge.FkBalanceID = balance_parsed.ID
+	state := &sagaState{ BalanceCharge: balanceCharge }
+	err = s.runSaga(ctx, s.withdrawSaga(), state)
 	if err != nil {
 		return nil, err
 	}
 
-	return res, nil
+	metrics.ChargeCreated(state.BalanceCharge)
+	return &state.BalanceCharge, nil
 }
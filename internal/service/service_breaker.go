@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+func (s WorkerService) ListBreakers(ctx context.Context) []circuitbreaker.BreakerStatus {
+	childLogger.Debug().Msg("ListBreakers")
+
+	_, root := tracing.Start(ctx, "Service.ListBreakers")
+	defer func() {
+		root.End(nil)
+	}()
+
+	return s.breakers.Status()
+}
+
+// ForceBreaker opens, closes or resets (back to the automatic state) the breaker of a dependency
+func (s WorkerService) ForceBreaker(ctx context.Context, name string, action string) (*circuitbreaker.BreakerStatus, error) {
+	childLogger.Debug().Str("name", name).Str("action", action).Msg("ForceBreaker")
+
+	_, root := tracing.Start(ctx, "Service.ForceBreaker")
+	defer func() {
+		root.End(nil)
+	}()
+
+	return s.breakers.Force(name, action)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+
+)
+
+// The funds of a balance are checked and reserved under its lock (LockBalanceCtx): the
+// withdrawals and the captures reserve their amount in the cache until go-rest-balance is
+// updated, the holds are rows of balance_hold. The reservations of the cache are released
+// under the lock too, so the balance read under it and the pending amount always agree
+
+// unheldCtx locks the balance in tx and returns its amount in go-rest-balance, read after
+// the lock, minus the active holds
+func (s WorkerService) unheldCtx(ctx context.Context, tx repository.Tx, balance core.Balance) (*core.Money, error) {
+	err := s.workerRepository.LockBalanceCtx(ctx, tx, balance.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	balance_current, err := s.balanceClient.GetBalance(ctx, balance.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	held, err := s.workerRepository.SumActiveHoldsCtx(ctx, tx, core.BalanceHold{	FkBalanceID: balance.ID,
+																					Currency: balance_current.Currency })
+	if err != nil {
+		return nil, err
+	}
+
+	unheld, err := balance_current.Amount.Add(held.Neg())
+	if err != nil {
+		return nil, err
+	}
+	return &unheld, nil
+}
+
+// availableCtx is unheldCtx minus the amounts reserved in the cache by the withdrawals and
+// captures in flight
+func (s WorkerService) availableCtx(ctx context.Context, tx repository.Tx, balance core.Balance) (*core.Money, error) {
+	available, err := s.unheldCtx(ctx, tx, balance)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.cache.Get(ctx, balance.AccountID)
+	if errors.Is(err, redis.Nil) {
+		return available, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pending, err := strconv.ParseInt(res.(string), 10, 64)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+
+	// The pending amount is negative
+	available.Units = available.Units + pending
+	return available, nil
+}
+
+// reserveFunds reserves units (negative, minor units) of the balance in the cache, until
+// releaseFunds. With check the reservation is made only when the available funds cover it,
+// it returns false otherwise
+func (s WorkerService) reserveFunds(ctx context.Context, balance core.Balance, units int64, check bool) (bool, error) {
+	tx, err := s.workerRepository.StartTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	// Nothing is written, the transaction only holds the lock
+	defer tx.Rollback()
+
+	if !check {
+		err = s.workerRepository.LockBalanceCtx(ctx, tx, balance.ID)
+		if err != nil {
+			return false, err
+		}
+		err = s.cache.Sum(ctx, balance.AccountID, units)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	unheld, err := s.unheldCtx(ctx, tx, balance)
+	if err != nil {
+		return false, err
+	}
+	// The check against the pending amount and the increment are one step of the cache
+	return s.cache.Reserve(ctx, balance.AccountID, units, unheld.Units)
+}
+
+// releaseFunds undoes reserveFunds, the errors are only logged: the reservation expires
+// with the key of the cache
+func (s WorkerService) releaseFunds(ctx context.Context, balance core.Balance, units int64) {
+	tx, err := s.workerRepository.StartTx(ctx)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Redis error decrease")
+		return
+	}
+	defer tx.Rollback()
+
+	err = s.workerRepository.LockBalanceCtx(ctx, tx, balance.ID)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Redis error decrease")
+		return
+	}
+	err = s.cache.Sum(ctx, balance.AccountID, -units)
+	if err != nil {
+		childLogger.Error().Err(err).Msg("Redis error decrease")
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/core"
+
+)
+
+// chargeCurrency checks the currency of the charge (ISO 4217) against the balance. A charge
+// in another currency is rejected, or converted at the rate of the provider when FX_MODE is
+// convert: the charge keeps the original amount and the snapshot of the rate applied
+func (s WorkerService) chargeCurrency(ctx context.Context, balanceCharge *core.BalanceCharge, balance core.Balance) error {
+	balanceCharge.OriginalAmount = nil
+	balanceCharge.FxRate = nil
+	balanceCharge.Currency = strings.ToUpper(balanceCharge.Currency)
+	balanceCharge.Amount.Currency = balanceCharge.Currency
+
+	if !core.ValidCurrency(balanceCharge.Currency) {
+		return erro.ErrInvalidCurrency
+	}
+	if balanceCharge.Currency == balance.Currency {
+		return nil
+	}
+	if !s.fxConvert || s.rateProvider == nil {
+		return erro.ErrCurrencyMismatch
+	}
+
+	fxRate, err := s.rateProvider.GetRate(ctx, balanceCharge.Currency, balance.Currency)
+	if err != nil {
+		return err
+	}
+	converted, err := balanceCharge.Amount.Convert(fxRate.Rate, balance.Currency)
+	if err != nil {
+		return err
+	}
+	// Too small to be represented in the currency of the balance
+	if converted.IsZero() && !balanceCharge.Amount.IsZero() {
+		return erro.ErrInvalidAmount
+	}
+
+	childLogger.Debug().Str("original", balanceCharge.Amount.String() + " " + balanceCharge.Currency).
+						Str("converted", converted.String() + " " + balance.Currency).
+						Str("rate", fxRate.Rate).Msg("FX conversion")
+
+	original := balanceCharge.Amount
+	balanceCharge.OriginalAmount = &original
+	balanceCharge.FxRate = &fxRate
+	balanceCharge.Amount = converted
+	balanceCharge.Currency = balance.Currency
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+
+	"github.com/go-rest-balance-charges/internal/health"
+)
+
+// ReadinessChecks are the probes of the dependencies, named as their breakers. Redis
+// only degrades the service, the withdrawals fail without it but the rest works
+func (s WorkerService) ReadinessChecks() []health.Check {
+	return []health.Check{
+		{	Name: "postgres",
+			Critical: true,
+			Run: func(ctx context.Context) error {
+				_, err := s.workerRepository.Ping(ctx)
+				return err
+			},
+		},
+		{	Name: "redis",
+			Critical: false,
+			Run: func(ctx context.Context) error {
+				_, err := s.cache.Ping(ctx)
+				return err
+			},
+		},
+		{	Name: "balance",
+			Critical: true,
+			Run: func(ctx context.Context) error {
+				return s.balanceClient.Ping(ctx)
+			},
+		},
+	}
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/metrics"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+const (
+	HoldActive		= "ACTIVE"
+	HoldCaptured	= "CAPTURED"
+	HoldReleased	= "RELEASED"
+	HoldExpired		= "EXPIRED"
+
+	TypeCapture		= "CAPTURE"
+)
+
+var (
+	holdDefaultExpiry	= 15 * time.Minute
+	holdMaxExpiry		= 7 * 24 * time.Hour
+)
+
+func (s WorkerService) captureHoldSaga() sagaDefinition {
+	return sagaDefinition{
+		name: "CAPTURE_HOLD",
+		steps: []sagaStep{
+			{ name: "insert_capture", action: s.insertChargeStep, compensate: s.voidChargeStep },
+			{ name: "update_balance", action: s.updateBalanceStep, compensate: s.revertBalanceStep },
+		},
+	}
+}
+
+// CreateHold reserves the amount against the available balance (balance minus the active holds
+// and the withdrawals and captures in flight)
+func (s WorkerService) CreateHold(ctx context.Context, balanceHold core.BalanceHold) (_ *core.BalanceHold, err error){
+	childLogger.Debug().Msg("CreateHold")
+
+	ctx, root := tracing.Start(ctx, "Service.CreateHold")
+	defer func() {
+		root.End(nil)
+	}()
+	tracing.SetAccountAttributes(ctx, root, balanceHold.AccountID)
+
+	if balanceHold.Amount.Sign() <= 0 {
+		return nil, erro.ErrInvalidAmount
+	}
+	expiry := holdDefaultExpiry
+	if balanceHold.ExpiresIn != 0 {
+		expiry = time.Duration(balanceHold.ExpiresIn) * time.Second
+	}
+	if expiry <= 0 || expiry > holdMaxExpiry {
+		return nil, erro.ErrHoldExpiry
+	}
+
+	balance_parsed, err := s.balanceClient.GetBalance(ctx, balanceHold.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if !balanceOfTenant(balance_parsed, balanceHold.TenantID) {
+		return nil, erro.ErrNotFound
+	}
+	if !core.ValidCurrency(balanceHold.Currency) {
+		return nil, erro.ErrInvalidCurrency
+	}
+	if balance_parsed.Amount.Currency != balanceHold.Amount.Currency {
+		return nil, erro.ErrCurrencyMismatch
+	}
+
+	tx, err := s.workerRepository.StartTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	balanceHold.FkBalanceID = balance_parsed.ID
+	available, err := s.availableCtx(ctx, tx, balance_parsed)
+	if err != nil {
+		return nil, err
+	}
+	if available.Units < balanceHold.Amount.Units {
+		err = erro.ErrNoFund
+		return nil, err
+	}
+
+	balanceHold.Status = HoldActive
+	balanceHold.CapturedAmount = core.NewMoney(0, balanceHold.Currency)
+	balanceHold.ExpiresAt = time.Now().Add(expiry)
+	balanceHold.ExpiresIn = 0
+	res, err := s.workerRepository.AddHoldCtx(ctx, tx, balanceHold)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+
+	return res, nil
+}
+
+func (s WorkerService) GetHold(ctx context.Context, balanceHold core.BalanceHold) (*core.BalanceHold, error){
+	childLogger.Debug().Msg("GetHold")
+
+	_, root := tracing.Start(ctx, "Service.GetHold")
+	defer func() {
+		root.End(nil)
+	}()
+
+	return s.workerRepository.GetHold(ctx, balanceHold)
+}
+
+// CaptureHold turns the hold into a charge of the captured amount (all the hold when no
+// amount is given). A partial capture releases the rest of the hold
+func (s WorkerService) CaptureHold(ctx context.Context, balanceHold core.BalanceHold, holdCapture core.HoldCapture) (*core.BalanceCharge, error){
+	childLogger.Debug().Msg("CaptureHold")
+
+	ctx, root := tracing.Start(ctx, "Service.CaptureHold")
+	defer func() {
+		root.End(nil)
+	}()
+
+	hold, err := s.workerRepository.GetHold(ctx, balanceHold)
+	if err != nil {
+		return nil, err
+	}
+	if !holdIsActive(hold) {
+		return nil, erro.ErrHoldNotActive
+	}
+
+	amount := hold.Amount
+	if holdCapture.Amount != nil {
+		amount = *holdCapture.Amount
+		err = amount.Bind(hold.Currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if amount.Sign() <= 0 || amount.Units > hold.Amount.Units {
+		return nil, erro.ErrHoldCaptureExceeded
+	}
+
+	balanceCharge := core.BalanceCharge{	AccountID:		hold.AccountID,
+											FkBalanceID:	hold.FkBalanceID,
+											Type:			TypeCapture,
+											Currency:		hold.Currency,
+											Amount:			amount.Neg(),
+											TenantID:		hold.TenantID,
+										}
+
+	// The hold covers the amount until it is CAPTURED, go-rest-balance only when the saga
+	// updates it: the amount is reserved in the cache in between, as a withdrawal
+	balance := core.Balance{ ID: hold.FkBalanceID, AccountID: hold.AccountID }
+	_, err = s.reserveFunds(ctx, balance, balanceCharge.Amount.Units, false)
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseFunds(ctx, balance, balanceCharge.Amount.Units)
+
+	state := &sagaState{ BalanceCharge: balanceCharge, HoldID: hold.ID }
+	err = s.runSaga(ctx, s.captureHoldSaga(), state)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.ChargeCreated(state.BalanceCharge)
+	return &state.BalanceCharge, nil
+}
+
+// captureHoldCtx locks the hold and marks it CAPTURED by the charge, it is called
+// by the insert step of the CAPTURE_HOLD saga
+func (s WorkerService) captureHoldCtx(ctx context.Context, tx repository.Tx, holdID int, balanceCharge core.BalanceCharge) error {
+	hold, err := s.workerRepository.GetHoldForUpdateCtx(ctx, tx, core.BalanceHold{ ID: holdID })
+	if err != nil {
+		return err
+	}
+	if !holdIsActive(hold) {
+		return erro.ErrHoldNotActive
+	}
+
+	hold.Status = HoldCaptured
+	hold.CapturedAmount = balanceCharge.Amount.Neg()
+	hold.ChargeID = balanceCharge.ID
+	return s.workerRepository.UpdateHoldCtx(ctx, tx, *hold)
+}
+
+// reactivateHoldCtx undoes captureHoldCtx when the capture saga is compensated
+func (s WorkerService) reactivateHoldCtx(ctx context.Context, tx repository.Tx, holdID int) error {
+	hold, err := s.workerRepository.GetHoldForUpdateCtx(ctx, tx, core.BalanceHold{ ID: holdID })
+	if err != nil {
+		return err
+	}
+	if hold.Status != HoldCaptured {
+		return nil
+	}
+
+	hold.Status = HoldActive
+	hold.CapturedAmount = core.NewMoney(0, hold.Currency)
+	hold.ChargeID = 0
+	return s.workerRepository.UpdateHoldCtx(ctx, tx, *hold)
+}
+
+// ReleaseHold frees the reserved amount, releasing twice is not an error
+func (s WorkerService) ReleaseHold(ctx context.Context, balanceHold core.BalanceHold) (_ *core.BalanceHold, err error){
+	childLogger.Debug().Msg("ReleaseHold")
+
+	ctx, root := tracing.Start(ctx, "Service.ReleaseHold")
+	defer func() {
+		root.End(nil)
+	}()
+
+	tx, err := s.workerRepository.StartTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	hold, err := s.workerRepository.GetHoldForUpdateCtx(ctx, tx, balanceHold)
+	if err != nil {
+		return nil, err
+	}
+
+	switch hold.Status {
+	case HoldReleased:
+	case HoldActive:
+		hold.Status = HoldReleased
+		err = s.workerRepository.UpdateHoldCtx(ctx, tx, *hold)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		err = erro.ErrHoldNotActive
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+
+	return hold, nil
+}
+
+// Available returns the balance of go-rest-balance minus the amount of the active holds
+func (s WorkerService) Available(ctx context.Context, balanceCharge core.BalanceCharge) (*core.AvailableBalance, error){
+	childLogger.Debug().Msg("Available")
+
+	ctx, root := tracing.Start(ctx, "Service.Available")
+	defer func() {
+		root.End(nil)
+	}()
+	tracing.SetChargeAttributes(ctx, root, balanceCharge)
+
+	balance_parsed, err := s.balanceClient.GetBalance(ctx, balanceCharge.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if !balanceOfTenant(balance_parsed, balanceCharge.TenantID) {
+		return nil, erro.ErrNotFound
+	}
+
+	held, err := s.workerRepository.SumActiveHolds(ctx, core.BalanceHold{	FkBalanceID: balance_parsed.ID,
+																			Currency: balance_parsed.Currency })
+	if err != nil {
+		return nil, err
+	}
+	available, err := balance_parsed.Amount.Add(held.Neg())
+	if err != nil {
+		return nil, err
+	}
+
+	return &core.AvailableBalance{	AccountID: balanceCharge.AccountID,
+									Currency: balance_parsed.Currency,
+									Balance: balance_parsed.Amount,
+									Held: *held,
+									Available: available,
+								}, nil
+}
+
+// StartHoldSweeper expires the stale holds every interval until the context is cancelled
+func (s WorkerService) StartHoldSweeper(ctx context.Context, interval time.Duration) {
+	childLogger.Info().Msg("Start HoldSweeper")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			childLogger.Info().Msg("Stop HoldSweeper")
+			return
+		case <-ticker.C:
+			count, err := s.workerRepository.ExpireHolds(ctx)
+			if err != nil {
+				childLogger.Error().Err(err).Msg("Error expiring holds")
+				continue
+			}
+			if count > 0 {
+				childLogger.Info().Int64("count", count).Msg("Holds expired")
+			}
+		}
+	}
+}
+
+// balanceOfTenant is false when the balance belongs to another tenant than the caller
+func balanceOfTenant(balance core.Balance, tenantID string) bool {
+	return tenantID == "" || balance.TenantID == "" || balance.TenantID == tenantID
+}
+
+func holdIsActive(hold *core.BalanceHold) bool {
+	return hold.Status == HoldActive && hold.ExpiresAt.After(time.Now())
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+// A request still PROCESSING after this period is treated as abandoned (pod crash).
+// It must stay above the server write timeout.
+var idempotencyLockTimeout = 2 * time.Minute
+
+// ClaimIdempotencyKey returns (nil, nil) when the caller must process the request,
+// the stored response when it must be replayed, or an error when it must be rejected.
+func (s WorkerService) ClaimIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (*core.IdempotencyKey, error){
+	childLogger.Debug().Msg("ClaimIdempotencyKey")
+
+	_, root := tracing.Start(ctx, "Service.ClaimIdempotencyKey")
+	defer func() {
+		root.End(nil)
+	}()
+
+	res, claimed, err := s.workerRepository.ClaimIdempotencyKey(ctx, idempotencyKey, idempotencyLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	if res.RequestHash != idempotencyKey.RequestHash {
+		return nil, erro.ErrIdempotencyMismatch
+	}
+	if res.Status != "COMPLETED" {
+		return nil, erro.ErrIdempotencyInProgress
+	}
+
+	return res, nil
+}
+
+func (s WorkerService) CompleteIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error){
+	childLogger.Debug().Msg("CompleteIdempotencyKey")
+
+	_, root := tracing.Start(ctx, "Service.CompleteIdempotencyKey")
+	defer func() {
+		root.End(nil)
+	}()
+
+	return s.workerRepository.CompleteIdempotencyKey(ctx, idempotencyKey)
+}
+
+func (s WorkerService) ReleaseIdempotencyKey(ctx context.Context, idempotencyKey core.IdempotencyKey) (error){
+	childLogger.Debug().Msg("ReleaseIdempotencyKey")
+
+	_, root := tracing.Start(ctx, "Service.ReleaseIdempotencyKey")
+	defer func() {
+		root.End(nil)
+	}()
+
+	return s.workerRepository.ReleaseIdempotencyKey(ctx, idempotencyKey)
+}
+
+// StartIdempotencySweeper deletes the keys older than ttl every interval until the context
+// is cancelled. A key PROCESSING is kept at least for the lock timeout
+func (s WorkerService) StartIdempotencySweeper(ctx context.Context, interval time.Duration, ttl time.Duration) {
+	childLogger.Info().Msg("Start IdempotencySweeper")
+
+	if ttl < idempotencyLockTimeout {
+		ttl = idempotencyLockTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			childLogger.Info().Msg("Stop IdempotencySweeper")
+			return
+		case <-ticker.C:
+			count, err := s.workerRepository.DeleteIdempotencyKeys(ctx, time.Now().Add(-ttl))
+			if err != nil {
+				childLogger.Error().Err(err).Msg("Error deleting idempotency keys")
+				continue
+			}
+			if count > 0 {
+				childLogger.Info().Int64("count", count).Msg("Idempotency keys expired")
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+const (
+	TypeFee		= "FEE"
+	TypeVoid	= "VOID"
+)
+
+// chargePostings returns the double entry of the charge: the customer account of the balance
+// against the counterparty of the type (fees, suspense for the reconciliation adjustments,
+// settlement for everything else). A positive charge credits the customer
+func chargePostings(balanceCharge core.BalanceCharge) []core.LedgerPosting {
+	if balanceCharge.Amount.IsZero() {
+		return nil
+	}
+
+	counterparty := core.AccountSettlement
+	switch balanceCharge.Type {
+	case TypeFee:
+		counterparty = core.AccountFees
+	case TypeAdjustment:
+		counterparty = core.AccountSuspense
+	}
+
+	customer_side, counterparty_side := core.PostingCredit, core.PostingDebit
+	amount := balanceCharge.Amount
+	if amount.Sign() < 0 {
+		customer_side, counterparty_side = core.PostingDebit, core.PostingCredit
+		amount = amount.Neg()
+	}
+
+	return []core.LedgerPosting{
+		{	ChargeID:		balanceCharge.ID,
+			Account:		"customer:" + strconv.Itoa(balanceCharge.FkBalanceID),
+			AccountType:	core.AccountCustomer,
+			Direction:		customer_side,
+			Amount:			amount,
+			Currency:		balanceCharge.Currency,
+		},
+		{	ChargeID:		balanceCharge.ID,
+			Account:		ledgerAccount(counterparty, balanceCharge.Currency),
+			AccountType:	counterparty,
+			Direction:		counterparty_side,
+			Amount:			amount,
+			Currency:		balanceCharge.Currency,
+		},
+	}
+}
+
+func ledgerAccount(accountType string, currency string) string {
+	switch accountType {
+	case core.AccountFees:
+		return "fees:" + currency
+	case core.AccountSuspense:
+		return "suspense:" + currency
+	}
+	return "settlement:" + currency
+}
+
+// addChargeCtx inserts the charge and its postings in the transaction, every charge goes
+// through it so the ledger always has the double entry of the charges
+func (s WorkerService) addChargeCtx(ctx context.Context, tx repository.Tx, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error) {
+	res, err := s.workerRepository.AddCtx(ctx, tx, balanceCharge)
+	if err != nil {
+		return nil, err
+	}
+
+	postings := chargePostings(*res)
+	if len(postings) == 0 {
+		return res, nil
+	}
+	err = s.workerRepository.AddPostingsCtx(ctx, tx, postings)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// voidChargeCtx cancels what remains of the charge with a VOID charge (reversal_of the charge)
+// posting the opposite entries of the charge, false when there is nothing left to void
+func (s WorkerService) voidChargeCtx(ctx context.Context, tx repository.Tx, balanceCharge core.BalanceCharge) (bool, error) {
+	original, err := s.workerRepository.GetForUpdateCtx(ctx, tx, balanceCharge)
+	if err != nil {
+		return false, err
+	}
+	reversed, err := s.workerRepository.SumReversalsCtx(ctx, tx, *original)
+	if err != nil {
+		return false, err
+	}
+	remaining, err := original.Amount.Add(*reversed)
+	if err != nil {
+		return false, err
+	}
+	if remaining.IsZero() {
+		return false, nil
+	}
+
+	void, err := s.workerRepository.AddCtx(ctx, tx, core.BalanceCharge{	AccountID:		original.AccountID,
+																		FkBalanceID:	original.FkBalanceID,
+																		Type:			TypeVoid,
+																		Currency:		original.Currency,
+																		Amount:			remaining.Neg(),
+																		TenantID:		original.TenantID,
+																		ReversalOf:		original.ID,
+																	})
+	if err != nil {
+		return false, err
+	}
+
+	// The counterparty is the one of the voided charge, not the one of the VOID type
+	original.Amount = remaining
+	postings := chargePostings(*original)
+	for i := range postings {
+		postings[i].ChargeID = void.ID
+		if postings[i].Direction == core.PostingDebit {
+			postings[i].Direction = core.PostingCredit
+		} else {
+			postings[i].Direction = core.PostingDebit
+		}
+	}
+	err = s.workerRepository.AddPostingsCtx(ctx, tx, postings)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// TrialBalance sums the postings per account and currency, the debits must equal the credits
+func (s WorkerService) TrialBalance(ctx context.Context, currency string) (*[]core.TrialBalance, error){
+	childLogger.Debug().Msg("TrialBalance")
+
+	_, root := tracing.Start(ctx, "Service.TrialBalance")
+	defer func() {
+		root.End(nil)
+	}()
+
+	accounts, err := s.workerRepository.SumPostings(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	trial_list := []core.TrialBalance{}
+	for _, account := range *accounts {
+		if len(trial_list) == 0 || trial_list[len(trial_list)-1].Currency != account.Currency {
+			trial_list = append(trial_list, core.TrialBalance{	Currency:		account.Currency,
+																Accounts:		[]core.LedgerAccountBalance{},
+																TotalDebits:	core.NewMoney(0, account.Currency),
+																TotalCredits:	core.NewMoney(0, account.Currency),
+															})
+		}
+		trial := &trial_list[len(trial_list)-1]
+
+		if core.CreditNormal(account.AccountType) {
+			account.Balance, err = account.Credits.Add(account.Debits.Neg())
+		} else {
+			account.Balance, err = account.Debits.Add(account.Credits.Neg())
+		}
+		if err != nil {
+			return nil, err
+		}
+		trial.Accounts = append(trial.Accounts, account)
+
+		trial.TotalDebits, err = trial.TotalDebits.Add(account.Debits)
+		if err != nil {
+			return nil, err
+		}
+		trial.TotalCredits, err = trial.TotalCredits.Add(account.Credits)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range trial_list {
+		trial_list[i].Balanced = trial_list[i].TotalDebits.Units == trial_list[i].TotalCredits.Units
+		if !trial_list[i].Balanced {
+			childLogger.Error().Str("currency", trial_list[i].Currency).Err(erro.ErrLedgerUnbalanced).Msg("Trial balance")
+		}
+	}
+
+	return &trial_list, nil
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+const (
+	ReconciliationOpen		= "OPEN"
+	ReconciliationCorrected	= "CORRECTED"
+	ReconciliationResolved	= "RESOLVED"
+
+	TypeAdjustment			= "ADJUSTMENT"
+)
+
+// Reconcile compares, per account, the sum of the charges with the balance of go-rest-balance.
+// With autoCorrect a discrepancy seen with the same difference by the previous run is fixed
+// with an ADJUSTMENT charge, so the operations in flight during a run are never adjusted
+func (s WorkerService) Reconcile(ctx context.Context, autoCorrect bool) (*core.ReconciliationReport, error){
+	childLogger.Debug().Msg("Reconcile")
+
+	ctx, root := tracing.Start(ctx, "Service.Reconcile")
+	defer func() {
+		root.End(nil)
+	}()
+
+	report := core.ReconciliationReport{ StartedAt: time.Now() }
+
+	accounts, err := s.workerRepository.ListChargeAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range *accounts {
+		report.Accounts++
+
+		res, err := s.reconcileAccount(ctx, account, autoCorrect)
+		if err != nil {
+			childLogger.Error().Err(err).Str("account_id", account.AccountID).Msg("Error reconciling account")
+			report.Failed++
+			continue
+		}
+		switch {
+		case res == nil:
+			report.Matched++
+		case res.Status == ReconciliationCorrected:
+			report.Discrepancies++
+			report.Corrected++
+		default:
+			report.Discrepancies++
+		}
+	}
+	report.FinishedAt = time.Now()
+
+	return &report, nil
+}
+
+// reconcileAccount returns the discrepancy of the account, nil when the ledger matches
+func (s WorkerService) reconcileAccount(ctx context.Context, account core.BalanceCharge, autoCorrect bool) (_ *core.Reconciliation, err error){
+	tx, err := s.workerRepository.StartTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// The lock serializes the pods and the holds of the balance
+	err = s.workerRepository.LockBalanceCtx(ctx, tx, account.FkBalanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	balance_parsed, err := s.balanceClient.GetBalance(ctx, account.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if balance_parsed.ID != account.FkBalanceID {
+		err = erro.ErrNotFound
+		return nil, err
+	}
+	if balance_parsed.Amount.Currency != account.Currency {
+		err = erro.ErrCurrencyMismatch
+		return nil, err
+	}
+
+	ledger, err := s.workerRepository.SumChargesSince(ctx, account, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	difference, err := balance_parsed.Amount.Add(ledger.Neg())
+	if err != nil {
+		return nil, err
+	}
+
+	open, err := s.workerRepository.GetOpenReconciliationCtx(ctx, tx, account.FkBalanceID)
+	if err != nil && !errors.Is(err, erro.ErrNotFound) {
+		return nil, err
+	}
+	err = nil
+
+	if difference.IsZero() {
+		if open != nil {
+			open.LedgerAmount = *ledger
+			open.RemoteAmount = balance_parsed.Amount
+			open.Difference = difference
+			open.Status = ReconciliationResolved
+			open.Checks++
+			err = s.workerRepository.UpdateReconciliationCtx(ctx, tx, *open)
+			if err != nil {
+				return nil, err
+			}
+			childLogger.Info().Str("account_id", account.AccountID).Int("reconciliation_id", open.ID).Msg("Reconciliation resolved")
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, errors.New(err.Error())
+		}
+		return nil, nil
+	}
+
+	reconciliation := core.Reconciliation{	AccountID:		account.AccountID,
+											FkBalanceID:	account.FkBalanceID,
+											Currency:		account.Currency,
+											Status:			ReconciliationOpen,
+										}
+	confirmed := false
+	if open != nil {
+		confirmed = open.Difference.Cmp(difference) == 0
+		reconciliation = *open
+	}
+	reconciliation.LedgerAmount = *ledger
+	reconciliation.RemoteAmount = balance_parsed.Amount
+	reconciliation.Difference = difference
+	reconciliation.Checks++
+
+	childLogger.Warn().	Str("account_id", account.AccountID).
+						Str("ledger", ledger.String()).
+						Str("remote", balance_parsed.Amount.String()).
+						Str("difference", difference.String()).
+						Msg("Reconciliation discrepancy")
+
+	if autoCorrect && confirmed {
+		adjustment := core.BalanceCharge{	AccountID:		account.AccountID,
+											FkBalanceID:	account.FkBalanceID,
+											Type:			TypeAdjustment,
+											Currency:		account.Currency,
+											Amount:			difference,
+											TenantID:		balance_parsed.TenantID,
+										}
+		res, err_add := s.addChargeCtx(ctx, tx, adjustment)
+		if err_add != nil {
+			err = err_add
+			return nil, err
+		}
+		err = s.addChargeEventCtx(ctx, tx, EventChargeAdjusted, *res)
+		if err != nil {
+			return nil, err
+		}
+		reconciliation.Status = ReconciliationCorrected
+		reconciliation.AdjustmentID = res.ID
+		childLogger.Warn().Str("account_id", account.AccountID).Int("charge_id", res.ID).Str("amount", difference.String()).Msg("Reconciliation adjustment charge")
+	}
+
+	if open == nil {
+		res, err_add := s.workerRepository.AddReconciliationCtx(ctx, tx, reconciliation)
+		if err_add != nil {
+			err = err_add
+			return nil, err
+		}
+		reconciliation = *res
+	} else {
+		err = s.workerRepository.UpdateReconciliationCtx(ctx, tx, reconciliation)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+
+	return &reconciliation, nil
+}
+
+func (s WorkerService) ListReconciliations(ctx context.Context, status string) (*[]core.Reconciliation, error){
+	childLogger.Debug().Msg("ListReconciliations")
+
+	_, root := tracing.Start(ctx, "Service.ListReconciliations")
+	defer func() {
+		root.End(nil)
+	}()
+
+	return s.workerRepository.ListReconciliations(ctx, status)
+}
+
+// StartReconciliation runs Reconcile every interval until the context is cancelled
+func (s WorkerService) StartReconciliation(ctx context.Context, interval time.Duration, autoCorrect bool) {
+	childLogger.Info().Msg("Start Reconciliation")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			childLogger.Info().Msg("Stop Reconciliation")
+			return
+		case <-ticker.C:
+			report, err := s.Reconcile(ctx, autoCorrect)
+			if err != nil {
+				childLogger.Error().Err(err).Msg("Error reconciling")
+				continue
+			}
+			childLogger.Info().Interface("report", report).Msg("Reconciliation done")
+		}
+	}
+}
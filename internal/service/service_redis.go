@@ -5,25 +5,38 @@ import (
 	"strconv"
 
 	"github.com/go-rest-balance-charges/internal/core"
-	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/tracing"
 
 )
 
 func (s WorkerService) GetCache(ctx context.Context, balanceCharge core.BalanceCharge) (*core.BalanceCharge, error){
 	childLogger.Debug().Msg("GetCache")
 
-	_, root := xray.BeginSubsegment(ctx, "Service.GetCache")
+	ctx, root := tracing.Start(ctx, "Service.GetCache")
 	defer func() {
-		root.Close(nil)
+		root.End(nil)
 	}()
 
+	// The keys of the cache carry no tenant, the account is checked on go-rest-balance
+	balance_parsed, err := s.balanceClient.GetBalance(ctx, balanceCharge.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if !balanceOfTenant(balance_parsed, balanceCharge.TenantID) {
+		return nil, erro.ErrNotFound
+	}
+
 	res, err := s.cache.Get(ctx, balanceCharge.AccountID)
 	if err != nil {
 		return nil, err
 	}
 	
-	item, _ := strconv.ParseFloat(res.(string), 64)
-	balanceCharge.Amount = item
+	item, err := strconv.ParseInt(res.(string), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	balanceCharge.Amount = core.NewMoney(item, balanceCharge.Currency)
 
 	return &balanceCharge, nil
 }
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/metrics"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/repository"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+const TypeReversal = "REVERSAL"
+
+func (s WorkerService) reverseChargeSaga() sagaDefinition {
+	return sagaDefinition{
+		name: "REVERSE_CHARGE",
+		steps: []sagaStep{
+			{ name: "insert_reversal", action: s.insertChargeStep, compensate: s.voidChargeStep },
+			{ name: "update_balance", action: s.updateBalanceStep, compensate: s.revertBalanceStep },
+		},
+	}
+}
+
+// Reverse refunds the charge (all that remains of it when no amount is given), creating a
+// reversal charge linked by reversal_of and moving the balance by the opposite amount
+func (s WorkerService) Reverse(ctx context.Context, balanceCharge core.BalanceCharge, chargeReversal core.ChargeReversal) (*core.BalanceCharge, error){
+	childLogger.Debug().Msg("Reverse")
+
+	ctx, root := tracing.Start(ctx, "Service.Reverse")
+	defer func() {
+		root.End(nil)
+	}()
+
+	original, err := s.workerRepository.Get(ctx, balanceCharge)
+	if err != nil {
+		return nil, err
+	}
+	tracing.SetChargeAttributes(ctx, root, *original)
+	if original.ReversalOf != 0 || original.Amount.IsZero() {
+		return nil, erro.ErrReversalInvalid
+	}
+
+	// Rows inserted before account_id was stored need the account from the request
+	accountID := original.AccountID
+	if accountID == "" {
+		accountID = chargeReversal.AccountID
+	}
+	if accountID == "" || (chargeReversal.AccountID != "" && chargeReversal.AccountID != accountID) {
+		return nil, erro.ErrNotFound
+	}
+
+	balance_parsed, err := s.balanceClient.GetBalance(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if balance_parsed.ID != original.FkBalanceID {
+		return nil, erro.ErrNotFound
+	}
+
+	// The amount is the refunded value, the reversal has the opposite sign of the charge
+	amount := core.NewMoney(0, original.Currency)
+	if chargeReversal.Amount != nil {
+		amount = *chargeReversal.Amount
+		err = amount.Bind(original.Currency)
+		if err != nil {
+			return nil, err
+		}
+		if amount.Sign() <= 0 {
+			return nil, erro.ErrInvalidAmount
+		}
+		if original.Amount.Sign() > 0 {
+			amount = amount.Neg()
+		}
+	}
+
+	reversal := core.BalanceCharge{	AccountID:		accountID,
+									FkBalanceID:	original.FkBalanceID,
+									Type:			TypeReversal,
+									Currency:		original.Currency,
+									Amount:			amount,
+									TenantID:		original.TenantID,
+									ReversalOf:		original.ID,
+								}
+
+	state := &sagaState{ BalanceCharge: reversal }
+	err = s.runSaga(ctx, s.reverseChargeSaga(), state)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.ChargeCreated(state.BalanceCharge)
+	return &state.BalanceCharge, nil
+}
+
+// checkReversalCtx locks the original charge so concurrent reversals are serialized and
+// checks the reversal against what remains of it. A zero amount means a full reversal
+// of the remaining value and is replaced by it
+func (s WorkerService) checkReversalCtx(ctx context.Context, tx repository.Tx, reversal *core.BalanceCharge) error {
+	original, err := s.workerRepository.GetForUpdateCtx(ctx, tx, core.BalanceCharge{ ID: reversal.ReversalOf })
+	if err != nil {
+		return err
+	}
+
+	reversed, err := s.workerRepository.SumReversalsCtx(ctx, tx, *original)
+	if err != nil {
+		return err
+	}
+
+	remaining, err := original.Amount.Add(*reversed)
+	if err != nil {
+		return err
+	}
+	if remaining.IsZero() {
+		return erro.ErrAlreadyReversed
+	}
+
+	if reversal.Amount.IsZero() {
+		reversal.Amount = remaining.Neg()
+		return nil
+	}
+
+	after, err := remaining.Add(reversal.Amount)
+	if err != nil {
+		return err
+	}
+	if after.Sign() != 0 && after.Sign() != remaining.Sign() {
+		return erro.ErrReversalExceeded
+	}
+
+	return nil
+}
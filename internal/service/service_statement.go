@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/tracing"
+
+)
+
+var statementMaxPeriod = 366 * 24 * time.Hour
+
+// Statement rebuilds the balances of the period backwards from the current balance:
+// closing = current - charges since to, opening = closing - charges of the period
+func (s WorkerService) Statement(ctx context.Context, accountID string, from time.Time, to time.Time) (*core.Statement, error){
+	childLogger.Debug().Msg("Statement")
+
+	ctx, root := tracing.Start(ctx, "Service.Statement")
+	defer func() {
+		root.End(nil)
+	}()
+	tracing.SetAccountAttributes(ctx, root, accountID)
+
+	if !from.Before(to) || to.Sub(from) > statementMaxPeriod {
+		return nil, erro.ErrStatementPeriod
+	}
+
+	balance_parsed, err := s.balanceClient.GetBalance(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	// Only the charges of the caller, the account of another tenant is not found
+	tenantID := core.TenantID(ctx)
+	if !balanceOfTenant(balance_parsed, tenantID) {
+		return nil, erro.ErrNotFound
+	}
+	currency := balance_parsed.Currency
+	current := core.NewMoney(balance_parsed.Amount.Units, currency)
+
+	since, err := s.workerRepository.SumChargesSince(ctx, core.BalanceCharge{ FkBalanceID: balance_parsed.ID, Currency: currency, TenantID: tenantID }, to)
+	if err != nil {
+		return nil, err
+	}
+	closing, err := current.Add(since.Neg())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.workerRepository.List(ctx, core.ChargeFilter{	FkBalanceID:	balance_parsed.ID,
+																	Currency:		currency,
+																	TenantID:		tenantID,
+																	From:			&from,
+																	To:				&to,
+																	Sort:			core.SortChargedAtAsc,
+																})
+	if err != nil {
+		return nil, err
+	}
+
+	period := core.NewMoney(0, currency)
+	for _, charge := range *res {
+		period, err = period.Add(charge.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opening, err := closing.Add(period.Neg())
+	if err != nil {
+		return nil, err
+	}
+
+	statement := core.Statement{	AccountID:		accountID,
+									Currency:		currency,
+									From:			from,
+									To:				to,
+									OpeningBalance:	opening,
+									ClosingBalance:	closing,
+									Lines:			[]core.StatementLine{},
+									Totals:			[]core.StatementTotal{},
+									GeneratedAt:	time.Now(),
+								}
+
+	running := opening
+	totals := map[string]*core.StatementTotal{}
+	for _, charge := range *res {
+		running, err = running.Add(charge.Amount)
+		if err != nil {
+			return nil, err
+		}
+		statement.Lines = append(statement.Lines, core.StatementLine{	ID:			charge.ID,
+																		Type:		charge.Type,
+																		ChargeAt:	charge.ChargeAt,
+																		Amount:		charge.Amount,
+																		Balance:	running,
+																		ReversalOf:	charge.ReversalOf,
+																	})
+
+		total, ok := totals[charge.Type]
+		if !ok {
+			total = &core.StatementTotal{ Type: charge.Type, Amount: core.NewMoney(0, currency) }
+			totals[charge.Type] = total
+		}
+		total.Count++
+		total.Amount, err = total.Amount.Add(charge.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, total := range totals {
+		statement.Totals = append(statement.Totals, *total)
+	}
+	sort.Slice(statement.Totals, func(i, j int) bool { return statement.Totals[i].Type < statement.Totals[j].Type })
+
+	return &statement, nil
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+
+	"github.com/go-rest-balance-charges/internal/circuitbreaker"
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+	"github.com/go-rest-balance-charges/internal/repository/cache"
+	"github.com/go-rest-balance-charges/internal/repository/memory"
+
+)
+
+// fakeBalanceClient is go-rest-balance in memory. lostUpdates makes the next updates
+// fail after being applied (the response is lost), then failUpdates makes the next ones
+// fail, updates counts the updates applied. crash panics in the next update, before or
+// after applying it, as the pod dying in the middle of the call
+type fakeBalanceClient struct {
+	mutex		sync.Mutex
+	balances	map[string]core.Balance
+	lostUpdates	int
+	failUpdates	int
+	updates		int
+	crash		string
+}
+
+const (
+	crashBefore	= "before"
+	crashAfter	= "after"
+)
+
+// errCrash is the panic of a crash
+var errCrash = errors.New("crash")
+
+func newFakeBalanceClient(balances ...core.Balance) *fakeBalanceClient {
+	f := &fakeBalanceClient{ balances: map[string]core.Balance{} }
+	for _, balance := range balances {
+		f.balances[balance.AccountID] = balance
+	}
+	return f
+}
+
+func (f *fakeBalanceClient) GetBalance(ctx context.Context, accountID string) (core.Balance, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	balance, ok := f.balances[accountID]
+	if !ok {
+		return core.Balance{}, erro.ErrNotFound
+	}
+	return balance, nil
+}
+
+func (f *fakeBalanceClient) UpdateBalance(ctx context.Context, accountID string, balance core.Balance) (core.Balance, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	crash := f.crash
+	f.crash = ""
+	if crash == crashBefore {
+		panic(errCrash)
+	}
+	if f.lostUpdates == 0 && f.failUpdates > 0 {
+		f.failUpdates--
+		return core.Balance{}, erro.ErrRemoteUnavailable
+	}
+	current, ok := f.balances[accountID]
+	if !ok {
+		return core.Balance{}, erro.ErrNotFound
+	}
+	current.Amount = balance.Amount
+	f.balances[accountID] = current
+	f.updates++
+	if crash == crashAfter {
+		panic(errCrash)
+	}
+	if f.lostUpdates > 0 {
+		f.lostUpdates--
+		return core.Balance{}, erro.ErrRemoteUnavailable
+	}
+	return current, nil
+}
+
+func (f *fakeBalanceClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeBalanceClient) amount(accountID string) int64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.balances[accountID].Amount.Units
+}
+
+func (f *fakeBalanceClient) updateCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.updates
+}
+
+func newBalance(id int, accountID string, tenantID string, units int64) core.Balance {
+	return core.Balance{	ID:			id,
+							AccountID:	accountID,
+							Currency:	"BRL",
+							Amount:		core.NewMoney(units, "BRL"),
+							TenantID:	tenantID,
+						}
+}
+
+// newTestService runs the service on the memory repository and cache
+func newTestService(balanceClient *fakeBalanceClient) (*WorkerService, db_memory.WorkerRepository) {
+	repo := db_memory.NewWorkerRepository()
+	return NewWorkerService(repo, balanceClient, circuitbreaker.NewRegistry(), cache_redis.NewMemoryCache(context.Background()), nil, false), repo
+}
+
+// testContext has a segment, so the spans of the default tracer (X-Ray) can be started
+func testContext(t *testing.T) context.Context {
+	ctx, segment := xray.BeginSegment(context.Background(), t.Name())
+	t.Cleanup(func() { segment.Close(nil) })
+	return ctx
+}
+
+func TestWritesRejectAnotherTenant(t *testing.T) {
+	ctx := testContext(t)
+	balanceClient := newFakeBalanceClient(newBalance(1, "ACC-B", "TENANT-B", 10000))
+	s, repo := newTestService(balanceClient)
+
+	charge := core.BalanceCharge{ AccountID: "ACC-B", Type: "CRED", Currency: "BRL", Amount: core.NewMoney(100, "BRL"), TenantID: "TENANT-A" }
+	_, err := s.AddCtx(ctx, charge)
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("AddCtx of another tenant err = %v, want ErrNotFound", err)
+	}
+
+	charge.Type = "WITHDRAW"
+	charge.Amount = core.NewMoney(-100, "BRL")
+	_, err = s.WithdrawCbCtx(ctx, charge)
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("WithdrawCbCtx of another tenant err = %v, want ErrNotFound", err)
+	}
+
+	_, err = s.CreateHold(ctx, core.BalanceHold{ AccountID: "ACC-B", Currency: "BRL", Amount: core.NewMoney(100, "BRL"), TenantID: "TENANT-A" })
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("CreateHold of another tenant err = %v, want ErrNotFound", err)
+	}
+
+	_, err = s.GetCache(ctx, core.BalanceCharge{ AccountID: "ACC-B", TenantID: "TENANT-A" })
+	if !errors.Is(err, erro.ErrNotFound) {
+		t.Errorf("GetCache of another tenant err = %v, want ErrNotFound", err)
+	}
+
+	if balanceClient.amount("ACC-B") != 10000 || balanceClient.updates != 0 {
+		t.Errorf("balance = %d after %d updates, want 10000 untouched", balanceClient.amount("ACC-B"), balanceClient.updates)
+	}
+	charges, err := repo.List(ctx, core.ChargeFilter{ FkBalanceID: 1 })
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(*charges) != 0 {
+		t.Errorf("charges = %v, want none", *charges)
+	}
+	held, err := repo.SumActiveHolds(ctx, core.BalanceHold{ FkBalanceID: 1, Currency: "BRL" })
+	if err != nil || held.Units != 0 {
+		t.Errorf("SumActiveHolds = %v, %v, want 0", held, err)
+	}
+
+	// The tenant of the account goes through
+	charge = core.BalanceCharge{ AccountID: "ACC-B", Type: "CRED", Currency: "BRL", Amount: core.NewMoney(100, "BRL"), TenantID: "TENANT-B" }
+	_, err = s.AddCtx(ctx, charge)
+	if err != nil {
+		t.Errorf("AddCtx of the tenant: %v", err)
+	}
+}
+
+func TestCompensationFailureIsCommitted(t *testing.T) {
+	balanceClient := newFakeBalanceClient(newBalance(1, "ACC-001", "TENANT-001", 10000))
+	s, _ := newTestService(balanceClient)
+	charge := core.BalanceCharge{ AccountID: "ACC-001", Type: "CRED", Currency: "BRL", Amount: core.NewMoney(100, "BRL"), TenantID: "TENANT-001" }
+
+	// Compensated: nothing is left, the request can be retried
+	balanceClient.failUpdates = 1
+	ctx, committed := core.WithCommitTracking(testContext(t))
+	_, err := s.AddCtx(ctx, charge)
+	if !errors.Is(err, erro.ErrRemoteUnavailable) || committed() {
+		t.Errorf("AddCtx compensated err = %v, committed = %v, want ErrRemoteUnavailable not committed", err, committed())
+	}
+
+	// The update was applied but its response lost, and the revert fails
+	balanceClient.lostUpdates = 1
+	balanceClient.failUpdates = 1
+	ctx, committed = core.WithCommitTracking(testContext(t))
+	_, err = s.AddCtx(ctx, charge)
+	if !errors.Is(err, erro.ErrSagaCompensation) || !committed() {
+		t.Errorf("AddCtx not compensated err = %v, committed = %v, want ErrSagaCompensation committed", err, committed())
+	}
+	if balanceClient.amount("ACC-001") != 10100 {
+		t.Errorf("balance = %d, want 10100 (the update left applied)", balanceClient.amount("ACC-001"))
+	}
+}
@@ -0,0 +1,189 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OtelTracer traces with OpenTelemetry, the spans are exported in batches by OTLP/HTTP
+// (Endpoint, or the OTEL_EXPORTER_OTLP_* variables) or written to stdout
+type OtelTracer struct {
+	provider		*sdktrace.TracerProvider
+	tracer			trace.Tracer
+	propagator		propagation.TextMapPropagator
+}
+
+type otelSpan struct {
+	span		trace.Span
+}
+
+func (s otelSpan) SetAttribute(key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	case float64:
+		s.span.SetAttributes(attribute.Float64(key, v))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprintf("%v", v)))
+	}
+}
+
+func (s otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+func NewOtelTracer(ctx context.Context, config Config) (*OtelTracer, error) {
+	childLogger.Debug().Str("exporter", config.Exporter).Msg("NewOtelTracer")
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case "", "otlp":
+		options := []otlptracehttp.Option{}
+		// The endpoint is host:port or a URL (http://collector:4318), http is insecure
+		endpoint := config.Endpoint
+		if strings.HasPrefix(endpoint, "http://") {
+			config.Insecure = true
+		}
+		endpoint = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://"), "/")
+		if endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("otel exporter %s not supported", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ServiceName == "" {
+		config.ServiceName = "go-rest-balance-charges"
+	}
+	if config.SampleRatio <= 0 || config.SampleRatio > 1 {
+		config.SampleRatio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", config.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	propagator := propagation.TraceContext{}
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	return &OtelTracer{
+		provider:	provider,
+		tracer:		provider.Tracer("github.com/go-rest-balance-charges"),
+		propagator:	propagator,
+	}, nil
+}
+
+func (t *OtelTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, otelSpan{ span: span }
+}
+
+// Handler continues the trace of the traceparent of the request, if any
+func (t *OtelTracer) Handler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ctx := t.propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := t.tracer.Start(ctx, name,
+									trace.WithSpanKind(trace.SpanKindServer),
+									trace.WithAttributes(	attribute.String("http.method", req.Method),
+															attribute.String("http.target", req.URL.Path)))
+		defer span.End()
+
+		recorder := &statusRecorder{ ResponseWriter: rw, status: http.StatusOK }
+		next.ServeHTTP(recorder, req.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// Client starts a client span per request and sends its traceparent
+func (t *OtelTracer) Client(client *http.Client) *http.Client {
+	traced := *client
+	traced.Transport = otelTransport{ base: baseTransport(client), tracer: t }
+	return &traced
+}
+
+func (t *OtelTracer) Inject(ctx context.Context, header http.Header) {
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Shutdown exports the spans not sent yet
+func (t *OtelTracer) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+type otelTransport struct {
+	base		http.RoundTripper
+	tracer		*OtelTracer
+}
+
+func (o otelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := o.tracer.tracer.Start(req.Context(), "HTTP " + req.Method,
+										trace.WithSpanKind(trace.SpanKindClient),
+										trace.WithAttributes(	attribute.String("http.method", req.Method),
+																attribute.String("http.url", req.URL.String())))
+	defer span.End()
+
+	req = req.Clone(ctx)
+	o.tracer.Inject(ctx, req.Header)
+
+	resp, err := o.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
+
+// statusRecorder keeps the status answered by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status		int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
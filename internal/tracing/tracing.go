@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-rest-balance-charges/internal/core"
+)
+
+var childLogger = log.With().Str("tracing", "tracing").Logger()
+
+// Span is a unit of work of a trace, an X-Ray subsegment or an OpenTelemetry span
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End(err error)
+}
+
+// Tracer is the tracing backend, XRayTracer or OtelTracer
+type Tracer interface {
+	// Start begins a span child of the one of ctx
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// Handler traces the requests of a route, the root span is named name
+	Handler(name string, next http.Handler) http.Handler
+	// Client traces the outbound calls of client, they carry the W3C traceparent header
+	Client(client *http.Client) *http.Client
+	// Inject writes the W3C traceparent of the span of ctx
+	Inject(ctx context.Context, header http.Header)
+	Shutdown(ctx context.Context) error
+}
+
+// Config of the tracer: xray (default) or otel, exported with otlp (OTLP/HTTP) or stdout
+type Config struct {
+	Tracer			string
+	Exporter		string
+	Endpoint		string
+	Insecure		bool
+	ServiceName		string
+	SampleRatio		float64
+}
+
+var tracer Tracer = XRayTracer{}
+
+// NewTracer builds the tracer by name (xray or otel)
+func NewTracer(ctx context.Context, config Config) (Tracer, error) {
+	childLogger.Debug().Str("tracer", config.Tracer).Msg("NewTracer")
+
+	switch config.Tracer {
+	case "", "xray":
+		return XRayTracer{}, nil
+	case "otel":
+		return NewOtelTracer(ctx, config)
+	}
+	return nil, fmt.Errorf("tracer %s not supported", config.Tracer)
+}
+
+// SetTracer changes the tracer of Start, Handler and Client, it is set once at startup
+func SetTracer(t Tracer) {
+	tracer = t
+}
+
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	return tracer.Start(ctx, name)
+}
+
+func Handler(name string, next http.Handler) http.Handler {
+	return tracer.Handler(name, next)
+}
+
+func Client(client *http.Client) *http.Client {
+	return tracer.Client(client)
+}
+
+func Shutdown(ctx context.Context) error {
+	return tracer.Shutdown(ctx)
+}
+
+// SetChargeAttributes adds the account, tenant and type of the charge to the span, the tenant
+// of the caller when the charge has none
+func SetChargeAttributes(ctx context.Context, span Span, balanceCharge core.BalanceCharge) {
+	if balanceCharge.TenantID != "" {
+		ctx = core.WithTenantID(ctx, balanceCharge.TenantID)
+	}
+	SetAccountAttributes(ctx, span, balanceCharge.AccountID)
+	if balanceCharge.Type != "" {
+		span.SetAttribute("type_charge", balanceCharge.Type)
+	}
+}
+
+// SetAccountAttributes adds the account and the tenant of the caller to the span
+func SetAccountAttributes(ctx context.Context, span Span, accountID string) {
+	if accountID != "" {
+		span.SetAttribute("account_id", accountID)
+	}
+	if tenantID := core.TenantID(ctx); tenantID != "" {
+		span.SetAttribute("tenant_id", tenantID)
+	}
+}
+
+// injectTransport adds the traceparent of the request context to the outbound requests
+type injectTransport struct {
+	base		http.RoundTripper
+	tracer		Tracer
+}
+
+func (t injectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	t.tracer.Inject(req.Context(), req.Header)
+	return t.base.RoundTrip(req)
+}
+
+func baseTransport(client *http.Client) http.RoundTripper {
+	if client.Transport != nil {
+		return client.Transport
+	}
+	return http.DefaultTransport
+}
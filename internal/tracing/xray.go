@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// XRayTracer traces with AWS X-Ray, the spans are subsegments of the segment of the request
+type XRayTracer struct{}
+
+type xraySpan struct {
+	segment		*xray.Segment
+}
+
+func (s xraySpan) SetAttribute(key string, value interface{}) {
+	if s.segment == nil {
+		return
+	}
+	// The annotations are indexed, they accept only string, number and bool
+	switch value.(type) {
+	case string, bool, int, int32, int64, uint32, uint64, float32, float64:
+		s.segment.AddAnnotation(key, value)
+	default:
+		s.segment.AddAnnotation(key, fmt.Sprintf("%v", value))
+	}
+}
+
+func (s xraySpan) End(err error) {
+	s.segment.Close(err)
+}
+
+func (XRayTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx, segment := xray.BeginSubsegment(ctx, name)
+	return ctx, xraySpan{ segment: segment }
+}
+
+func (XRayTracer) Handler(name string, next http.Handler) http.Handler {
+	return xray.Handler(xray.NewFixedSegmentNamer(name), next)
+}
+
+// Client sends X-Amzn-Trace-Id (xray.Client) and the equivalent traceparent
+func (t XRayTracer) Client(client *http.Client) *http.Client {
+	client = xray.Client(client)
+	client.Transport = injectTransport{ base: baseTransport(client), tracer: t }
+	return client
+}
+
+// Inject converts the X-Ray trace id (1-5759e988-bd862e3fe1be46a994272793) into the W3C
+// trace id (5759e988bd862e3fe1be46a994272793), the parent is the current (sub)segment
+func (XRayTracer) Inject(ctx context.Context, header http.Header) {
+	segment := xray.GetSegment(ctx)
+	if segment == nil || segment.ParentSegment == nil {
+		return
+	}
+	trace_id := strings.ReplaceAll(strings.TrimPrefix(segment.ParentSegment.TraceID, "1-"), "-", "")
+	if len(trace_id) != 32 || len(segment.ID) != 16 {
+		return
+	}
+	flags := "00"
+	if segment.ParentSegment.Sampled {
+		flags = "01"
+	}
+	header.Set("traceparent", "00-" + trace_id + "-" + segment.ID + "-" + flags)
+}
+
+func (XRayTracer) Shutdown(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,174 @@
+package validation
+
+import (
+	"io"
+	"sort"
+	"regexp"
+
+	"github.com/go-rest-balance-charges/internal/core"
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+const (
+	OperationAdd		= "add"
+	OperationWithdraw	= "withdraw"
+
+	// Sign of the amount of a type_charge
+	SignCredit			= 1
+	SignDebit			= -1
+)
+
+// Size of the account_id and tenant_id columns
+const maxIdLength = 200
+
+var currencyFormat = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ChargeRules are the rules of the payload of a charge operation: the fields the client
+// may send, the type_charge allowed with the sign of their amount, the max absolute
+// amount (decimal in the currency of the charge, empty for no limit) and the tenant
+type ChargeRules struct {
+	Fields			[]string
+	Types			map[string]int
+	MaxAmount		string
+	TenantRequired	bool
+}
+
+var chargeFields = []string{ "account_id", "type_charge", "currency", "amount", "tenant_id" }
+
+// ChargeOperations are the rules of POST /add and POST /withdraw. The types created by the
+// service itself (REVERSAL, CAPTURE, ADJUSTMENT) are not accepted from the clients
+var ChargeOperations = map[string]ChargeRules{
+	OperationAdd: {
+		Fields:			chargeFields,
+		Types:			map[string]int{ "CRED": SignCredit, "DEBITO": SignDebit, "FEE": SignDebit },
+		MaxAmount:		"1000000",
+		TenantRequired:	true,
+	},
+	OperationWithdraw: {
+		Fields:			chargeFields,
+		Types:			map[string]int{ "WITHDRAW": SignDebit },
+		MaxAmount:		"1000000",
+		TenantRequired:	true,
+	},
+}
+
+// SetMaxAmount changes the max amount of every charge operation
+func SetMaxAmount(amount string) error {
+	if amount != "" {
+		if _, err := core.ParseMoney(amount, ""); err != nil {
+			return err
+		}
+	}
+	for operation, rules := range ChargeOperations {
+		rules.MaxAmount = amount
+		ChargeOperations[operation] = rules
+	}
+	return nil
+}
+
+// DecodeCharge reads the charge of the operation and checks its rules, the field errors
+// are returned together as *Errors. tenantID is the tenant of the authenticated caller, the
+// tenant_id of the payload is then optional
+func DecodeCharge(body io.Reader, operation string, tenantID string) (core.BalanceCharge, error) {
+	balanceCharge := core.BalanceCharge{}
+
+	rules, ok := ChargeOperations[operation]
+	if !ok {
+		return balanceCharge, erro.ErrFunctionNotImpl
+	}
+	if tenantID != "" {
+		rules.TenantRequired = false
+	}
+
+	errs := &Errors{}
+	err := decode(body, rules.Fields, &balanceCharge, errs)
+	if err != nil {
+		return balanceCharge, err
+	}
+	// The rules need the decoded values
+	if !errs.has(CodeInvalid) {
+		ValidateCharge(balanceCharge, rules, errs)
+	}
+
+	return balanceCharge, errs.result()
+}
+
+// ValidateCharge adds to errs every rule the charge breaks
+func ValidateCharge(balanceCharge core.BalanceCharge, rules ChargeRules, errs *Errors) {
+	switch {
+	case balanceCharge.AccountID == "":
+		errs.add("account_id", CodeRequired, "Campo obrigatório")
+	case len(balanceCharge.AccountID) > maxIdLength:
+		errs.add("account_id", CodeLength, "Tamanho máximo de 200 caracteres")
+	}
+
+	switch {
+	case rules.TenantRequired && balanceCharge.TenantID == "":
+		errs.add("tenant_id", CodeRequired, "Campo obrigatório")
+	case len(balanceCharge.TenantID) > maxIdLength:
+		errs.add("tenant_id", CodeLength, "Tamanho máximo de 200 caracteres")
+	}
+
+	currency_ok := false
+	switch {
+	case balanceCharge.Currency == "":
+		errs.add("currency", CodeRequired, "Campo obrigatório")
+	case !currencyFormat.MatchString(balanceCharge.Currency):
+		errs.add("currency", CodeFormat, "Código de moeda com 3 letras maiúsculas (ISO 4217)")
+	case !core.ValidCurrency(balanceCharge.Currency):
+		errs.add("currency", CodeNotAllowed, erro.ErrInvalidCurrency.Error())
+	default:
+		currency_ok = true
+	}
+
+	sign, type_ok := rules.Types[balanceCharge.Type]
+	switch {
+	case balanceCharge.Type == "":
+		errs.add("type_charge", CodeRequired, "Campo obrigatório")
+	case !type_ok:
+		errs.add("type_charge", CodeNotAllowed, "Tipo não permitido nessa operação (" + allowedTypes(rules) + ")")
+	}
+
+	amount := balanceCharge.Amount
+	switch {
+	case amount.IsZero():
+		errs.add("amount", CodeRequired, "Valor diferente de zero obrigatório")
+	case type_ok && amount.Sign() != sign && sign == SignCredit:
+		errs.add("amount", CodeSign, "Valor deve ser positivo para " + balanceCharge.Type)
+	case type_ok && amount.Sign() != sign && sign == SignDebit:
+		errs.add("amount", CodeSign, "Valor deve ser negativo para " + balanceCharge.Type)
+	case currency_ok && rules.MaxAmount != "":
+		max, err := core.ParseMoney(rules.MaxAmount, balanceCharge.Currency)
+		if err != nil {
+			childLogger.Error().Err(err).Str("max_amount", rules.MaxAmount).Msg("Invalid max amount")
+			return
+		}
+		absolute := amount
+		if absolute.Sign() < 0 {
+			absolute = absolute.Neg()
+		}
+		if absolute.Cmp(max) > 0 {
+			errs.add("amount", CodeMax, "Valor acima do limite de " + max.String() + " " + balanceCharge.Currency)
+		}
+	}
+}
+
+func allowedTypes(rules ChargeRules) string {
+	types := ""
+	for _, type_charge := range sortedTypes(rules.Types) {
+		if types != "" {
+			types = types + ", "
+		}
+		types = types + type_charge
+	}
+	return types
+}
+
+func sortedTypes(types map[string]int) []string {
+	list := []string{}
+	for type_charge := range types {
+		list = append(list, type_charge)
+	}
+	sort.Strings(list)
+	return list
+}
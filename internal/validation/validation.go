@@ -0,0 +1,122 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+)
+
+var childLogger = log.With().Str("validation", "validation").Logger()
+
+// Codes of the field errors
+const (
+	CodeRequired		= "required"
+	CodeUnknown			= "unknown_field"
+	CodeInvalid			= "invalid"
+	CodeFormat			= "format"
+	CodeNotAllowed		= "not_allowed"
+	CodeSign			= "sign"
+	CodeMax				= "max"
+	CodeLength			= "length"
+)
+
+type FieldError struct {
+	Field		string	`json:"field"`
+	Code		string	`json:"code"`
+	Message		string	`json:"message"`
+}
+
+// Errors is every field error of a payload, it unwraps to erro.ErrValidation
+type Errors struct {
+	Message		string			`json:"message"`
+	Errors		[]FieldError	`json:"errors"`
+}
+
+func (e *Errors) Error() string {
+	return e.Message
+}
+
+func (e *Errors) Unwrap() error {
+	return erro.ErrValidation
+}
+
+// Details are the field errors of the problem answered by the handlers
+func (e *Errors) Details() interface{} {
+	return e.Errors
+}
+
+// has tells whether a field error with the code was added
+func (e *Errors) has(code string) bool {
+	for _, field_error := range e.Errors {
+		if field_error.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Errors) add(field string, code string, message string) {
+	e.Errors = append(e.Errors, FieldError{ Field: field, Code: code, Message: message })
+}
+
+// result returns nil when no error was added
+func (e *Errors) result() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	e.Message = erro.ErrValidation.Error()
+	return e
+}
+
+// decode reads a JSON object, adding an error for each field not in allowed, and decodes it into v.
+// A body that is not a JSON object is erro.ErrUnmarshal, everything else a field error
+func decode(body io.Reader, allowed []string, v interface{}, errs *Errors) error {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return erro.ErrUnmarshal
+	}
+
+	fields := map[string]json.RawMessage{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	err = decoder.Decode(&fields)
+	if err != nil || decoder.More() {
+		return erro.ErrUnmarshal
+	}
+
+	known := map[string]bool{}
+	for _, field := range allowed {
+		known[field] = true
+	}
+	unknown := []string{}
+	for field := range fields {
+		if !known[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	sort.Strings(unknown)
+	for _, field := range unknown {
+		errs.add(field, CodeUnknown, "Campo não permitido")
+	}
+
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		var err_type *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &err_type):
+			errs.add(err_type.Field, CodeInvalid, "Tipo inválido, esperado " + err_type.Type.String())
+		case errors.Is(err, erro.ErrInvalidAmount), errors.Is(err, erro.ErrAmountScale), errors.Is(err, erro.ErrAmountOverflow):
+			errs.add("amount", CodeInvalid, err.Error())
+		default:
+			childLogger.Debug().Err(err).Msg("decode")
+			return erro.ErrUnmarshal
+		}
+	}
+
+	return nil
+}
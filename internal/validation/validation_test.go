@@ -0,0 +1,190 @@
+package validation
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-rest-balance-charges/internal/erro"
+
+)
+
+// fieldErrors are the field and code of each error, in order
+func fieldErrors(err error) []string {
+	var errs *Errors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+	list := []string{}
+	for _, field_error := range errs.Errors {
+		list = append(list, field_error.Field + ":" + field_error.Code)
+	}
+	return list
+}
+
+func TestDecodeCharge(t *testing.T) {
+	long_id := strings.Repeat("A", maxIdLength + 1)
+
+	tests := []struct {
+		name		string
+		operation	string
+		tenantID	string
+		body		string
+		want		[]string
+	}{
+		{ "add CRED", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "10.50", "tenant_id": "TENANT-001"}`, nil },
+		{ "add DEBITO", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "DEBITO", "currency": "BRL", "amount": "-10.50", "tenant_id": "TENANT-001"}`, nil },
+		{ "add FEE", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "FEE", "currency": "BRL", "amount": -1, "tenant_id": "TENANT-001"}`, nil },
+		{ "add WITHDRAW", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "WITHDRAW", "currency": "BRL", "amount": "-1", "tenant_id": "TENANT-001"}`, []string{ "type_charge:not_allowed" } },
+		{ "add REVERSAL", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "REVERSAL", "currency": "BRL", "amount": "1", "tenant_id": "TENANT-001"}`, []string{ "type_charge:not_allowed" } },
+		{ "add CAPTURE", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CAPTURE", "currency": "BRL", "amount": "-1", "tenant_id": "TENANT-001"}`, []string{ "type_charge:not_allowed" } },
+		{ "add ADJUSTMENT", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "ADJUSTMENT", "currency": "BRL", "amount": "1", "tenant_id": "TENANT-001"}`, []string{ "type_charge:not_allowed" } },
+		{ "withdraw WITHDRAW", OperationWithdraw, "", `{"account_id": "ACC-001", "type_charge": "WITHDRAW", "currency": "BRL", "amount": "-10.50", "tenant_id": "TENANT-001"}`, nil },
+		{ "withdraw CRED", OperationWithdraw, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "-1", "tenant_id": "TENANT-001"}`, []string{ "type_charge:not_allowed" } },
+		{ "withdraw DEBITO", OperationWithdraw, "", `{"account_id": "ACC-001", "type_charge": "DEBITO", "currency": "BRL", "amount": "-1", "tenant_id": "TENANT-001"}`, []string{ "type_charge:not_allowed" } },
+		{ "withdraw FEE", OperationWithdraw, "", `{"account_id": "ACC-001", "type_charge": "FEE", "currency": "BRL", "amount": "-1", "tenant_id": "TENANT-001"}`, []string{ "type_charge:not_allowed" } },
+		{ "CRED negative", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "-1", "tenant_id": "TENANT-001"}`, []string{ "amount:sign" } },
+		{ "DEBITO positive", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "DEBITO", "currency": "BRL", "amount": "1", "tenant_id": "TENANT-001"}`, []string{ "amount:sign" } },
+		{ "WITHDRAW positive", OperationWithdraw, "", `{"account_id": "ACC-001", "type_charge": "WITHDRAW", "currency": "BRL", "amount": "1", "tenant_id": "TENANT-001"}`, []string{ "amount:sign" } },
+		{ "amount zero", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "0.00", "tenant_id": "TENANT-001"}`, []string{ "amount:required" } },
+		{ "every field missing", OperationAdd, "", `{}`, []string{ "account_id:required", "tenant_id:required", "currency:required", "type_charge:required", "amount:required" } },
+		{ "tenant of the caller", OperationAdd, "TENANT-001", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "1"}`, nil },
+		{ "ids too long", OperationAdd, "", `{"account_id": "` + long_id + `", "type_charge": "CRED", "currency": "BRL", "amount": "1", "tenant_id": "` + long_id + `"}`, []string{ "account_id:length", "tenant_id:length" } },
+		{ "currency lower case", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "brl", "amount": "1", "tenant_id": "TENANT-001"}`, []string{ "currency:format" } },
+		{ "currency not ISO 4217", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "XXX", "amount": "1", "tenant_id": "TENANT-001"}`, []string{ "currency:not_allowed" } },
+		{ "amount at max", OperationWithdraw, "", `{"account_id": "ACC-001", "type_charge": "WITHDRAW", "currency": "BRL", "amount": "-1000000.00", "tenant_id": "TENANT-001"}`, nil },
+		{ "amount above max", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "1000000.01", "tenant_id": "TENANT-001"}`, []string{ "amount:max" } },
+		{ "negative above max", OperationWithdraw, "", `{"account_id": "ACC-001", "type_charge": "WITHDRAW", "currency": "BRL", "amount": "-1000000.01", "tenant_id": "TENANT-001"}`, []string{ "amount:max" } },
+		{ "JPY above max", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "JPY", "amount": "1000001", "tenant_id": "TENANT-001"}`, []string{ "amount:max" } },
+		{ "amount scale", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "1.001", "tenant_id": "TENANT-001"}`, []string{ "amount:invalid" } },
+		{ "JPY scale", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "JPY", "amount": "1.5", "tenant_id": "TENANT-001"}`, []string{ "amount:invalid" } },
+		{ "amount invalid", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "abc", "tenant_id": "TENANT-001"}`, []string{ "amount:invalid" } },
+		{ "amount overflow", OperationAdd, "", `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "99999999999999999999", "tenant_id": "TENANT-001"}`, []string{ "amount:invalid" } },
+		{ "account_id type", OperationAdd, "", `{"account_id": 1, "type_charge": "CRED", "currency": "BRL", "amount": "1", "tenant_id": "TENANT-001"}`, []string{ "account_id:invalid" } },
+		{ "unknown fields", OperationAdd, "", `{"id": 1, "account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "1", "tenant_id": "TENANT-001", "fk_balance_id": 1}`, []string{ "fk_balance_id:unknown_field", "id:unknown_field" } },
+		{ "unknown field and rules", OperationAdd, "", `{"reversal_of": 1, "account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "-1", "tenant_id": "TENANT-001"}`, []string{ "reversal_of:unknown_field", "amount:sign" } },
+	}
+	for _, tt := range tests {
+		_, err := DecodeCharge(strings.NewReader(tt.body), tt.operation, tt.tenantID)
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: err = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, erro.ErrValidation) {
+			t.Errorf("%s: err = %v, want ErrValidation", tt.name, err)
+			continue
+		}
+		if got := fieldErrors(err); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: errors = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeChargeValues(t *testing.T) {
+	body := `{"account_id": "ACC-001", "type_charge": "DEBITO", "currency": "BHD", "amount": "-1.234", "tenant_id": "TENANT-001"}`
+	balanceCharge, err := DecodeCharge(strings.NewReader(body), OperationAdd, "")
+	if err != nil {
+		t.Fatalf("DecodeCharge: %v", err)
+	}
+	if balanceCharge.AccountID != "ACC-001" || balanceCharge.Type != "DEBITO" || balanceCharge.TenantID != "TENANT-001" ||
+		balanceCharge.Amount.Units != -1234 || balanceCharge.Amount.Currency != "BHD" {
+		t.Errorf("DecodeCharge = %+v", balanceCharge)
+	}
+}
+
+func TestDecodeChargeMessages(t *testing.T) {
+	tests := []struct {
+		operation	string
+		body		string
+		want		string
+	}{
+		{ OperationAdd, `{"account_id": "ACC-001", "type_charge": "WITHDRAW", "currency": "BRL", "amount": "-1", "tenant_id": "T"}`, "Tipo não permitido nessa operação (CRED, DEBITO, FEE)" },
+		{ OperationWithdraw, `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "-1", "tenant_id": "T"}`, "Tipo não permitido nessa operação (WITHDRAW)" },
+		{ OperationAdd, `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "-1", "tenant_id": "T"}`, "Valor deve ser positivo para CRED" },
+		{ OperationWithdraw, `{"account_id": "ACC-001", "type_charge": "WITHDRAW", "currency": "BRL", "amount": "1", "tenant_id": "T"}`, "Valor deve ser negativo para WITHDRAW" },
+		{ OperationAdd, `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "1000000.01", "tenant_id": "T"}`, "Valor acima do limite de 1000000.00 BRL" },
+		{ OperationAdd, `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "XXX", "amount": "1", "tenant_id": "T"}`, erro.ErrInvalidCurrency.Error() },
+	}
+	for _, tt := range tests {
+		_, err := DecodeCharge(strings.NewReader(tt.body), tt.operation, "")
+		var errs *Errors
+		if !errors.As(err, &errs) || len(errs.Errors) != 1 {
+			t.Errorf("%s: err = %v, want one field error", tt.body, err)
+			continue
+		}
+		if errs.Errors[0].Message != tt.want {
+			t.Errorf("%s: message = %q, want %q", tt.body, errs.Errors[0].Message, tt.want)
+		}
+	}
+}
+
+func TestDecodeChargeErrors(t *testing.T) {
+	tests := []struct {
+		operation	string
+		body		string
+		err			error
+	}{
+		{ "transfer", `{}`, erro.ErrFunctionNotImpl },
+		{ OperationAdd, ``, erro.ErrUnmarshal },
+		{ OperationAdd, `[]`, erro.ErrUnmarshal },
+		{ OperationAdd, `{"account_id": "ACC-001"`, erro.ErrUnmarshal },
+		{ OperationAdd, `{} {}`, erro.ErrUnmarshal },
+	}
+	for _, tt := range tests {
+		_, err := DecodeCharge(strings.NewReader(tt.body), tt.operation, "")
+		if !errors.Is(err, tt.err) {
+			t.Errorf("DecodeCharge(%q, %s) err = %v, want %v", tt.body, tt.operation, err, tt.err)
+		}
+	}
+}
+
+// The field errors are the details of the problem answered by the handlers
+func TestErrorsDetails(t *testing.T) {
+	_, err := DecodeCharge(strings.NewReader(`{"account_id": "ACC-001"}`), OperationWithdraw, "TENANT-001")
+	domain := erro.FromError(err)
+	if domain.Code != erro.ErrValidation.Code || domain.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("FromError = %+v, want VALIDATION 422", domain)
+	}
+	details, ok := domain.Details.([]FieldError)
+	if !ok || len(details) != 3 {
+		t.Fatalf("details = %#v, want the 3 field errors", domain.Details)
+	}
+	for _, detail := range details {
+		if detail.Code != CodeRequired || detail.Message == "" {
+			t.Errorf("detail = %+v, want required with a message", detail)
+		}
+	}
+}
+
+func TestSetMaxAmount(t *testing.T) {
+	defer SetMaxAmount(ChargeOperations[OperationAdd].MaxAmount)
+
+	if err := SetMaxAmount("abc"); err == nil {
+		t.Errorf("SetMaxAmount(abc) err = nil")
+	}
+
+	body := `{"account_id": "ACC-001", "type_charge": "CRED", "currency": "BRL", "amount": "100.01", "tenant_id": "TENANT-001"}`
+	if err := SetMaxAmount("100"); err != nil {
+		t.Fatalf("SetMaxAmount(100): %v", err)
+	}
+	for operation := range ChargeOperations {
+		if ChargeOperations[operation].MaxAmount != "100" {
+			t.Errorf("max amount of %s = %s, want 100", operation, ChargeOperations[operation].MaxAmount)
+		}
+	}
+	_, err := DecodeCharge(strings.NewReader(body), OperationAdd, "")
+	if got := fieldErrors(err); !reflect.DeepEqual(got, []string{ "amount:max" }) {
+		t.Errorf("errors = %v, want amount:max", got)
+	}
+
+	// No limit
+	SetMaxAmount("")
+	_, err = DecodeCharge(strings.NewReader(body), OperationAdd, "")
+	if err != nil {
+		t.Errorf("DecodeCharge without max err = %v", err)
+	}
+}